@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Number of bits of precision of a histogram: values below 2^subBucketBits
+// are counted exactly, larger ones in buckets as wide as 1/2^(bits-1) of
+// their value, which keeps 3 significant digits
+const subBucketBits = 11
+const subBuckets = 1 << subBucketBits
+const halfSubBuckets = subBuckets / 2
+
+// A histogram of latencies in microseconds with the layout of an HDR
+// histogram: a fixed relative precision over any range of values, for a
+// fixed memory per power of two.
+type histogram struct {
+	counts []uint64
+	total  uint64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{min: math.MaxInt64}
+}
+
+// Get the bucket of a value
+func bucketOf(value int64) int {
+	if value < subBuckets {
+		return int(value)
+	}
+	shift := bits.Len64(uint64(value)) - subBucketBits
+	top := int(value >> uint(shift))
+	return subBuckets + (shift-1)*halfSubBuckets + top - halfSubBuckets
+}
+
+// Get the largest value counted in a bucket
+func highestValueOf(bucket int) int64 {
+	if bucket < subBuckets {
+		return int64(bucket)
+	}
+	k := bucket - subBuckets
+	shift := uint(k/halfSubBuckets + 1)
+	top := int64(k%halfSubBuckets + halfSubBuckets)
+	return (top+1)<<shift - 1
+}
+
+// Count a value
+func (h *histogram) record(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	bucket := bucketOf(value)
+	if bucket >= len(h.counts) {
+		counts := make([]uint64, bucket+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[bucket]++
+	h.total++
+	h.sum += float64(value)
+	if value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+}
+
+// Add the values of another histogram
+func (h *histogram) merge(other *histogram) {
+	if len(other.counts) > len(h.counts) {
+		counts := make([]uint64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for bucket, count := range other.counts {
+		h.counts[bucket] += count
+	}
+	h.total += other.total
+	h.sum += other.sum
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+func (h *histogram) mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// Get the value below which a percentage of the values are
+//
+// Arguments:
+//		percentile: between 0 and 100
+// Returns:
+//		The highest value equivalent to it, 0 for an empty histogram
+func (h *histogram) valueAt(percentile float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(percentile / 100 * float64(h.total)))
+	if target == 0 {
+		target = 1
+	}
+	var seen uint64
+	for bucket, count := range h.counts {
+		seen += count
+		if seen >= target {
+			value := highestValueOf(bucket)
+			if value > h.max {
+				value = h.max
+			}
+			return value
+		}
+	}
+	return h.max
+}
+
+// A line of the percentile distribution of a histogram
+type distributionPoint struct {
+	Percentile float64 `json:"percentile"`
+	ValueUs    int64   `json:"valueUs"`
+	Count      uint64  `json:"count"`
+}
+
+// Get the percentile distribution of a histogram, like the output of HDR
+// histograms: ticks halving the distance to 100% at every step, 5 ticks
+// per halving, up to the maximum
+func (h *histogram) distribution() []distributionPoint {
+	var points []distributionPoint
+	if h.total == 0 {
+		return points
+	}
+	for halvings := 0; ; halvings++ {
+		step := math.Pow(0.5, float64(halvings)) / 5
+		for tick := 0; tick < 5; tick++ {
+			percentile := 100 * (1 - math.Pow(0.5, float64(halvings)) + float64(tick)*step)
+			value := h.valueAt(percentile)
+			points = append(points, distributionPoint{Percentile: percentile, ValueUs: value, Count: h.countAtOrBelow(value)})
+			if value >= h.max {
+				break
+			}
+		}
+		if points[len(points)-1].ValueUs >= h.max || halvings > 30 {
+			break
+		}
+	}
+	points = append(points, distributionPoint{Percentile: 100, ValueUs: h.max, Count: h.total})
+	return points
+}
+
+// Count the values in the buckets up to the one of a value
+func (h *histogram) countAtOrBelow(value int64) uint64 {
+	last := bucketOf(value)
+	var count uint64
+	for bucket := 0; bucket <= last && bucket < len(h.counts); bucket++ {
+		count += h.counts[bucket]
+	}
+	return count
+}
@@ -0,0 +1,521 @@
+// kvbench drives YCSB-style workloads against a cluster through the
+// client library, and reports the throughput and latency percentiles of
+// every operation over time.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"pa2/src/client"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Settings of a benchmark
+type benchConfig struct {
+	seeds        []string
+	workload     string
+	distribution string
+	records      int64
+	operations   int64
+	duration     time.Duration
+	threads      int
+	valueSizes   string
+	scanLength   int
+	interval     time.Duration
+	keyPrefix    string
+	load         bool
+	seed         int64
+	timeout      time.Duration
+	out          string
+}
+
+// Counts the latencies of the operations, since the start and since the
+// last report
+type recorder struct {
+	sync.Mutex
+	total          map[string]*histogram
+	interval       map[string]*histogram
+	errors         map[string]uint64
+	intervalErrors map[string]uint64
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		total:          make(map[string]*histogram),
+		interval:       make(map[string]*histogram),
+		errors:         make(map[string]uint64),
+		intervalErrors: make(map[string]uint64),
+	}
+}
+
+// Count an operation. Failed ones are counted apart from the latencies.
+func (r *recorder) record(op string, latency time.Duration, err error) {
+	r.Lock()
+	defer r.Unlock()
+	if err != nil {
+		r.errors[op]++
+		r.intervalErrors[op]++
+		return
+	}
+	for _, histograms := range []map[string]*histogram{r.total, r.interval} {
+		if histograms[op] == nil {
+			histograms[op] = newHistogram()
+		}
+		histograms[op].record(latency.Microseconds())
+	}
+}
+
+// Get the counts since the last report and start new ones
+func (r *recorder) takeInterval() (map[string]*histogram, map[string]uint64) {
+	r.Lock()
+	defer r.Unlock()
+	histograms, errors := r.interval, r.intervalErrors
+	r.interval = make(map[string]*histogram)
+	r.intervalErrors = make(map[string]uint64)
+	return histograms, errors
+}
+
+// Statistics of one operation over a period
+type opStats struct {
+	Count        uint64              `json:"count"`
+	Errors       uint64              `json:"errors"`
+	OpsPerSec    float64             `json:"opsPerSec"`
+	MeanUs       float64             `json:"meanUs"`
+	P50Us        int64               `json:"p50Us"`
+	P95Us        int64               `json:"p95Us"`
+	P99Us        int64               `json:"p99Us"`
+	P999Us       int64               `json:"p999Us"`
+	MaxUs        int64               `json:"maxUs"`
+	Distribution []distributionPoint `json:"distribution,omitempty"`
+}
+
+func statsOf(h *histogram, errors uint64, period time.Duration) opStats {
+	if h == nil {
+		h = newHistogram()
+	}
+	stats := opStats{
+		Count:  h.total,
+		Errors: errors,
+		MeanUs: h.mean(),
+		P50Us:  h.valueAt(50),
+		P95Us:  h.valueAt(95),
+		P99Us:  h.valueAt(99),
+		P999Us: h.valueAt(99.9),
+		MaxUs:  h.valueAt(100),
+	}
+	if period > 0 {
+		stats.OpsPerSec = float64(h.total) / period.Seconds()
+	}
+	return stats
+}
+
+// Statistics of every operation over one report interval
+type intervalResult struct {
+	ElapsedSec float64            `json:"elapsedSec"`
+	OpsPerSec  float64            `json:"opsPerSec"`
+	Ops        map[string]opStats `json:"ops"`
+}
+
+// Everything written to the result file
+type benchResult struct {
+	Workload     string             `json:"workload"`
+	Distribution string             `json:"distribution"`
+	Records      int64              `json:"records"`
+	Threads      int                `json:"threads"`
+	ValueSizes   string             `json:"valueSizes"`
+	DurationSec  float64            `json:"durationSec"`
+	OpsPerSec    float64            `json:"opsPerSec"`
+	Intervals    []intervalResult   `json:"intervals"`
+	Summary      map[string]opStats `json:"summary"`
+}
+
+// State shared by the workers of a benchmark
+type bench struct {
+	cfg      benchConfig
+	kv       *client.Client
+	workload workload
+	sizes    sizeChooser
+	// Keys inserted so far, and the next key to insert
+	inserted   int64
+	nextInsert int64
+	// Operations started so far
+	started int64
+}
+
+// State of one worker
+type worker struct {
+	r     *rand.Rand
+	keys  keyChooser
+	sizes sizeChooser
+	value []byte
+}
+
+func (b *bench) newWorker(id int) (*worker, error) {
+	w := &worker{
+		r:     rand.New(rand.NewSource(b.cfg.seed + int64(id))),
+		sizes: b.sizes.forWorker(),
+		value: make([]byte, b.sizes.max),
+	}
+	var err error
+	if w.keys, err = newKeyChooser(b.cfg.distribution, b.cfg.records); err != nil {
+		return nil, err
+	}
+	for i := range w.value {
+		w.value[i] = byte('a' + w.r.Intn(26))
+	}
+	return w, nil
+}
+
+func (b *bench) key(index int64) []byte {
+	return []byte(fmt.Sprintf("%s%d", b.cfg.keyPrefix, index))
+}
+
+// Pick the key of an operation among the inserted ones
+func (b *bench) pickKey(w *worker) []byte {
+	inserted := atomic.LoadInt64(&b.inserted)
+	if inserted < 1 {
+		inserted = 1
+	}
+	return b.key(w.keys.next(w.r, inserted))
+}
+
+// Insert the next key
+func (b *bench) insert(ctx context.Context, w *worker) error {
+	return b.insertKey(ctx, w, atomic.AddInt64(&b.nextInsert, 1)-1)
+}
+
+func (b *bench) insertKey(ctx context.Context, w *worker, index int64) error {
+	err := b.kv.Put(ctx, b.key(index), w.value[:w.sizes.next(w.r)], 0)
+	if err == nil {
+		atomic.AddInt64(&b.inserted, 1)
+	}
+	return err
+}
+
+// Run one operation. A read of a key that isn't there yet, since
+// inserts finish out of order, isn't an error.
+func (b *bench) do(ctx context.Context, w *worker, op string) error {
+	switch op {
+	case OP_READ:
+		_, _, err := b.kv.Get(ctx, b.pickKey(w))
+		if client.IsNotFound(err) {
+			return nil
+		}
+		return err
+	case OP_UPDATE:
+		return b.kv.Put(ctx, b.pickKey(w), w.value[:w.sizes.next(w.r)], 0)
+	case OP_INSERT:
+		return b.insert(ctx, w)
+	case OP_SCAN:
+		limit := uint32(1 + w.r.Intn(b.cfg.scanLength))
+		_, err := b.kv.Scan(ctx, b.pickKey(w), []byte(b.cfg.keyPrefix), limit)
+		return err
+	case OP_RMW:
+		key := b.pickKey(w)
+		if _, _, err := b.kv.Get(ctx, key); err != nil && !client.IsNotFound(err) {
+			return err
+		}
+		return b.kv.Put(ctx, key, w.value[:w.sizes.next(w.r)], 0)
+	}
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+// Run workers until each one is done
+//
+// Arguments:
+//		step: runs one operation of a worker, false once it has no more
+func (b *bench) runWorkers(step func(w *worker) bool) error {
+	workers := make([]*worker, b.cfg.threads)
+	for i := range workers {
+		var err error
+		if workers[i], err = b.newWorker(i); err != nil {
+			return err
+		}
+	}
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			for step(w) {
+			}
+		}(w)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Insert the records the workload reads
+func (b *bench) loadRecords() error {
+	rec := newRecorder()
+	start := time.Now()
+	err := b.runWorkers(func(w *worker) bool {
+		index := atomic.AddInt64(&b.nextInsert, 1) - 1
+		if index >= b.cfg.records {
+			return false
+		}
+		opStart := time.Now()
+		err := b.insertKey(context.Background(), w, index)
+		rec.record(OP_INSERT, time.Since(opStart), err)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	b.nextInsert = b.cfg.records
+	stats := statsOf(rec.total[OP_INSERT], rec.errors[OP_INSERT], time.Since(start))
+	fmt.Printf("loaded %d records in %.1fs, %.0f ops/s, %d errors\n", stats.Count, time.Since(start).Seconds(), stats.OpsPerSec, stats.Errors)
+	return nil
+}
+
+// Run the workload and report its statistics every interval
+func (b *bench) run() (*benchResult, error) {
+	rec := newRecorder()
+	result := &benchResult{
+		Workload:     b.cfg.workload,
+		Distribution: b.cfg.distribution,
+		Records:      b.cfg.records,
+		Threads:      b.cfg.threads,
+		ValueSizes:   b.cfg.valueSizes,
+	}
+
+	start := time.Now()
+	deadline := start.Add(b.cfg.duration)
+	done := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		ticker := time.NewTicker(b.cfg.interval)
+		defer ticker.Stop()
+		last := start
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+			}
+			now := time.Now()
+			histograms, errors := rec.takeInterval()
+			interval := intervalOf(histograms, errors, now.Sub(start), now.Sub(last))
+			result.Intervals = append(result.Intervals, interval)
+			printInterval(interval)
+			last = now
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	err := b.runWorkers(func(w *worker) bool {
+		if time.Now().After(deadline) {
+			return false
+		}
+		if b.cfg.operations > 0 && atomic.AddInt64(&b.started, 1) > b.cfg.operations {
+			return false
+		}
+		op := b.workload.pick(w.r)
+		opStart := time.Now()
+		err := b.do(context.Background(), w, op)
+		rec.record(op, time.Since(opStart), err)
+		return true
+	})
+	close(done)
+	<-reported
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := time.Since(start)
+	result.DurationSec = elapsed.Seconds()
+	result.Summary = make(map[string]opStats)
+	var total uint64
+	for op, h := range rec.total {
+		stats := statsOf(h, rec.errors[op], elapsed)
+		stats.Distribution = h.distribution()
+		result.Summary[op] = stats
+		total += h.total
+	}
+	for op, errors := range rec.errors {
+		if _, ok := result.Summary[op]; !ok {
+			result.Summary[op] = statsOf(nil, errors, elapsed)
+		}
+	}
+	result.OpsPerSec = float64(total) / elapsed.Seconds()
+	return result, nil
+}
+
+func intervalOf(histograms map[string]*histogram, errors map[string]uint64, elapsed time.Duration, period time.Duration) intervalResult {
+	interval := intervalResult{ElapsedSec: elapsed.Seconds(), Ops: make(map[string]opStats)}
+	var total uint64
+	for op, h := range histograms {
+		interval.Ops[op] = statsOf(h, errors[op], period)
+		total += h.total
+	}
+	for op, count := range errors {
+		if _, ok := interval.Ops[op]; !ok {
+			interval.Ops[op] = statsOf(nil, count, period)
+		}
+	}
+	if period > 0 {
+		interval.OpsPerSec = float64(total) / period.Seconds()
+	}
+	return interval
+}
+
+func sortedOps(ops map[string]opStats) []string {
+	var names []string
+	for op := range ops {
+		names = append(names, op)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func ms(us int64) string {
+	return fmt.Sprintf("%.2fms", float64(us)/1000)
+}
+
+func printInterval(interval intervalResult) {
+	var parts []string
+	for _, op := range sortedOps(interval.Ops) {
+		stats := interval.Ops[op]
+		part := fmt.Sprintf("%s %d p50 %s p99 %s", op, stats.Count, ms(stats.P50Us), ms(stats.P99Us))
+		if stats.Errors > 0 {
+			part += fmt.Sprintf(" errors %d", stats.Errors)
+		}
+		parts = append(parts, part)
+	}
+	fmt.Printf("%6.1fs %8.0f ops/s  %s\n", interval.ElapsedSec, interval.OpsPerSec, strings.Join(parts, " | "))
+}
+
+func printSummary(result *benchResult) {
+	fmt.Printf("\n%.0f ops/s over %.1fs\n", result.OpsPerSec, result.DurationSec)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "OP\tCOUNT\tERRORS\tOPS/S\tMEAN\tP50\tP95\tP99\tP99.9\tMAX\t")
+	for _, op := range sortedOps(result.Summary) {
+		s := result.Summary[op]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\t%s\t%s\t%s\t%s\t%s\t%s\t\n", op, s.Count, s.Errors, s.OpsPerSec,
+			ms(int64(s.MeanUs)), ms(s.P50Us), ms(s.P95Us), ms(s.P99Us), ms(s.P999Us), ms(s.MaxUs))
+	}
+	w.Flush()
+}
+
+// Write the result file, as CSV if its name ends in .csv, otherwise as
+// JSON. The CSV has a row per operation and interval, then a row per
+// operation for the whole run with "total" as elapsed time.
+func writeResult(path string, result *benchResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	w := csv.NewWriter(file)
+	_ = w.Write([]string{"elapsed_s", "op", "count", "errors", "ops_per_sec", "mean_us", "p50_us", "p95_us", "p99_us", "p999_us", "max_us"})
+	row := func(elapsed string, op string, s opStats) {
+		_ = w.Write([]string{elapsed, op, fmt.Sprint(s.Count), fmt.Sprint(s.Errors), fmt.Sprintf("%.1f", s.OpsPerSec),
+			fmt.Sprintf("%.1f", s.MeanUs), fmt.Sprint(s.P50Us), fmt.Sprint(s.P95Us), fmt.Sprint(s.P99Us),
+			fmt.Sprint(s.P999Us), fmt.Sprint(s.MaxUs)})
+	}
+	for _, interval := range result.Intervals {
+		for _, op := range sortedOps(interval.Ops) {
+			row(fmt.Sprintf("%.1f", interval.ElapsedSec), op, interval.Ops[op])
+		}
+	}
+	for _, op := range sortedOps(result.Summary) {
+		row("total", op, result.Summary[op])
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func main() {
+	var cfg benchConfig
+	seeds := flag.String("seeds", "127.0.0.1:3333", "comma separated addresses of nodes of the cluster")
+	flag.StringVar(&cfg.workload, "workload", "a", "YCSB core workload, a to f")
+	flag.StringVar(&cfg.distribution, "distribution", "", "key distribution: uniform, zipfian or latest, the one of the workload by default")
+	flag.Int64Var(&cfg.records, "records", 1000, "number of keys loaded before the run")
+	flag.Int64Var(&cfg.operations, "operations", 0, "stop after this many operations, 0 to only stop after -duration")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "length of the run")
+	flag.IntVar(&cfg.threads, "threads", 16, "number of concurrent clients")
+	flag.StringVar(&cfg.valueSizes, "value-sizes", "fixed:100", "value sizes: fixed:N, uniform:MIN-MAX or zipfian:MIN-MAX")
+	flag.IntVar(&cfg.scanLength, "scan-length", 100, "largest number of keys of a scan")
+	flag.DurationVar(&cfg.interval, "interval", time.Second, "time between two reports")
+	flag.StringVar(&cfg.keyPrefix, "key-prefix", "user", "prefix of the keys")
+	flag.BoolVar(&cfg.load, "load", true, "insert the records before the run")
+	flag.Int64Var(&cfg.seed, "seed", time.Now().UnixNano(), "seed of the random choices")
+	flag.DurationVar(&cfg.timeout, "timeout", 500*time.Millisecond, "time to wait for the first response of a node")
+	flag.StringVar(&cfg.out, "out", "", "result file, CSV if it ends in .csv, JSON otherwise")
+	flag.Parse()
+
+	if err := benchmark(cfg, *seeds); err != nil {
+		fmt.Fprintln(os.Stderr, "kvbench:", err)
+		os.Exit(1)
+	}
+}
+
+func benchmark(cfg benchConfig, seeds string) error {
+	for _, seed := range strings.Split(seeds, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			cfg.seeds = append(cfg.seeds, seed)
+		}
+	}
+	wl, ok := workloads[strings.ToLower(cfg.workload)]
+	if !ok {
+		return fmt.Errorf("unknown workload %q", cfg.workload)
+	}
+	if cfg.distribution == "" {
+		cfg.distribution = wl.distribution
+	}
+	sizes, err := parseSizes(cfg.valueSizes)
+	if err != nil {
+		return err
+	}
+	if cfg.records < 1 || cfg.threads < 1 || cfg.scanLength < 1 || cfg.interval <= 0 {
+		return fmt.Errorf("-records, -threads, -scan-length and -interval must be positive")
+	}
+
+	kv, err := client.New(cfg.seeds, client.Options{Timeout: cfg.timeout})
+	if err != nil {
+		return fmt.Errorf("could not reach the cluster: %v", err)
+	}
+	defer kv.Close()
+
+	b := &bench{cfg: cfg, kv: kv, workload: wl, sizes: sizes}
+	if cfg.load {
+		if err := b.loadRecords(); err != nil {
+			return err
+		}
+	} else {
+		b.inserted, b.nextInsert = cfg.records, cfg.records
+	}
+
+	fmt.Printf("workload %s, %s keys, %d threads, values %s\n", cfg.workload, cfg.distribution, cfg.threads, cfg.valueSizes)
+	result, err := b.run()
+	if err != nil {
+		return err
+	}
+	printSummary(result)
+	if cfg.out != "" {
+		return writeResult(cfg.out, result)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Operations of a workload
+const (
+	OP_READ   = "read"
+	OP_UPDATE = "update"
+	OP_INSERT = "insert"
+	OP_SCAN   = "scan"
+	OP_RMW    = "rmw"
+)
+
+// Key distributions
+const (
+	DIST_UNIFORM = "uniform"
+	DIST_ZIPFIAN = "zipfian"
+	DIST_LATEST  = "latest"
+)
+
+// Largest value a node accepts
+const maxValueBytes = 10000
+
+// Proportions of the operations of a workload, and the distribution of
+// the keys it picks unless one is given
+type workload struct {
+	mix          map[string]float64
+	distribution string
+}
+
+// The core workloads of YCSB
+var workloads = map[string]workload{
+	// Update heavy
+	"a": {map[string]float64{OP_READ: 0.5, OP_UPDATE: 0.5}, DIST_ZIPFIAN},
+	// Read mostly
+	"b": {map[string]float64{OP_READ: 0.95, OP_UPDATE: 0.05}, DIST_ZIPFIAN},
+	// Read only
+	"c": {map[string]float64{OP_READ: 1}, DIST_ZIPFIAN},
+	// Read the latest inserted keys
+	"d": {map[string]float64{OP_READ: 0.95, OP_INSERT: 0.05}, DIST_LATEST},
+	// Short ranges
+	"e": {map[string]float64{OP_SCAN: 0.95, OP_INSERT: 0.05}, DIST_ZIPFIAN},
+	// Read-modify-write
+	"f": {map[string]float64{OP_READ: 0.5, OP_RMW: 0.5}, DIST_ZIPFIAN},
+}
+
+// Operations in the order they are picked from, so a seed gives the same
+// sequence
+var opOrder = []string{OP_READ, OP_UPDATE, OP_INSERT, OP_SCAN, OP_RMW}
+
+// Pick the next operation of a workload
+func (w workload) pick(r *rand.Rand) string {
+	u := r.Float64()
+	last := OP_READ
+	for _, op := range opOrder {
+		share, ok := w.mix[op]
+		if !ok {
+			continue
+		}
+		if u < share {
+			return op
+		}
+		u -= share
+		last = op
+	}
+	return last
+}
+
+// Generator of the zipfian distribution of YCSB over [0, items), where
+// item 0 is the most popular. The number of items can grow, the zeta
+// constant is then extended incrementally.
+type zipfian struct {
+	theta float64
+	alpha float64
+	zeta2 float64
+	items int64
+	zetan float64
+	eta   float64
+}
+
+const zipfianTheta = 0.99
+
+func newZipfian(items int64) *zipfian {
+	z := &zipfian{theta: zipfianTheta, alpha: 1 / (1 - zipfianTheta)}
+	z.zeta2 = 1 + math.Pow(0.5, zipfianTheta)
+	z.grow(items)
+	return z
+}
+
+// Extend the distribution to a number of items
+func (z *zipfian) grow(items int64) {
+	if items <= z.items {
+		return
+	}
+	for i := z.items + 1; i <= items; i++ {
+		z.zetan += 1 / math.Pow(float64(i), z.theta)
+	}
+	z.items = items
+	z.eta = (1 - math.Pow(2/float64(items), 1-z.theta)) / (1 - z.zeta2/z.zetan)
+}
+
+// Pick an item among the first items ones
+func (z *zipfian) next(r *rand.Rand, items int64) int64 {
+	if items <= 1 {
+		return 0
+	}
+	z.grow(items)
+	u := r.Float64()
+	uz := u * z.zetan
+	if uz < 1 {
+		return 0
+	}
+	if uz < 1+math.Pow(0.5, z.theta) {
+		return 1
+	}
+	item := int64(float64(items) * math.Pow(z.eta*u-z.eta+1, z.alpha))
+	if item >= items {
+		item = items - 1
+	}
+	return item
+}
+
+// Picks the index of the key of the next operation
+type keyChooser interface {
+	// Pick among the keys inserted so far
+	next(r *rand.Rand, inserted int64) int64
+}
+
+type uniformKeys struct{}
+
+func (uniformKeys) next(r *rand.Rand, inserted int64) int64 {
+	return r.Int63n(inserted)
+}
+
+// Zipfian keys, with the popular ones spread over the key space by a hash
+// like the scrambled zipfian of YCSB
+type zipfianKeys struct {
+	zipf *zipfian
+}
+
+func (k zipfianKeys) next(r *rand.Rand, inserted int64) int64 {
+	item := k.zipf.next(r, inserted)
+	hash := fnv.New64a()
+	fmt.Fprint(hash, item)
+	return int64(hash.Sum64() % uint64(inserted))
+}
+
+// Zipfian keys where the most recently inserted ones are the most popular
+type latestKeys struct {
+	zipf *zipfian
+}
+
+func (k latestKeys) next(r *rand.Rand, inserted int64) int64 {
+	return inserted - 1 - k.zipf.next(r, inserted)
+}
+
+// Make a key chooser for one worker
+func newKeyChooser(distribution string, records int64) (keyChooser, error) {
+	switch distribution {
+	case DIST_UNIFORM:
+		return uniformKeys{}, nil
+	case DIST_ZIPFIAN:
+		return zipfianKeys{newZipfian(records)}, nil
+	case DIST_LATEST:
+		return latestKeys{newZipfian(records)}, nil
+	}
+	return nil, fmt.Errorf("unknown key distribution %q", distribution)
+}
+
+// Picks the size of the next value: "fixed:N", "uniform:MIN-MAX", or
+// "zipfian:MIN-MAX" where the smallest sizes are the most common
+type sizeChooser struct {
+	kind string
+	min  int
+	max  int
+	zipf *zipfian
+}
+
+// Parse a value size distribution
+func parseSizes(spec string) (sizeChooser, error) {
+	s := strings.SplitN(spec, ":", 2)
+	if len(s) != 2 {
+		return sizeChooser{}, fmt.Errorf("invalid value sizes %q, expected kind:size", spec)
+	}
+	sizes := sizeChooser{kind: s[0]}
+	var err error
+	switch sizes.kind {
+	case "fixed":
+		sizes.min, err = strconv.Atoi(s[1])
+		sizes.max = sizes.min
+	case "uniform", "zipfian":
+		bounds := strings.SplitN(s[1], "-", 2)
+		if len(bounds) != 2 {
+			return sizeChooser{}, fmt.Errorf("invalid value sizes %q, expected %s:MIN-MAX", spec, sizes.kind)
+		}
+		if sizes.min, err = strconv.Atoi(bounds[0]); err == nil {
+			sizes.max, err = strconv.Atoi(bounds[1])
+		}
+	default:
+		return sizeChooser{}, fmt.Errorf("unknown value size distribution %q", sizes.kind)
+	}
+	if err != nil {
+		return sizeChooser{}, fmt.Errorf("invalid value sizes %q: %v", spec, err)
+	}
+	if sizes.min < 0 || sizes.max < sizes.min || sizes.max > maxValueBytes {
+		return sizeChooser{}, fmt.Errorf("invalid value sizes %q: sizes must be between 0 and %d", spec, maxValueBytes)
+	}
+	return sizes, nil
+}
+
+// Make a copy of the distribution for one worker
+func (s sizeChooser) forWorker() sizeChooser {
+	if s.kind == "zipfian" {
+		s.zipf = newZipfian(int64(s.max - s.min + 1))
+	}
+	return s
+}
+
+func (s sizeChooser) next(r *rand.Rand) int {
+	switch s.kind {
+	case "uniform":
+		return s.min + r.Intn(s.max-s.min+1)
+	case "zipfian":
+		return s.min + int(s.zipf.next(r, int64(s.max-s.min+1)))
+	}
+	return s.min
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Types of the events of a history. An invoke event starts an operation
+// and the next event of the same client completes it: ok if it took
+// effect with the recorded result, fail if it surely didn't take effect,
+// info if it is unknown, like after a timeout.
+const (
+	EVENT_INVOKE = "invoke"
+	EVENT_OK     = "ok"
+	EVENT_FAIL   = "fail"
+	EVENT_INFO   = "info"
+)
+
+// Operations of the KV API recorded in a history
+const (
+	OP_GET    = "get"
+	OP_PUT    = "put"
+	OP_REMOVE = "remove"
+)
+
+// An event of a history, one JSON line of a history file
+type event struct {
+	Client int    `json:"client"`
+	Type   string `json:"type"`
+	Op     string `json:"op"`
+	Key    string `json:"key"`
+	// Value written by a put, or read by a get
+	Value string `json:"value,omitempty"`
+	// Whether the key existed, for a get or a remove that completed
+	Found bool `json:"found,omitempty"`
+	// Nanoseconds since the start of the recording
+	Time  int64  `json:"time"`
+	Error string `json:"error,omitempty"`
+}
+
+// An operation of a history, made of its invoke and complete events
+type operation struct {
+	id     int
+	client int
+	op     string
+	key    string
+	value  string
+	found  bool
+	call   int64
+	ret    int64
+	// The outcome is unknown: the operation may or may not have taken
+	// effect, at any time after its call
+	unknown bool
+}
+
+func (o operation) String() string {
+	ret := fmt.Sprint(o.ret)
+	if o.unknown {
+		ret = "?"
+	}
+	switch o.op {
+	case OP_PUT:
+		return fmt.Sprintf("client %d put(%s, %q) [%d, %s]", o.client, o.key, o.value, o.call, ret)
+	case OP_GET:
+		if !o.found {
+			return fmt.Sprintf("client %d get(%s) -> not found [%d, %s]", o.client, o.key, o.call, ret)
+		}
+		return fmt.Sprintf("client %d get(%s) -> %q [%d, %s]", o.client, o.key, o.value, o.call, ret)
+	}
+	return fmt.Sprintf("client %d remove(%s) -> found %v [%d, %s]", o.client, o.key, o.found, o.call, ret)
+}
+
+// Read the events of a history file
+func readEvents(r io.Reader) ([]event, error) {
+	var events []event
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// Pair the events of a history into operations, in the order of their
+// calls. Failed operations and reads with an unknown outcome have no
+// effect and are left out. An operation that never completed has an
+// unknown outcome.
+func operations(events []event) ([]operation, error) {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+
+	var ops []operation
+	invoked := make(map[int]event)
+	add := func(invoke event, complete event) {
+		o := operation{client: invoke.Client, op: invoke.Op, key: invoke.Key, value: invoke.Value, call: invoke.Time, ret: complete.Time}
+		switch complete.Type {
+		case EVENT_FAIL:
+			return
+		case EVENT_INFO:
+			if o.op == OP_GET {
+				return
+			}
+			o.unknown = true
+			o.ret = math.MaxInt64
+		default:
+			o.found = complete.Found
+			if o.op == OP_GET {
+				o.value = complete.Value
+			}
+		}
+		o.id = len(ops)
+		ops = append(ops, o)
+	}
+
+	for _, e := range events {
+		if e.Type == EVENT_INVOKE {
+			if _, pending := invoked[e.Client]; pending {
+				return nil, fmt.Errorf("client %d invoked an operation at %d before completing the previous one", e.Client, e.Time)
+			}
+			if e.Op != OP_GET && e.Op != OP_PUT && e.Op != OP_REMOVE {
+				return nil, fmt.Errorf("unknown operation %q", e.Op)
+			}
+			invoked[e.Client] = e
+			continue
+		}
+		invoke, pending := invoked[e.Client]
+		if !pending {
+			return nil, fmt.Errorf("client %d completed an operation at %d it didn't invoke", e.Client, e.Time)
+		}
+		delete(invoked, e.Client)
+		add(invoke, e)
+	}
+	for _, invoke := range invoked {
+		add(invoke, event{Type: EVENT_INFO})
+	}
+
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].call < ops[j].call })
+	for i := range ops {
+		ops[i].id = i
+	}
+	return ops, nil
+}
+
+// Split operations by key. Operations on different keys don't affect each
+// other, so each key can be checked on its own.
+func byKey(ops []operation) map[string][]operation {
+	keys := make(map[string][]operation)
+	for _, o := range ops {
+		keys[o.key] = append(keys[o.key], o)
+	}
+	return keys
+}
+
+func sortedKeys(keys map[string][]operation) []string {
+	var names []string
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
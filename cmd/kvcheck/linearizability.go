@@ -0,0 +1,228 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// State of a key: the KV API on a single key is a register that can also
+// be empty
+type register struct {
+	exists bool
+	value  string
+}
+
+// Apply an operation to the state of a key
+//
+// Returns:
+//		Whether the operation could have had its result in this state,
+//		and the state after it
+func step(state register, o operation) (bool, register) {
+	switch o.op {
+	case OP_GET:
+		return o.found == state.exists && (!o.found || o.value == state.value), state
+	case OP_PUT:
+		return true, register{exists: true, value: o.value}
+	}
+	return o.unknown || o.found == state.exists, register{}
+}
+
+// A call or return of an operation, in a doubly linked list ordered by
+// time
+type entry struct {
+	id     int
+	isCall bool
+	time   int64
+	match  *entry
+	prev   *entry
+	next   *entry
+}
+
+// Make the list of calls and returns of operations, after a head entry.
+// A call and a return at the same time are concurrent, so calls come
+// first.
+func makeEntries(ops []operation) *entry {
+	var entries []*entry
+	for i, o := range ops {
+		call := &entry{id: i, isCall: true, time: o.call}
+		ret := &entry{id: i, time: o.ret}
+		call.match = ret
+		entries = append(entries, call, ret)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+		return entries[i].isCall && !entries[j].isCall
+	})
+
+	head := &entry{id: -1}
+	last := head
+	for _, e := range entries {
+		last.next = e
+		e.prev = last
+		last = e
+	}
+	return head
+}
+
+// Take a call and its return out of the list, once the operation is
+// linearized
+func lift(call *entry) {
+	call.prev.next = call.next
+	call.next.prev = call.prev
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+// Put back a call and its return taken out by lift
+func unlift(call *entry) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	call.next.prev = call
+}
+
+// Set of the operations linearized so far
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) clone() bitset {
+	return append(bitset{}, b...)
+}
+
+func (b bitset) set(i int) bitset {
+	b[i/64] |= 1 << uint(i%64)
+	return b
+}
+
+func (b bitset) clear(i int) bitset {
+	b[i/64] &^= 1 << uint(i%64)
+	return b
+}
+
+// Get a map key for the set
+func (b bitset) key() string {
+	buf := make([]byte, 0, len(b)*8)
+	for _, word := range b {
+		for shift := uint(0); shift < 64; shift += 8 {
+			buf = append(buf, byte(word>>shift))
+		}
+	}
+	return string(buf)
+}
+
+// Results of a check
+const (
+	CHECK_OK      = "ok"
+	CHECK_ILLEGAL = "illegal"
+	CHECK_UNKNOWN = "unknown"
+)
+
+// Outcome of the linearizability check of a key
+type linearizability struct {
+	result string
+	// Largest number of operations linearized in one order, and the
+	// operation whose return no order could reach past after it
+	linearized int
+	stuck      operation
+}
+
+// Check whether the operations of a key are linearizable: whether they
+// can be put in an order, each one taking effect at some point between
+// its call and its return, where every result matches the register.
+// This is the search of Porcupine: calls are linearized as long as the
+// register allows it, the search backtracks when it reaches a return that
+// isn't linearized, and the states already explored for a set of
+// linearized operations are cached.
+//
+// Arguments:
+//		ops: operations of a single key
+//		deadline: time after which the check gives up
+// Returns:
+//		The outcome of the check
+func checkLinearizable(ops []operation, deadline time.Time) linearizability {
+	type frame struct {
+		call  *entry
+		state register
+	}
+
+	head := makeEntries(ops)
+	linearized := newBitset(len(ops))
+	cache := make(map[string][]register)
+	var calls []frame
+	var state register
+	best := linearizability{result: CHECK_ILLEGAL, linearized: -1}
+
+	e := head.next
+	for steps := 0; head.next != nil; steps++ {
+		if steps%1024 == 0 && time.Now().After(deadline) {
+			return linearizability{result: CHECK_UNKNOWN}
+		}
+
+		if e.isCall {
+			if ok, newState := step(state, ops[e.id]); ok {
+				newLinearized := linearized.clone().set(e.id)
+				if !cacheContains(cache, newLinearized.key(), newState) {
+					calls = append(calls, frame{call: e, state: state})
+					state = newState
+					linearized.set(e.id)
+					lift(e)
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+
+		// A return of an operation that isn't linearized: no order of
+		// the calls before it works, undo the last linearized one
+		if len(calls) > best.linearized {
+			best.linearized = len(calls)
+			best.stuck = ops[e.id]
+		}
+		if len(calls) == 0 {
+			return best
+		}
+		top := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		state = top.state
+		linearized.clear(top.call.id)
+		unlift(top.call)
+		e = top.call.next
+	}
+	return linearizability{result: CHECK_OK, linearized: len(ops)}
+}
+
+// Check whether a state was already explored for a set of linearized
+// operations, and remember it if not
+func cacheContains(cache map[string][]register, key string, state register) bool {
+	for _, cached := range cache[key] {
+		if cached == state {
+			return true
+		}
+	}
+	cache[key] = append(cache[key], state)
+	return false
+}
+
+// Get the operations running at the same time as one
+func concurrentWith(ops []operation, o operation) []operation {
+	var concurrent []operation
+	for _, other := range ops {
+		if other.id != o.id && other.call <= o.ret && o.call <= other.ret {
+			concurrent = append(concurrent, other)
+		}
+	}
+	return concurrent
+}
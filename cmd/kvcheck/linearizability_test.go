@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Operations of a test history. A return time of -1 is an unknown
+// outcome.
+func put(client int, value string, call, ret int64) operation {
+	return operation{client: client, op: OP_PUT, value: value, call: call, ret: ret}
+}
+
+func get(client int, value string, call, ret int64) operation {
+	return operation{client: client, op: OP_GET, value: value, found: value != "", call: call, ret: ret}
+}
+
+func remove(client int, found bool, call, ret int64) operation {
+	return operation{client: client, op: OP_REMOVE, found: found, call: call, ret: ret}
+}
+
+// Put the operations of a key in the order of their calls and number them, like
+// operations does
+func keyHistory(ops ...operation) []operation {
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].call < ops[j].call })
+	for i := range ops {
+		ops[i].id = i
+		ops[i].key = "k"
+		if ops[i].ret == -1 {
+			ops[i].unknown = true
+			ops[i].ret = math.MaxInt64
+		}
+	}
+	return ops
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []operation
+		want string
+	}{
+		{"empty", keyHistory(), CHECK_OK},
+		{"read of the empty key", keyHistory(get(1, "", 0, 1)), CHECK_OK},
+		{"read your write", keyHistory(put(1, "a", 0, 1), get(1, "a", 2, 3)), CHECK_OK},
+		{"stale read", keyHistory(put(1, "a", 0, 1), put(1, "b", 2, 3), get(2, "a", 4, 5)), CHECK_ILLEGAL},
+		{"read concurrent with a put, old value", keyHistory(put(1, "a", 0, 1), put(1, "b", 2, 10), get(2, "a", 3, 4)), CHECK_OK},
+		{"read concurrent with a put, new value", keyHistory(put(1, "a", 0, 1), put(1, "b", 2, 10), get(2, "b", 3, 4)), CHECK_OK},
+		{"new value then old value", keyHistory(put(1, "a", 0, 1), put(1, "b", 2, 10), get(2, "b", 3, 4), get(2, "a", 5, 6)), CHECK_ILLEGAL},
+		{"value never written", keyHistory(put(1, "a", 0, 1), get(2, "c", 2, 3)), CHECK_ILLEGAL},
+		{"read before the write", keyHistory(get(2, "a", 0, 1), put(1, "a", 2, 3)), CHECK_ILLEGAL},
+		{"remove", keyHistory(put(1, "a", 0, 1), remove(1, true, 2, 3), get(2, "", 4, 5)), CHECK_OK},
+		{"remove of a missing key found it", keyHistory(remove(1, true, 0, 1)), CHECK_ILLEGAL},
+		{"read after a remove", keyHistory(put(1, "a", 0, 1), remove(1, true, 2, 3), get(2, "a", 4, 5)), CHECK_ILLEGAL},
+		{"unknown put seen later", keyHistory(put(1, "a", 0, -1), get(2, "", 1, 2), get(2, "a", 10, 11)), CHECK_OK},
+		{"unknown put never seen", keyHistory(put(1, "a", 0, -1), get(2, "", 10, 11)), CHECK_OK},
+		{"concurrent puts in one order", keyHistory(put(1, "a", 0, 10), put(2, "b", 0, 10), get(3, "a", 11, 12), get(3, "a", 13, 14)), CHECK_OK},
+		{"concurrent puts in two orders", keyHistory(put(1, "a", 0, 10), put(2, "b", 0, 10), get(3, "a", 11, 12), get(3, "b", 13, 14)), CHECK_ILLEGAL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkLinearizable(tt.ops, time.Now().Add(time.Minute))
+			if got.result != tt.want {
+				t.Fatalf("got %s, want %s", got.result, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLinearizableStuck(t *testing.T) {
+	ops := keyHistory(put(1, "a", 0, 1), put(1, "b", 2, 3), get(2, "a", 4, 5))
+	got := checkLinearizable(ops, time.Now().Add(time.Minute))
+	if got.linearized != 2 || got.stuck.op != OP_GET {
+		t.Fatalf("got %d operations linearized, stuck at %v, want 2 and the get", got.linearized, got.stuck)
+	}
+}
+
+func TestCheckLinearizableDeadline(t *testing.T) {
+	ops := keyHistory(put(1, "a", 0, 1))
+	if got := checkLinearizable(ops, time.Now().Add(-time.Second)); got.result != CHECK_UNKNOWN {
+		t.Fatalf("got %s past the deadline, want %s", got.result, CHECK_UNKNOWN)
+	}
+}
@@ -0,0 +1,342 @@
+// kvcheck records the operations of concurrent clients against a cluster
+// and checks the history for linearizability, read-your-writes or
+// monotonic reads.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"pa2/src/client"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usageText = `Usage: kvcheck <command> [flags]
+
+Commands:
+  record                          run clients against a cluster and write their history
+  check <history>                 check a history against consistency models
+
+Flags of a command are listed by kvcheck <command> -h
+`
+
+func usage() {
+	fmt.Fprint(os.Stderr, usageText)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = record(os.Args[2:])
+	case "check":
+		var ok bool
+		ok, err = check(os.Args[2:])
+		if err == nil && !ok {
+			os.Exit(1)
+		}
+	case "-h", "-help", "--help":
+		usage()
+		return
+	default:
+		usage()
+		err = fmt.Errorf("unknown command %q", os.Args[1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvcheck:", err)
+		os.Exit(2)
+	}
+}
+
+// Settings of a recording
+type recordConfig struct {
+	seeds     []string
+	clients   int
+	keys      int
+	duration  time.Duration
+	timeout   time.Duration
+	opTimeout time.Duration
+	seed      int64
+}
+
+// Writes the events of all the clients to a history file
+type history struct {
+	start   time.Time
+	encoder *json.Encoder
+	mutex   sync.Mutex
+	err     error
+}
+
+// Add an event, stamped with the time since the start of the recording
+func (h *history) add(e event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	e.Time = time.Since(h.start).Nanoseconds()
+	if err := h.encoder.Encode(e); err != nil && h.err == nil {
+		h.err = err
+	}
+}
+
+// Get the type of the event completing an operation from its error. An
+// error the node returned before doing anything fails the operation;
+// after a timeout or a failure while replicating, the operation may have
+// taken effect.
+func completion(err error) string {
+	if err == nil || client.IsNotFound(err) {
+		return EVENT_OK
+	}
+	var kvErr *client.Error
+	if errors.As(err, &kvErr) {
+		switch kvErr.Code {
+		case client.NO_SPC_ERR, client.SYS_OVERLOAD_ERR, client.UNKNOWN_CMD_ERR, client.INVALID_KEY_ERR,
+			client.INVALID_VAL_ERR, client.NO_QUORUM_ERR, client.CONDITION_FAILED_ERR, client.MOVED_ERR,
+			client.TOO_MANY_HOPS_ERR:
+			return EVENT_FAIL
+		}
+	}
+	return EVENT_INFO
+}
+
+func keyName(i int) string {
+	return fmt.Sprintf("kvcheck-%d", i)
+}
+
+// Remove the keys of a recording, so the history starts from empty keys
+// rather than values left by an earlier one
+func clearKeys(cfg recordConfig) error {
+	kv, err := client.New(cfg.seeds, client.Options{Timeout: cfg.timeout})
+	if err != nil {
+		return fmt.Errorf("could not reach the cluster: %v", err)
+	}
+	defer kv.Close()
+
+	for i := 0; i < cfg.keys; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.opTimeout)
+		err := kv.Remove(ctx, []byte(keyName(i)))
+		cancel()
+		if err != nil && !client.IsNotFound(err) {
+			return fmt.Errorf("could not remove %s: %v", keyName(i), err)
+		}
+	}
+	return nil
+}
+
+// Run one client until the end of the recording: operations on random
+// keys, every put writing a value no other put writes
+func runClient(cfg recordConfig, h *history, id int, deadline time.Time) error {
+	kv, err := client.New(cfg.seeds, client.Options{Timeout: cfg.timeout})
+	if err != nil {
+		return fmt.Errorf("could not reach the cluster: %v", err)
+	}
+	defer kv.Close()
+
+	r := rand.New(rand.NewSource(cfg.seed + int64(id)))
+	for n := 0; time.Now().Before(deadline); n++ {
+		key := keyName(r.Intn(cfg.keys))
+		invoke := event{Client: id, Type: EVENT_INVOKE, Op: OP_GET, Key: key}
+		switch u := r.Float64(); {
+		case u < 0.4:
+			invoke.Op = OP_PUT
+			invoke.Value = fmt.Sprintf("c%d-%d", id, n)
+		case u < 0.5:
+			invoke.Op = OP_REMOVE
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.opTimeout)
+		h.add(invoke)
+		complete := event{Client: id, Op: invoke.Op, Key: key}
+		switch invoke.Op {
+		case OP_GET:
+			var value []byte
+			value, _, err = kv.Get(ctx, []byte(key))
+			complete.Value = string(value)
+			complete.Found = err == nil
+		case OP_PUT:
+			err = kv.Put(ctx, []byte(key), []byte(invoke.Value), 0)
+			complete.Value = invoke.Value
+		case OP_REMOVE:
+			err = kv.Remove(ctx, []byte(key))
+			complete.Found = err == nil
+		}
+		cancel()
+
+		complete.Type = completion(err)
+		if complete.Type != EVENT_OK {
+			complete.Error = err.Error()
+		}
+		h.add(complete)
+	}
+	return nil
+}
+
+// Record a history
+//
+// Arguments:
+//		args: flags of the command
+// Returns:
+//		An error if the history could not be written
+func record(args []string) error {
+	var cfg recordConfig
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	seeds := flags.String("seeds", "127.0.0.1:3333", "comma separated addresses of nodes of the cluster")
+	flags.IntVar(&cfg.clients, "clients", 5, "number of concurrent clients")
+	flags.IntVar(&cfg.keys, "keys", 3, "number of keys the clients share")
+	flags.DurationVar(&cfg.duration, "duration", 10*time.Second, "length of the recording")
+	flags.DurationVar(&cfg.timeout, "timeout", 500*time.Millisecond, "time to wait for the first response of a node")
+	flags.DurationVar(&cfg.opTimeout, "op-timeout", 5*time.Second, "time allowed for an operation before its outcome is unknown")
+	flags.Int64Var(&cfg.seed, "seed", time.Now().UnixNano(), "seed of the random choices")
+	out := flags.String("out", "history.jsonl", "history file")
+	flags.Parse(args)
+
+	for _, seed := range strings.Split(*seeds, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			cfg.seeds = append(cfg.seeds, seed)
+		}
+	}
+	if cfg.clients < 1 || cfg.keys < 1 {
+		return fmt.Errorf("-clients and -keys must be positive")
+	}
+
+	if err := clearKeys(cfg); err != nil {
+		return err
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := &history{start: time.Now(), encoder: json.NewEncoder(file)}
+	deadline := h.start.Add(cfg.duration)
+	errs := make(chan error, cfg.clients)
+	var wg sync.WaitGroup
+	for id := 0; id < cfg.clients; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := runClient(cfg, h, id, deadline); err != nil {
+				errs <- err
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	if h.err != nil {
+		return h.err
+	}
+	fmt.Printf("recorded %d clients for %v to %s\n", cfg.clients, cfg.duration, *out)
+	return file.Close()
+}
+
+// Check a history
+//
+// Arguments:
+//		args: flags of the command and the history file
+// Returns:
+//		Whether the history satisfies every model checked, and an error if
+//		it could not be read
+func check(args []string) (bool, error) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	model := flags.String("model", "all", "model to check: linearizable, read-your-writes, monotonic-reads or all")
+	timeout := flags.Duration("timeout", time.Minute, "time allowed for the linearizability search of a key")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return false, fmt.Errorf("check expects one history file")
+	}
+
+	models := []string{MODEL_LINEARIZABLE, MODEL_READ_YOUR_WRITE, MODEL_MONOTONIC_READS}
+	if *model != "all" {
+		models = nil
+		for _, m := range strings.Split(*model, ",") {
+			switch m = strings.TrimSpace(m); m {
+			case MODEL_LINEARIZABLE, MODEL_READ_YOUR_WRITE, MODEL_MONOTONIC_READS:
+				models = append(models, m)
+			default:
+				return false, fmt.Errorf("unknown model %q", m)
+			}
+		}
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	events, err := readEvents(file)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", flags.Arg(0), err)
+	}
+	ops, err := operations(events)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", flags.Arg(0), err)
+	}
+	keys := byKey(ops)
+	fmt.Printf("%d operations on %d keys\n", len(ops), len(keys))
+
+	ok := true
+	for _, m := range models {
+		result := CHECK_OK
+		for _, key := range sortedKeys(keys) {
+			var keyResult string
+			if m == MODEL_LINEARIZABLE {
+				keyResult = reportLinearizable(key, keys[key], *timeout)
+			} else {
+				keyResult = reportSessions(key, keys[key], m)
+			}
+			if keyResult == CHECK_ILLEGAL || result == CHECK_OK {
+				result = keyResult
+			}
+		}
+		fmt.Printf("%s: %s\n", m, result)
+		if result != CHECK_OK {
+			ok = false
+		}
+	}
+	return ok, nil
+}
+
+// Check the linearizability of a key and print why it failed
+func reportLinearizable(key string, ops []operation, timeout time.Duration) string {
+	l := checkLinearizable(ops, time.Now().Add(timeout))
+	switch l.result {
+	case CHECK_UNKNOWN:
+		fmt.Printf("  key %s: gave up after %v\n", key, timeout)
+	case CHECK_ILLEGAL:
+		fmt.Printf("  key %s: not linearizable, at most %d of %d operations fit in an order, then no order lets this one return\n", key, l.linearized, len(ops))
+		fmt.Printf("    %s\n", l.stuck)
+		if concurrent := concurrentWith(ops, l.stuck); len(concurrent) > 0 {
+			fmt.Printf("  which ran concurrently with\n")
+			for _, o := range concurrent {
+				fmt.Printf("    %s\n", o)
+			}
+		}
+	}
+	return l.result
+}
+
+// Check a session guarantee of a key and print its violations
+func reportSessions(key string, ops []operation, model string) string {
+	violations := checkSessions(ops, model)
+	for _, v := range violations {
+		fmt.Printf("  key %s: %s\n", key, v)
+	}
+	if len(violations) > 0 {
+		return CHECK_ILLEGAL
+	}
+	return CHECK_OK
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// A write a read may have returned: a put of the value it read, or for a
+// read that found nothing, a remove or the empty initial state
+type candidate struct {
+	op   *operation
+	call int64
+	ret  int64
+}
+
+func (c candidate) String() string {
+	if c.op == nil {
+		return "the initial state"
+	}
+	return c.op.String()
+}
+
+// The initial state of a key, before any operation
+var initialState = candidate{call: -1, ret: -1}
+
+// A violation of a session guarantee
+type violation struct {
+	model string
+	read  operation
+	// Write the read should have seen, or at least something after it
+	seen   candidate
+	reason string
+}
+
+func (v violation) String() string {
+	if v.reason != "" {
+		return fmt.Sprintf("%s: %s: %s", v.model, v.read, v.reason)
+	}
+	return fmt.Sprintf("%s: %s, after seeing %s", v.model, v.read, v.seen)
+}
+
+// Get the writes a read may have returned, leaving out those called
+// after it returned
+//
+// Arguments:
+//		ops: operations of the key of the read
+//		read: a get
+// Returns:
+//		The candidates, or the reason there are none
+func candidates(ops []operation, read operation) ([]candidate, string) {
+	var writes []candidate
+	if !read.found {
+		writes = append(writes, initialState)
+	}
+	for i := range ops {
+		o := &ops[i]
+		if (read.found && o.op == OP_PUT && o.value == read.value) || (!read.found && o.op == OP_REMOVE) {
+			writes = append(writes, candidate{op: o, call: o.call, ret: o.ret})
+		}
+	}
+	if len(writes) == 0 {
+		return nil, "read a value that was never written"
+	}
+
+	var possible []candidate
+	for _, w := range writes {
+		if w.call <= read.ret {
+			possible = append(possible, w)
+		}
+	}
+	if len(possible) == 0 {
+		return nil, "read a value written after the read returned"
+	}
+	return possible, ""
+}
+
+// Find whether a read is stale: whatever write it returned, a write the
+// client had already seen started after that write was done, so the read
+// should have returned the later one or something after it.
+//
+// Arguments:
+//		writes: candidates of the read
+//		seen: for each write the client has seen, the candidates it may
+//			have been
+// Returns:
+//		A write the client had seen and that replaces every candidate, and
+//		whether there is one
+func stale(writes []candidate, seen [][]candidate) (candidate, bool) {
+	for _, group := range seen {
+		// Earliest the seen write can have started
+		first := candidate{call: math.MaxInt64}
+		for _, s := range group {
+			if s.call < first.call {
+				first = s
+			}
+		}
+		replaced := true
+		for _, w := range writes {
+			if w.ret >= first.call {
+				replaced = false
+				break
+			}
+		}
+		if replaced {
+			return first, true
+		}
+	}
+	return candidate{}, false
+}
+
+// Models a history can be checked against
+const (
+	MODEL_LINEARIZABLE    = "linearizable"
+	MODEL_READ_YOUR_WRITE = "read-your-writes"
+	MODEL_MONOTONIC_READS = "monotonic-reads"
+)
+
+// Check the session guarantees of the operations of a key. With
+// read-your-writes, a client reads its own completed writes or later
+// ones. With monotonic reads, a client never reads a write older than one
+// it has read before.
+//
+// Arguments:
+//		ops: operations of a single key
+//		model: MODEL_READ_YOUR_WRITE or MODEL_MONOTONIC_READS
+// Returns:
+//		The violations found
+func checkSessions(ops []operation, model string) []violation {
+	var violations []violation
+	seen := make(map[int][][]candidate)
+
+	for i := range ops {
+		o := ops[i]
+		if o.op != OP_GET {
+			if model == MODEL_READ_YOUR_WRITE && !o.unknown {
+				seen[o.client] = append(seen[o.client], []candidate{{op: &ops[i], call: o.call, ret: o.ret}})
+			}
+			continue
+		}
+
+		writes, reason := candidates(ops, o)
+		if reason != "" {
+			violations = append(violations, violation{model: model, read: o, reason: reason})
+			continue
+		}
+		if s, isStale := stale(writes, seen[o.client]); isStale {
+			violations = append(violations, violation{model: model, read: o, seen: s})
+		}
+		if model == MODEL_MONOTONIC_READS {
+			seen[o.client] = append(seen[o.client], writes)
+		}
+	}
+	return violations
+}
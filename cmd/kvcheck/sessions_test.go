@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestCheckSessions(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		ops   []operation
+		// Number of violations found
+		want int
+	}{
+		{"own write read", MODEL_READ_YOUR_WRITE, keyHistory(put(1, "a", 0, 1), get(1, "a", 2, 3)), 0},
+		{"own write missed", MODEL_READ_YOUR_WRITE, keyHistory(put(1, "a", 0, 1), get(1, "", 2, 3)), 1},
+		{"write of another client missed", MODEL_READ_YOUR_WRITE, keyHistory(put(2, "a", 0, 1), get(1, "", 2, 3)), 0},
+		{"older own write", MODEL_READ_YOUR_WRITE, keyHistory(put(1, "a", 0, 1), put(1, "b", 2, 3), get(1, "a", 4, 5)), 1},
+		{"later write of another client", MODEL_READ_YOUR_WRITE, keyHistory(put(1, "a", 0, 1), put(2, "b", 2, 3), get(1, "b", 4, 5)), 0},
+		{"unknown own write missed", MODEL_READ_YOUR_WRITE, keyHistory(put(1, "a", 0, -1), get(1, "", 2, 3)), 0},
+		{"own remove missed", MODEL_READ_YOUR_WRITE, keyHistory(put(1, "a", 0, 1), remove(1, true, 2, 3), get(1, "a", 4, 5)), 1},
+		{"value never written", MODEL_READ_YOUR_WRITE, keyHistory(get(1, "a", 0, 1)), 1},
+		{"value written after the read", MODEL_READ_YOUR_WRITE, keyHistory(get(1, "a", 0, 1), put(2, "a", 2, 3)), 1},
+		{"reads moving forward", MODEL_MONOTONIC_READS, keyHistory(put(2, "a", 0, 1), put(2, "b", 2, 3), get(1, "a", 4, 5), get(1, "b", 6, 7)), 0},
+		{"reads going back", MODEL_MONOTONIC_READS, keyHistory(put(2, "a", 0, 1), put(2, "b", 2, 3), get(1, "b", 4, 5), get(1, "a", 6, 7)), 1},
+		{"reads going back on two clients", MODEL_MONOTONIC_READS, keyHistory(put(2, "a", 0, 1), put(2, "b", 2, 3), get(1, "b", 4, 5), get(3, "a", 6, 7)), 0},
+		{"concurrent writes read in both orders", MODEL_MONOTONIC_READS, keyHistory(put(2, "a", 0, 10), put(3, "b", 0, 10), get(1, "b", 4, 5), get(1, "a", 6, 7)), 0},
+		{"own write missed, monotonic reads", MODEL_MONOTONIC_READS, keyHistory(put(1, "a", 0, 1), get(1, "", 2, 3)), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkSessions(tt.ops, tt.model)
+			if len(got) != tt.want {
+				t.Fatalf("got violations %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
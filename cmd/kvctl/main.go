@@ -0,0 +1,438 @@
+// kvctl runs day-to-day operations against a cluster: reading and
+// writing keys, and looking at its membership, ring and health.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	pb "pa2/pb/protobuf"
+	"pa2/src/client"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const usageText = `Usage: kvctl [flags] <command> [arguments]
+
+Commands:
+  get <key>                       print the value of a key
+  put <key> [value]               store a value, read from -f or stdin when not given
+  rm <key>                        remove a key
+  scan                            list the keys of the cluster
+  members                         list the members known to the first seed
+  ring                            list the nodes of the ring and the range each owns
+  owner <key>                     print the node owning a key
+  health                          print the health of the cluster seen by the first seed
+  wipeout                         remove every key of every node of the ring
+  shutdown <node>                 stop a node, given as ip:port
+
+Flags:
+`
+
+// Settings shared by every command
+type options struct {
+	seeds   []string
+	json    bool
+	timeout time.Duration
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, usageText)
+	flag.PrintDefaults()
+}
+
+func main() {
+	seeds := flag.String("seeds", envOr("KVCTL_SEEDS", "127.0.0.1:3333"), "comma separated addresses of nodes of the cluster, or $KVCTL_SEEDS")
+	asJSON := flag.Bool("json", false, "print JSON instead of tables")
+	timeout := flag.Duration("timeout", 10*time.Second, "time allowed for the whole command")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	opts := options{json: *asJSON, timeout: *timeout}
+	for _, seed := range strings.Split(*seeds, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			opts.seeds = append(opts.seeds, seed)
+		}
+	}
+
+	if err := run(opts, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Run a command
+//
+// Arguments:
+//		opts: shared settings
+//		command: name of the command
+//		args: arguments following the command
+// Returns:
+//		An error if the command failed
+func run(opts options, command string, args []string) error {
+	handlers := map[string]func(context.Context, *client.Client, *options, []string) error{
+		"get":      cmdGet,
+		"put":      cmdPut,
+		"rm":       cmdRemove,
+		"scan":     cmdScan,
+		"members":  cmdMembers,
+		"ring":     cmdRing,
+		"owner":    cmdOwner,
+		"health":   cmdHealth,
+		"wipeout":  cmdWipeout,
+		"shutdown": cmdShutdown,
+	}
+	handler, ok := handlers[command]
+	if !ok {
+		usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	kv, err := client.New(opts.seeds, client.Options{})
+	if err != nil {
+		return fmt.Errorf("could not reach the cluster: %v", err)
+	}
+	defer kv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	return handler(ctx, kv, &opts, args)
+}
+
+// Make the flags of a command. -json can also be given after the command.
+func newFlags(command string, opts *options) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.BoolVar(&opts.json, "json", opts.json, "print JSON instead of tables")
+	return flags
+}
+
+// Parse the flags of a command and check that it got the number of
+// arguments it takes
+//
+// Returns:
+//		The arguments left after the flags
+func parseArgs(flags *flag.FlagSet, args []string, min int, max int, usage string) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := wantArgs(flags.Args(), min, max, usage); err != nil {
+		return nil, err
+	}
+	return flags.Args(), nil
+}
+
+// Check that a command got the number of arguments it takes
+func wantArgs(args []string, min int, max int, usage string) error {
+	if len(args) < min || len(args) > max {
+		return fmt.Errorf("usage: kvctl %s", usage)
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// Print rows as a table, the first one being the header
+func printTable(rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// A key-value pair as printed in JSON
+type entryJSON struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Version int32  `json:"version"`
+}
+
+func cmdGet(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	args, err := parseArgs(newFlags("get", opts), args, 1, 1, "get <key>")
+	if err != nil {
+		return err
+	}
+	value, version, err := kv.Get(ctx, []byte(args[0]))
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(entryJSON{Key: args[0], Value: string(value), Version: version})
+	}
+	_, err = os.Stdout.Write(value)
+	return err
+}
+
+func cmdPut(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	flags := newFlags("put", opts)
+	file := flags.String("f", "", "read the value from a file, - for stdin")
+	version := flags.Int("version", 0, "version stored with the value")
+	args, err := parseArgs(flags, args, 1, 2, "put [-f file] [-version n] <key> [value]")
+	if err != nil {
+		return err
+	}
+
+	var value []byte
+	switch {
+	case len(args) == 2 && *file != "":
+		return errors.New("give the value either as an argument or with -f")
+	case len(args) == 2:
+		value = []byte(args[1])
+	case *file == "" || *file == "-":
+		value, err = ioutil.ReadAll(os.Stdin)
+	default:
+		value, err = ioutil.ReadFile(*file)
+	}
+	if err != nil {
+		return err
+	}
+	return kv.Put(ctx, []byte(args[0]), value, int32(*version))
+}
+
+func cmdRemove(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	args, err := parseArgs(newFlags("rm", opts), args, 1, 1, "rm <key>")
+	if err != nil {
+		return err
+	}
+	return kv.Remove(ctx, []byte(args[0]))
+}
+
+func cmdScan(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	flags := newFlags("scan", opts)
+	prefix := flags.String("prefix", "", "only keys starting with it")
+	after := flags.String("after", "", "only keys greater than this one")
+	limit := flags.Uint("limit", 0, "maximum number of keys, 0 for every key")
+	if _, err := parseArgs(flags, args, 0, 0, "scan [-prefix p] [-after key] [-limit n]"); err != nil {
+		return err
+	}
+
+	entries, err := kv.Scan(ctx, []byte(*after), []byte(*prefix), uint32(*limit))
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		out := []entryJSON{}
+		for _, entry := range entries {
+			out = append(out, entryJSON{Key: string(entry.Key), Value: string(entry.Value), Version: entry.Version})
+		}
+		return printJSON(out)
+	}
+	rows := [][]string{{"KEY", "VERSION", "VALUE"}}
+	for _, entry := range entries {
+		rows = append(rows, []string{string(entry.Key), fmt.Sprint(entry.Version), string(entry.Value)})
+	}
+	return printTable(rows)
+}
+
+// A member as printed in JSON
+type memberJSON struct {
+	Addr        string            `json:"addr"`
+	Membership  string            `json:"membership"`
+	Incarnation uint64            `json:"incarnation"`
+	Suspicion   float64           `json:"suspicion"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// Get the name of a membership state, "alive" for a member
+func membershipName(membership string) string {
+	if membership == "0" {
+		return "alive"
+	}
+	return membership
+}
+
+func cmdMembers(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	args, err := parseArgs(newFlags("members", opts), args, 0, 0, "members")
+	if err != nil {
+		return err
+	}
+	respPay, err := kv.DoAt(ctx, opts.seeds[0], &pb.KVRequest{Command: client.GET_MEMBERSHIP_LIST})
+	if err != nil {
+		return err
+	}
+	if respPay.ErrCode != client.NO_ERR {
+		return &client.Error{Code: respPay.ErrCode}
+	}
+
+	members := []memberJSON{}
+	for addr, entry := range respPay.NodeList {
+		node := &pb.NodeVal{}
+		if err := proto.Unmarshal(entry, node); err != nil {
+			return fmt.Errorf("invalid entry for %s: %v", addr, err)
+		}
+		members = append(members, memberJSON{
+			Addr:        addr,
+			Membership:  membershipName(node.Membership),
+			Incarnation: node.Incarnation,
+			Suspicion:   node.Suspicion,
+			Tags:        node.Tags,
+		})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+
+	if opts.json {
+		return printJSON(members)
+	}
+	rows := [][]string{{"ADDR", "MEMBERSHIP", "INCARNATION", "SUSPICION", "TAGS"}}
+	for _, member := range members {
+		var tags []string
+		for name, value := range member.Tags {
+			tags = append(tags, name+"="+value)
+		}
+		sort.Strings(tags)
+		rows = append(rows, []string{member.Addr, member.Membership, fmt.Sprint(member.Incarnation),
+			fmt.Sprintf("%.2f", member.Suspicion), strings.Join(tags, ",")})
+	}
+	return printTable(rows)
+}
+
+// A node of the ring as printed in JSON, with the hashes it owns: the
+// ones after its father's, up to its own
+type ringNodeJSON struct {
+	Addr       string `json:"addr"`
+	Hash       uint32 `json:"hash"`
+	RangeStart uint32 `json:"rangeStart"`
+	RangeEnd   uint32 `json:"rangeEnd"`
+}
+
+func cmdRing(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	args, err := parseArgs(newFlags("ring", opts), args, 0, 0, "ring")
+	if err != nil {
+		return err
+	}
+	nodes, epoch := kv.Ring()
+	ring := []ringNodeJSON{}
+	for i, node := range nodes {
+		father := nodes[(i-1+len(nodes))%len(nodes)]
+		ring = append(ring, ringNodeJSON{Addr: node.Addr, Hash: node.Hash, RangeStart: father.Hash + 1, RangeEnd: node.Hash})
+	}
+
+	if opts.json {
+		return printJSON(struct {
+			Epoch uint64         `json:"epoch"`
+			Nodes []ringNodeJSON `json:"nodes"`
+		}{epoch, ring})
+	}
+	fmt.Printf("epoch %d\n", epoch)
+	rows := [][]string{{"ADDR", "HASH", "OWNS"}}
+	for _, node := range ring {
+		rows = append(rows, []string{node.Addr, fmt.Sprint(node.Hash), fmt.Sprintf("%d..%d", node.RangeStart, node.RangeEnd)})
+	}
+	return printTable(rows)
+}
+
+func cmdOwner(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	args, err := parseArgs(newFlags("owner", opts), args, 1, 1, "owner <key>")
+	if err != nil {
+		return err
+	}
+	key := []byte(args[0])
+	if opts.json {
+		return printJSON(struct {
+			Key   string `json:"key"`
+			Hash  uint32 `json:"hash"`
+			Owner string `json:"owner"`
+		}{args[0], client.KeyHash(key), kv.Owner(key)})
+	}
+	fmt.Println(kv.Owner(key))
+	return nil
+}
+
+func cmdHealth(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	args, err := parseArgs(newFlags("health", opts), args, 0, 0, "health")
+	if err != nil {
+		return err
+	}
+	respPay, err := kv.DoAt(ctx, opts.seeds[0], &pb.KVRequest{Command: client.CLUSTER_HEALTH})
+	if err != nil {
+		return err
+	}
+	if respPay.ErrCode != client.NO_ERR {
+		return &client.Error{Code: respPay.ErrCode}
+	}
+	health := respPay.Health
+	if opts.json {
+		return printJSON(health)
+	}
+
+	rows := [][]string{
+		{"mode", health.Mode},
+		{"ring size", fmt.Sprint(health.RingSize)},
+		{"cluster size", fmt.Sprint(health.ClusterSize)},
+		{"alive", fmt.Sprint(health.AliveCount)},
+		{"suspect", fmt.Sprint(health.SuspectCount)},
+		{"dead", fmt.Sprint(health.DeadCount)},
+		{"left", fmt.Sprint(health.LeftCount)},
+		{"under-replicated ranges", fmt.Sprint(health.UnderReplicatedRanges)},
+		{"pending handoffs", fmt.Sprint(health.PendingHandoffs)},
+		{"epoch", fmt.Sprint(health.Epoch)},
+	}
+	if err := printTable(rows); err != nil {
+		return err
+	}
+
+	var addrs []string
+	for addr := range health.NodeEpochs {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	rows = [][]string{{"NODE", "EPOCH"}}
+	for _, addr := range addrs {
+		rows = append(rows, []string{addr, fmt.Sprint(health.NodeEpochs[addr])})
+	}
+	fmt.Println()
+	return printTable(rows)
+}
+
+func cmdWipeout(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	args, err := parseArgs(newFlags("wipeout", opts), args, 0, 0, "wipeout")
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, addr := range kv.Nodes() {
+		respPay, err := kv.DoAt(ctx, addr, &pb.KVRequest{Command: client.WIPEOUT})
+		if err == nil && respPay.ErrCode != client.NO_ERR {
+			err = &client.Error{Code: respPay.ErrCode}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvctl: could not wipe out %s: %v\n", addr, err)
+			failed = append(failed, addr)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d nodes not wiped out", len(failed))
+	}
+	return nil
+}
+
+func cmdShutdown(ctx context.Context, kv *client.Client, opts *options, args []string) error {
+	args, err := parseArgs(newFlags("shutdown", opts), args, 1, 1, "shutdown <node>")
+	if err != nil {
+		return err
+	}
+	return kv.Notify(args[0], &pb.KVRequest{Command: client.SHUTDOWN})
+}
@@ -4,6 +4,6 @@ go 1.15
 
 require (
 	github.com/golang/protobuf v1.5.2
-	github.com/google/uuid v1.2.0
+	google.golang.org/grpc v1.43.0
 	google.golang.org/protobuf v1.26.0
 )
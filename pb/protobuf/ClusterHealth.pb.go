@@ -0,0 +1,272 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.17.1
+// source: ClusterHealth.proto
+
+package protobuf
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ClusterHealth struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RingSize              uint32            `protobuf:"varint,1,opt,name=ringSize,proto3" json:"ringSize,omitempty"`
+	AliveCount            uint32            `protobuf:"varint,2,opt,name=aliveCount,proto3" json:"aliveCount,omitempty"`
+	SuspectCount          uint32            `protobuf:"varint,3,opt,name=suspectCount,proto3" json:"suspectCount,omitempty"`
+	DeadCount             uint32            `protobuf:"varint,4,opt,name=deadCount,proto3" json:"deadCount,omitempty"`
+	LeftCount             uint32            `protobuf:"varint,5,opt,name=leftCount,proto3" json:"leftCount,omitempty"`
+	UnderReplicatedRanges uint32            `protobuf:"varint,6,opt,name=underReplicatedRanges,proto3" json:"underReplicatedRanges,omitempty"`
+	PendingHandoffs       uint32            `protobuf:"varint,7,opt,name=pendingHandoffs,proto3" json:"pendingHandoffs,omitempty"`
+	Epoch                 uint64            `protobuf:"varint,8,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	NodeEpochs            map[string]uint64 `protobuf:"bytes,9,rep,name=nodeEpochs,proto3" json:"nodeEpochs,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	MembershipEvents      map[string]uint64 `protobuf:"bytes,10,rep,name=membershipEvents,proto3" json:"membershipEvents,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	ClusterSize           uint32            `protobuf:"varint,11,opt,name=clusterSize,proto3" json:"clusterSize,omitempty"`
+	Mode                  string            `protobuf:"bytes,12,opt,name=mode,proto3" json:"mode,omitempty"`
+}
+
+func (x *ClusterHealth) Reset() {
+	*x = ClusterHealth{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_ClusterHealth_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ClusterHealth) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClusterHealth) ProtoMessage() {}
+
+func (x *ClusterHealth) ProtoReflect() protoreflect.Message {
+	mi := &file_ClusterHealth_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClusterHealth.ProtoReflect.Descriptor instead.
+func (*ClusterHealth) Descriptor() ([]byte, []int) {
+	return file_ClusterHealth_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ClusterHealth) GetRingSize() uint32 {
+	if x != nil {
+		return x.RingSize
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetAliveCount() uint32 {
+	if x != nil {
+		return x.AliveCount
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetSuspectCount() uint32 {
+	if x != nil {
+		return x.SuspectCount
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetDeadCount() uint32 {
+	if x != nil {
+		return x.DeadCount
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetLeftCount() uint32 {
+	if x != nil {
+		return x.LeftCount
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetUnderReplicatedRanges() uint32 {
+	if x != nil {
+		return x.UnderReplicatedRanges
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetPendingHandoffs() uint32 {
+	if x != nil {
+		return x.PendingHandoffs
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetEpoch() uint64 {
+	if x != nil {
+		return x.Epoch
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetNodeEpochs() map[string]uint64 {
+	if x != nil {
+		return x.NodeEpochs
+	}
+	return nil
+}
+
+func (x *ClusterHealth) GetMembershipEvents() map[string]uint64 {
+	if x != nil {
+		return x.MembershipEvents
+	}
+	return nil
+}
+
+func (x *ClusterHealth) GetClusterSize() uint32 {
+	if x != nil {
+		return x.ClusterSize
+	}
+	return 0
+}
+
+func (x *ClusterHealth) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+var File_ClusterHealth_proto protoreflect.FileDescriptor
+
+var file_ClusterHealth_proto_rawDesc = []byte{
+	0x0a, 0x13, 0x43, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x22,
+	0xff, 0x04, 0x0a, 0x0d, 0x43, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x48, 0x65, 0x61, 0x6c, 0x74,
+	0x68, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x69, 0x6e, 0x67, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x08, 0x72, 0x69, 0x6e, 0x67, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1e, 0x0a,
+	0x0a, 0x61, 0x6c, 0x69, 0x76, 0x65, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x0a, 0x61, 0x6c, 0x69, 0x76, 0x65, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x22, 0x0a,
+	0x0c, 0x73, 0x75, 0x73, 0x70, 0x65, 0x63, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x0c, 0x73, 0x75, 0x73, 0x70, 0x65, 0x63, 0x74, 0x43, 0x6f, 0x75, 0x6e,
+	0x74, 0x12, 0x1c, 0x0a, 0x09, 0x64, 0x65, 0x61, 0x64, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x64, 0x65, 0x61, 0x64, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12,
+	0x1c, 0x0a, 0x09, 0x6c, 0x65, 0x66, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x09, 0x6c, 0x65, 0x66, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x34, 0x0a,
+	0x15, 0x75, 0x6e, 0x64, 0x65, 0x72, 0x52, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x65, 0x64,
+	0x52, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x15, 0x75, 0x6e,
+	0x64, 0x65, 0x72, 0x52, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x65, 0x64, 0x52, 0x61, 0x6e,
+	0x67, 0x65, 0x73, 0x12, 0x28, 0x0a, 0x0f, 0x70, 0x65, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x48, 0x61,
+	0x6e, 0x64, 0x6f, 0x66, 0x66, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0f, 0x70, 0x65,
+	0x6e, 0x64, 0x69, 0x6e, 0x67, 0x48, 0x61, 0x6e, 0x64, 0x6f, 0x66, 0x66, 0x73, 0x12, 0x14, 0x0a,
+	0x05, 0x65, 0x70, 0x6f, 0x63, 0x68, 0x18, 0x08, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x65, 0x70,
+	0x6f, 0x63, 0x68, 0x12, 0x47, 0x0a, 0x0a, 0x6e, 0x6f, 0x64, 0x65, 0x45, 0x70, 0x6f, 0x63, 0x68,
+	0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x27, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x43, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68,
+	0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x45, 0x70, 0x6f, 0x63, 0x68, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x52, 0x0a, 0x6e, 0x6f, 0x64, 0x65, 0x45, 0x70, 0x6f, 0x63, 0x68, 0x73, 0x12, 0x59, 0x0a, 0x10,
+	0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73,
+	0x18, 0x0a, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2d, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x43, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x48, 0x65, 0x61, 0x6c, 0x74, 0x68, 0x2e,
+	0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x10, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69,
+	0x70, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x20, 0x0a, 0x0b, 0x63, 0x6c, 0x75, 0x73, 0x74,
+	0x65, 0x72, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x63, 0x6c,
+	0x75, 0x73, 0x74, 0x65, 0x72, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6d, 0x6f, 0x64,
+	0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6d, 0x6f, 0x64, 0x65, 0x1a, 0x3d, 0x0a,
+	0x0f, 0x4e, 0x6f, 0x64, 0x65, 0x45, 0x70, 0x6f, 0x63, 0x68, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
+	0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x1a, 0x43, 0x0a, 0x15,
+	0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38,
+	0x01, 0x42, 0x0d, 0x5a, 0x0b, 0x70, 0x62, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_ClusterHealth_proto_rawDescOnce sync.Once
+	file_ClusterHealth_proto_rawDescData = file_ClusterHealth_proto_rawDesc
+)
+
+func file_ClusterHealth_proto_rawDescGZIP() []byte {
+	file_ClusterHealth_proto_rawDescOnce.Do(func() {
+		file_ClusterHealth_proto_rawDescData = protoimpl.X.CompressGZIP(file_ClusterHealth_proto_rawDescData)
+	})
+	return file_ClusterHealth_proto_rawDescData
+}
+
+var file_ClusterHealth_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_ClusterHealth_proto_goTypes = []interface{}{
+	(*ClusterHealth)(nil), // 0: protobuf.ClusterHealth
+	nil,                   // 1: protobuf.ClusterHealth.NodeEpochsEntry
+	nil,                   // 2: protobuf.ClusterHealth.MembershipEventsEntry
+}
+var file_ClusterHealth_proto_depIdxs = []int32{
+	1, // 0: protobuf.ClusterHealth.nodeEpochs:type_name -> protobuf.ClusterHealth.NodeEpochsEntry
+	2, // 1: protobuf.ClusterHealth.membershipEvents:type_name -> protobuf.ClusterHealth.MembershipEventsEntry
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_ClusterHealth_proto_init() }
+func file_ClusterHealth_proto_init() {
+	if File_ClusterHealth_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_ClusterHealth_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ClusterHealth); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_ClusterHealth_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_ClusterHealth_proto_goTypes,
+		DependencyIndexes: file_ClusterHealth_proto_depIdxs,
+		MessageInfos:      file_ClusterHealth_proto_msgTypes,
+	}.Build()
+	File_ClusterHealth_proto = out.File
+	file_ClusterHealth_proto_rawDesc = nil
+	file_ClusterHealth_proto_goTypes = nil
+	file_ClusterHealth_proto_depIdxs = nil
+}
@@ -25,12 +25,29 @@ type KVRequest struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Command uint32 `protobuf:"varint,1,opt,name=command,proto3" json:"command,omitempty"`
-	Key     []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
-	Value   []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
-	Version int32  `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`
-	Addr    []byte `protobuf:"bytes,5,opt,name=addr,proto3" json:"addr,omitempty"`
-	Check   int32  `protobuf:"varint,6,opt,name=check,proto3" json:"check,omitempty"`
+	Command         uint32            `protobuf:"varint,1,opt,name=command,proto3" json:"command,omitempty"`
+	Key             []byte            `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Value           []byte            `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Version         int32             `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`
+	Addr            []byte            `protobuf:"bytes,5,opt,name=addr,proto3" json:"addr,omitempty"`
+	Check           int32             `protobuf:"varint,6,opt,name=check,proto3" json:"check,omitempty"`
+	RangeStart      uint32            `protobuf:"varint,7,opt,name=rangeStart,proto3" json:"rangeStart,omitempty"`
+	RangeEnd        uint32            `protobuf:"varint,8,opt,name=rangeEnd,proto3" json:"rangeEnd,omitempty"`
+	NodeList        map[string][]byte `protobuf:"bytes,9,rep,name=nodeList,proto3" json:"nodeList,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Incarnation     uint64            `protobuf:"varint,10,opt,name=incarnation,proto3" json:"incarnation,omitempty"`
+	Digest          uint64            `protobuf:"varint,11,opt,name=digest,proto3" json:"digest,omitempty"`
+	Wanted          []string          `protobuf:"bytes,12,rep,name=wanted,proto3" json:"wanted,omitempty"`
+	Limit           uint32            `protobuf:"varint,13,opt,name=limit,proto3" json:"limit,omitempty"`
+	TtlMs           uint64            `protobuf:"varint,14,opt,name=ttlMs,proto3" json:"ttlMs,omitempty"`
+	Condition       uint32            `protobuf:"varint,15,opt,name=condition,proto3" json:"condition,omitempty"`
+	Delta           int64             `protobuf:"varint,16,opt,name=delta,proto3" json:"delta,omitempty"`
+	UnsignedCounter bool              `protobuf:"varint,17,opt,name=unsignedCounter,proto3" json:"unsignedCounter,omitempty"`
+	ForwardMode     string            `protobuf:"bytes,18,opt,name=forwardMode,proto3" json:"forwardMode,omitempty"`
+	Hops            uint32            `protobuf:"varint,19,opt,name=hops,proto3" json:"hops,omitempty"`
+	Trace           []string          `protobuf:"bytes,20,rep,name=trace,proto3" json:"trace,omitempty"`
+	RingEpoch       uint64            `protobuf:"varint,21,opt,name=ringEpoch,proto3" json:"ringEpoch,omitempty"`
+	Flags           uint32            `protobuf:"varint,22,opt,name=flags,proto3" json:"flags,omitempty"`
+	WriteTime       int64             `protobuf:"varint,23,opt,name=writeTime,proto3" json:"writeTime,omitempty"`
 }
 
 func (x *KVRequest) Reset() {
@@ -107,12 +124,131 @@ func (x *KVRequest) GetCheck() int32 {
 	return 0
 }
 
+func (x *KVRequest) GetRangeStart() uint32 {
+	if x != nil {
+		return x.RangeStart
+	}
+	return 0
+}
+
+func (x *KVRequest) GetRangeEnd() uint32 {
+	if x != nil {
+		return x.RangeEnd
+	}
+	return 0
+}
+
+func (x *KVRequest) GetNodeList() map[string][]byte {
+	if x != nil {
+		return x.NodeList
+	}
+	return nil
+}
+
+func (x *KVRequest) GetIncarnation() uint64 {
+	if x != nil {
+		return x.Incarnation
+	}
+	return 0
+}
+
+func (x *KVRequest) GetDigest() uint64 {
+	if x != nil {
+		return x.Digest
+	}
+	return 0
+}
+
+func (x *KVRequest) GetWanted() []string {
+	if x != nil {
+		return x.Wanted
+	}
+	return nil
+}
+
+func (x *KVRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *KVRequest) GetTtlMs() uint64 {
+	if x != nil {
+		return x.TtlMs
+	}
+	return 0
+}
+
+func (x *KVRequest) GetCondition() uint32 {
+	if x != nil {
+		return x.Condition
+	}
+	return 0
+}
+
+func (x *KVRequest) GetDelta() int64 {
+	if x != nil {
+		return x.Delta
+	}
+	return 0
+}
+
+func (x *KVRequest) GetUnsignedCounter() bool {
+	if x != nil {
+		return x.UnsignedCounter
+	}
+	return false
+}
+
+func (x *KVRequest) GetForwardMode() string {
+	if x != nil {
+		return x.ForwardMode
+	}
+	return ""
+}
+
+func (x *KVRequest) GetHops() uint32 {
+	if x != nil {
+		return x.Hops
+	}
+	return 0
+}
+
+func (x *KVRequest) GetTrace() []string {
+	if x != nil {
+		return x.Trace
+	}
+	return nil
+}
+
+func (x *KVRequest) GetRingEpoch() uint64 {
+	if x != nil {
+		return x.RingEpoch
+	}
+	return 0
+}
+
+func (x *KVRequest) GetFlags() uint32 {
+	if x != nil {
+		return x.Flags
+	}
+	return 0
+}
+
+func (x *KVRequest) GetWriteTime() int64 {
+	if x != nil {
+		return x.WriteTime
+	}
+	return 0
+}
+
 var File_KeyValueRequest_proto protoreflect.FileDescriptor
 
 var file_KeyValueRequest_proto_rawDesc = []byte{
 	0x0a, 0x15, 0x4b, 0x65, 0x79, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
 	0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
-	0x66, 0x22, 0x91, 0x01, 0x0a, 0x09, 0x4b, 0x56, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x66, 0x22, 0xc3, 0x05, 0x0a, 0x09, 0x4b, 0x56, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
 	0x18, 0x0a, 0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
 	0x52, 0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
 	0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76,
@@ -121,8 +257,43 @@ var file_KeyValueRequest_proto_rawDesc = []byte{
 	0x28, 0x05, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x61,
 	0x64, 0x64, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x61, 0x64, 0x64, 0x72, 0x12,
 	0x14, 0x0a, 0x05, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05,
-	0x63, 0x68, 0x65, 0x63, 0x6b, 0x42, 0x0d, 0x5a, 0x0b, 0x70, 0x62, 0x2f, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x62, 0x75, 0x66, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x63, 0x68, 0x65, 0x63, 0x6b, 0x12, 0x1e, 0x0a, 0x0a, 0x72, 0x61, 0x6e, 0x67, 0x65, 0x53, 0x74,
+	0x61, 0x72, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x72, 0x61, 0x6e, 0x67, 0x65,
+	0x53, 0x74, 0x61, 0x72, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x61, 0x6e, 0x67, 0x65, 0x45, 0x6e,
+	0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x72, 0x61, 0x6e, 0x67, 0x65, 0x45, 0x6e,
+	0x64, 0x12, 0x3d, 0x0a, 0x08, 0x6e, 0x6f, 0x64, 0x65, 0x4c, 0x69, 0x73, 0x74, 0x18, 0x09, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4b,
+	0x56, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x4c, 0x69, 0x73,
+	0x74, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x08, 0x6e, 0x6f, 0x64, 0x65, 0x4c, 0x69, 0x73, 0x74,
+	0x12, 0x20, 0x0a, 0x0b, 0x69, 0x6e, 0x63, 0x61, 0x72, 0x6e, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18,
+	0x0a, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b, 0x69, 0x6e, 0x63, 0x61, 0x72, 0x6e, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x64, 0x69, 0x67, 0x65, 0x73, 0x74, 0x18, 0x0b, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x06, 0x64, 0x69, 0x67, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x61,
+	0x6e, 0x74, 0x65, 0x64, 0x18, 0x0c, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x77, 0x61, 0x6e, 0x74,
+	0x65, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x0d, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x74, 0x6c, 0x4d,
+	0x73, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x74, 0x74, 0x6c, 0x4d, 0x73, 0x12, 0x1c,
+	0x0a, 0x09, 0x63, 0x6f, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0f, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x09, 0x63, 0x6f, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05,
+	0x64, 0x65, 0x6c, 0x74, 0x61, 0x18, 0x10, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x64, 0x65, 0x6c,
+	0x74, 0x61, 0x12, 0x28, 0x0a, 0x0f, 0x75, 0x6e, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x43, 0x6f,
+	0x75, 0x6e, 0x74, 0x65, 0x72, 0x18, 0x11, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0f, 0x75, 0x6e, 0x73,
+	0x69, 0x67, 0x6e, 0x65, 0x64, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x65, 0x72, 0x12, 0x20, 0x0a, 0x0b,
+	0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x4d, 0x6f, 0x64, 0x65, 0x18, 0x12, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0b, 0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x4d, 0x6f, 0x64, 0x65, 0x12, 0x12,
+	0x0a, 0x04, 0x68, 0x6f, 0x70, 0x73, 0x18, 0x13, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x04, 0x68, 0x6f,
+	0x70, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x72, 0x61, 0x63, 0x65, 0x18, 0x14, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x05, 0x74, 0x72, 0x61, 0x63, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x72, 0x69, 0x6e, 0x67,
+	0x45, 0x70, 0x6f, 0x63, 0x68, 0x18, 0x15, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x72, 0x69, 0x6e,
+	0x67, 0x45, 0x70, 0x6f, 0x63, 0x68, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x6c, 0x61, 0x67, 0x73, 0x18,
+	0x16, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x66, 0x6c, 0x61, 0x67, 0x73, 0x12, 0x1c, 0x0a, 0x09,
+	0x77, 0x72, 0x69, 0x74, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x18, 0x17, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x09, 0x77, 0x72, 0x69, 0x74, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x1a, 0x3b, 0x0a, 0x0d, 0x4e, 0x6f,
+	0x64, 0x65, 0x4c, 0x69, 0x73, 0x74, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b,
+	0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x42, 0x0d, 0x5a, 0x0b, 0x70, 0x62, 0x2f, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -137,16 +308,18 @@ func file_KeyValueRequest_proto_rawDescGZIP() []byte {
 	return file_KeyValueRequest_proto_rawDescData
 }
 
-var file_KeyValueRequest_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_KeyValueRequest_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_KeyValueRequest_proto_goTypes = []interface{}{
 	(*KVRequest)(nil), // 0: protobuf.KVRequest
+	nil,               // 1: protobuf.KVRequest.NodeListEntry
 }
 var file_KeyValueRequest_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: protobuf.KVRequest.nodeList:type_name -> protobuf.KVRequest.NodeListEntry
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_KeyValueRequest_proto_init() }
@@ -174,7 +347,7 @@ func file_KeyValueRequest_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_KeyValueRequest_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
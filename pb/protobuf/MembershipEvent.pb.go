@@ -0,0 +1,176 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.17.1
+// source: MembershipEvent.proto
+
+package protobuf
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type MembershipEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type     string   `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Node     *NodeVal `protobuf:"bytes,2,opt,name=node,proto3" json:"node,omitempty"`
+	Previous string   `protobuf:"bytes,3,opt,name=previous,proto3" json:"previous,omitempty"`
+	Time     uint64   `protobuf:"varint,4,opt,name=time,proto3" json:"time,omitempty"`
+}
+
+func (x *MembershipEvent) Reset() {
+	*x = MembershipEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_MembershipEvent_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MembershipEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MembershipEvent) ProtoMessage() {}
+
+func (x *MembershipEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_MembershipEvent_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MembershipEvent.ProtoReflect.Descriptor instead.
+func (*MembershipEvent) Descriptor() ([]byte, []int) {
+	return file_MembershipEvent_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MembershipEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *MembershipEvent) GetNode() *NodeVal {
+	if x != nil {
+		return x.Node
+	}
+	return nil
+}
+
+func (x *MembershipEvent) GetPrevious() string {
+	if x != nil {
+		return x.Previous
+	}
+	return ""
+}
+
+func (x *MembershipEvent) GetTime() uint64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+var File_MembershipEvent_proto protoreflect.FileDescriptor
+
+var file_MembershipEvent_proto_rawDesc = []byte{
+	0x0a, 0x15, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x45, 0x76, 0x65, 0x6e,
+	0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x1a, 0x0d, 0x4e, 0x6f, 0x64, 0x65, 0x56, 0x61, 0x6c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x22, 0x7c, 0x0a, 0x0f, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x45, 0x76,
+	0x65, 0x6e, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x25, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x56, 0x61, 0x6c, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x12, 0x1a,
+	0x0a, 0x08, 0x70, 0x72, 0x65, 0x76, 0x69, 0x6f, 0x75, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x70, 0x72, 0x65, 0x76, 0x69, 0x6f, 0x75, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69,
+	0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x42, 0x0d,
+	0x5a, 0x0b, 0x70, 0x62, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_MembershipEvent_proto_rawDescOnce sync.Once
+	file_MembershipEvent_proto_rawDescData = file_MembershipEvent_proto_rawDesc
+)
+
+func file_MembershipEvent_proto_rawDescGZIP() []byte {
+	file_MembershipEvent_proto_rawDescOnce.Do(func() {
+		file_MembershipEvent_proto_rawDescData = protoimpl.X.CompressGZIP(file_MembershipEvent_proto_rawDescData)
+	})
+	return file_MembershipEvent_proto_rawDescData
+}
+
+var file_MembershipEvent_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_MembershipEvent_proto_goTypes = []interface{}{
+	(*MembershipEvent)(nil), // 0: protobuf.MembershipEvent
+	(*NodeVal)(nil),         // 1: protobuf.NodeVal
+}
+var file_MembershipEvent_proto_depIdxs = []int32{
+	1, // 0: protobuf.MembershipEvent.node:type_name -> protobuf.NodeVal
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_MembershipEvent_proto_init() }
+func file_MembershipEvent_proto_init() {
+	if File_MembershipEvent_proto != nil {
+		return
+	}
+	file_NodeVal_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_MembershipEvent_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MembershipEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_MembershipEvent_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_MembershipEvent_proto_goTypes,
+		DependencyIndexes: file_MembershipEvent_proto_depIdxs,
+		MessageInfos:      file_MembershipEvent_proto_msgTypes,
+	}.Build()
+	File_MembershipEvent_proto = out.File
+	file_MembershipEvent_proto_rawDesc = nil
+	file_MembershipEvent_proto_goTypes = nil
+	file_MembershipEvent_proto_depIdxs = nil
+}
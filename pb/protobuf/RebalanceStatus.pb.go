@@ -0,0 +1,194 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.17.1
+// source: RebalanceStatus.proto
+
+package protobuf
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RebalanceStatus struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	State            string `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"`
+	RangesPending    uint32 `protobuf:"varint,2,opt,name=rangesPending,proto3" json:"rangesPending,omitempty"`
+	KeysMoved        uint64 `protobuf:"varint,3,opt,name=keysMoved,proto3" json:"keysMoved,omitempty"`
+	BytesMoved       uint64 `protobuf:"varint,4,opt,name=bytesMoved,proto3" json:"bytesMoved,omitempty"`
+	BytesPerSecLimit uint64 `protobuf:"varint,5,opt,name=bytesPerSecLimit,proto3" json:"bytesPerSecLimit,omitempty"`
+	OpsPerSecLimit   uint32 `protobuf:"varint,6,opt,name=opsPerSecLimit,proto3" json:"opsPerSecLimit,omitempty"`
+}
+
+func (x *RebalanceStatus) Reset() {
+	*x = RebalanceStatus{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_RebalanceStatus_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RebalanceStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RebalanceStatus) ProtoMessage() {}
+
+func (x *RebalanceStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_RebalanceStatus_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RebalanceStatus.ProtoReflect.Descriptor instead.
+func (*RebalanceStatus) Descriptor() ([]byte, []int) {
+	return file_RebalanceStatus_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RebalanceStatus) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *RebalanceStatus) GetRangesPending() uint32 {
+	if x != nil {
+		return x.RangesPending
+	}
+	return 0
+}
+
+func (x *RebalanceStatus) GetKeysMoved() uint64 {
+	if x != nil {
+		return x.KeysMoved
+	}
+	return 0
+}
+
+func (x *RebalanceStatus) GetBytesMoved() uint64 {
+	if x != nil {
+		return x.BytesMoved
+	}
+	return 0
+}
+
+func (x *RebalanceStatus) GetBytesPerSecLimit() uint64 {
+	if x != nil {
+		return x.BytesPerSecLimit
+	}
+	return 0
+}
+
+func (x *RebalanceStatus) GetOpsPerSecLimit() uint32 {
+	if x != nil {
+		return x.OpsPerSecLimit
+	}
+	return 0
+}
+
+var File_RebalanceStatus_proto protoreflect.FileDescriptor
+
+var file_RebalanceStatus_proto_rawDesc = []byte{
+	0x0a, 0x15, 0x52, 0x65, 0x62, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x65, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x22, 0xdf, 0x01, 0x0a, 0x0f, 0x52, 0x65, 0x62, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x65, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x24, 0x0a, 0x0d, 0x72,
+	0x61, 0x6e, 0x67, 0x65, 0x73, 0x50, 0x65, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x0d, 0x72, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x50, 0x65, 0x6e, 0x64, 0x69, 0x6e,
+	0x67, 0x12, 0x1c, 0x0a, 0x09, 0x6b, 0x65, 0x79, 0x73, 0x4d, 0x6f, 0x76, 0x65, 0x64, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x6b, 0x65, 0x79, 0x73, 0x4d, 0x6f, 0x76, 0x65, 0x64, 0x12,
+	0x1e, 0x0a, 0x0a, 0x62, 0x79, 0x74, 0x65, 0x73, 0x4d, 0x6f, 0x76, 0x65, 0x64, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x0a, 0x62, 0x79, 0x74, 0x65, 0x73, 0x4d, 0x6f, 0x76, 0x65, 0x64, 0x12,
+	0x2a, 0x0a, 0x10, 0x62, 0x79, 0x74, 0x65, 0x73, 0x50, 0x65, 0x72, 0x53, 0x65, 0x63, 0x4c, 0x69,
+	0x6d, 0x69, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x10, 0x62, 0x79, 0x74, 0x65, 0x73,
+	0x50, 0x65, 0x72, 0x53, 0x65, 0x63, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x12, 0x26, 0x0a, 0x0e, 0x6f,
+	0x70, 0x73, 0x50, 0x65, 0x72, 0x53, 0x65, 0x63, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x0e, 0x6f, 0x70, 0x73, 0x50, 0x65, 0x72, 0x53, 0x65, 0x63, 0x4c, 0x69,
+	0x6d, 0x69, 0x74, 0x42, 0x0d, 0x5a, 0x0b, 0x70, 0x62, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_RebalanceStatus_proto_rawDescOnce sync.Once
+	file_RebalanceStatus_proto_rawDescData = file_RebalanceStatus_proto_rawDesc
+)
+
+func file_RebalanceStatus_proto_rawDescGZIP() []byte {
+	file_RebalanceStatus_proto_rawDescOnce.Do(func() {
+		file_RebalanceStatus_proto_rawDescData = protoimpl.X.CompressGZIP(file_RebalanceStatus_proto_rawDescData)
+	})
+	return file_RebalanceStatus_proto_rawDescData
+}
+
+var file_RebalanceStatus_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_RebalanceStatus_proto_goTypes = []interface{}{
+	(*RebalanceStatus)(nil), // 0: protobuf.RebalanceStatus
+}
+var file_RebalanceStatus_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_RebalanceStatus_proto_init() }
+func file_RebalanceStatus_proto_init() {
+	if File_RebalanceStatus_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_RebalanceStatus_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RebalanceStatus); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_RebalanceStatus_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_RebalanceStatus_proto_goTypes,
+		DependencyIndexes: file_RebalanceStatus_proto_depIdxs,
+		MessageInfos:      file_RebalanceStatus_proto_msgTypes,
+	}.Build()
+	File_RebalanceStatus_proto = out.File
+	file_RebalanceStatus_proto_rawDesc = nil
+	file_RebalanceStatus_proto_goTypes = nil
+	file_RebalanceStatus_proto_depIdxs = nil
+}
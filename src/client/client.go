@@ -0,0 +1,621 @@
+// Package client is a client of the key-value store that keeps a copy of
+// the hash ring, so a request goes straight to the node owning its key
+// instead of being forwarded by the node it reaches.
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"net"
+	pb "pa2/pb/protobuf"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// List of commands that can be sent to the server
+const (
+	PUT                 = 0x01
+	GET                 = 0x02
+	REMOVE              = 0x03
+	SHUTDOWN            = 0x04
+	WIPEOUT             = 0x05
+	IS_ALIVE            = 0x06
+	GET_PID             = 0x07
+	GET_MEMBERSHIP_CNT  = 0x08
+	GET_MEMBERSHIP_LIST = 0x22
+	CLUSTER_HEALTH      = 0x57
+	SCAN                = 0x58
+	EXPIRE              = 0x59
+	INCR                = 0x5b
+	PUT_IF              = 0x5d
+	GET_RING            = 0x63
+)
+
+// List of errors that can be returned to client
+const (
+	NO_ERR               = 0x00
+	KEY_DNE_ERR          = 0x01
+	NO_SPC_ERR           = 0x02
+	SYS_OVERLOAD_ERR     = 0x03
+	KV_INTERNAL_ERR      = 0x04
+	UNKNOWN_CMD_ERR      = 0x05
+	INVALID_KEY_ERR      = 0x06
+	INVALID_VAL_ERR      = 0x07
+	NO_QUORUM_ERR        = 0x08
+	CONDITION_FAILED_ERR = 0x09
+	MOVED_ERR            = 0x0a
+	UNREACHABLE_ERR      = 0x0b
+	TOO_MANY_HOPS_ERR    = 0x0c
+)
+
+// Forward mode asking a node that doesn't own a key to tell the client
+// which node does
+const forwardRedirect = "redirect"
+
+// Largest message a node answers with
+const maxMsgBytes = 65536
+
+// Returned when no node answered a request in time
+var ErrTimeout = errors.New("no node answered in time")
+
+// Returned when the client knows no node to send a request to
+var ErrNoNodes = errors.New("no node to send the request to")
+
+// Error returned when a node answered with an error code
+type Error struct {
+	Code uint32
+}
+
+func (e *Error) Error() string {
+	switch e.Code {
+	case KEY_DNE_ERR:
+		return "key does not exist"
+	case NO_SPC_ERR:
+		return "no space left"
+	case SYS_OVERLOAD_ERR:
+		return "overloaded"
+	case KV_INTERNAL_ERR:
+		return "internal error"
+	case UNKNOWN_CMD_ERR:
+		return "unknown command"
+	case INVALID_KEY_ERR:
+		return "invalid key"
+	case INVALID_VAL_ERR:
+		return "invalid value"
+	case NO_QUORUM_ERR:
+		return "no quorum"
+	case CONDITION_FAILED_ERR:
+		return "condition not met"
+	case MOVED_ERR:
+		return "key owned by another node"
+	case UNREACHABLE_ERR:
+		return "no node holding the key answered"
+	case TOO_MANY_HOPS_ERR:
+		return "request forwarded too many times"
+	}
+	return fmt.Sprintf("error code %d", e.Code)
+}
+
+// Check whether an error says the key doesn't exist
+func IsNotFound(err error) bool {
+	var kvErr *Error
+	return errors.As(err, &kvErr) && kvErr.Code == KEY_DNE_ERR
+}
+
+// Get the error of a response, nil if it has none
+func respError(respPay *pb.KVResponse) error {
+	if respPay.ErrCode != NO_ERR {
+		return &Error{Code: respPay.ErrCode}
+	}
+	return nil
+}
+
+// Options of a client. Zero values are replaced by the defaults.
+type Options struct {
+	// Time to wait for the first response of a node, 500ms by default.
+	// It doubles every time the request is sent again
+	Timeout time.Duration
+	// Number of times a request is sent again after a timeout, a
+	// redirect or an overload, 3 by default
+	Retries int
+}
+
+// A client of the cluster. It is safe for concurrent use: every request
+// is sent from the same UDP socket and matched to its response by
+// message ID.
+type Client struct {
+	conn  *net.UDPConn
+	seeds []string
+	opts  Options
+
+	// Ring cached from the last node asked
+	ring       *ring
+	ringMutex  sync.RWMutex
+	refreshing int32
+
+	// Requests waiting for their response, by message ID
+	pending      map[string]chan *pb.KVResponse
+	pendingMutex sync.Mutex
+
+	// Used to spread the requests that have no key over the nodes
+	next uint32
+	// Closed once the client is closed
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Create a client and fetch the ring from the first seed that answers
+//
+// Arguments:
+//		seeds: addresses ("ip:port") of nodes of the cluster
+//		opts: options of the client
+// Returns:
+//		The client, or an error if no seed answered
+func New(seeds []string, opts Options) (*Client, error) {
+	if len(seeds) == 0 {
+		return nil, ErrNoNodes
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 500 * time.Millisecond
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = 3
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		seeds:   seeds,
+		opts:    opts,
+		ring:    &ring{},
+		pending: make(map[string]chan *pb.KVResponse),
+		closed:  make(chan struct{}),
+	}
+	go c.readResponses()
+
+	if err := c.Refresh(context.Background()); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close the socket of the client. Requests still waiting fail. Closing
+// it again does nothing.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// Get the addresses of the nodes in the cached ring, in ring order
+func (c *Client) Nodes() []string {
+	r := c.getRing()
+	return append([]string{}, r.addrs...)
+}
+
+// Get the address of the node owning a key in the cached ring
+func (c *Client) Owner(key []byte) string {
+	return c.getRing().nodeFor(key, 0)
+}
+
+// A node of the ring and its position
+type RingNode struct {
+	Addr string
+	Hash uint32
+}
+
+// Get the cached ring, in ring order, and its epoch
+func (c *Client) Ring() ([]RingNode, uint64) {
+	r := c.getRing()
+	nodes := make([]RingNode, len(r.addrs))
+	for i, addr := range r.addrs {
+		nodes[i] = RingNode{Addr: addr, Hash: r.hashes[i]}
+	}
+	return nodes, r.epoch
+}
+
+// Get the position of a key on the ring
+func KeyHash(key []byte) uint32 {
+	return hashKeyfromKey(key)
+}
+
+func (c *Client) getRing() *ring {
+	c.ringMutex.RLock()
+	defer c.ringMutex.RUnlock()
+	return c.ring
+}
+
+// Fetch the ring again, from the nodes of the cached ring then the seeds
+func (c *Client) Refresh(ctx context.Context) error {
+	return c.refreshFrom(ctx, "")
+}
+
+// Fetch the ring again, from a given node first if there is one
+func (c *Client) refreshFrom(ctx context.Context, first string) error {
+	var addrs []string
+	if first != "" {
+		addrs = append(addrs, first)
+	}
+	addrs = append(addrs, c.getRing().addrs...)
+	addrs = append(addrs, c.seeds...)
+
+	err := ErrNoNodes
+	for _, addr := range addrs {
+		var respPay *pb.KVResponse
+		respPay, err = c.DoAt(ctx, addr, &pb.KVRequest{Command: GET_RING})
+		if err == nil {
+			err = respError(respPay)
+		}
+		if err == nil && len(respPay.NodeList) > 0 {
+			c.ringMutex.Lock()
+			c.ring = newRing(respPay.NodeList, respPay.RingEpoch)
+			c.ringMutex.Unlock()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// Fetch the ring again in the background, unless it is already being
+// fetched
+func (c *Client) refreshInBackground() {
+	if !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		_ = c.Refresh(context.Background())
+	}()
+}
+
+// Check whether a command is routed to the node owning its key
+func routedByKey(cmd uint32) bool {
+	switch cmd {
+	case PUT, GET, REMOVE, EXPIRE, INCR, PUT_IF:
+		return true
+	}
+	return false
+}
+
+// Send a request and wait for its response. A request for a key goes to
+// the node owning it in the cached ring, which redirects the client when
+// its ring is out of date: the ring is fetched again and the request
+// follows the redirect. When the owner doesn't answer, the request goes
+// to the next nodes of the ring, which forward it as usual. Requests
+// without a key go to any node.
+//
+// Every attempt sends the same message ID, see call, and waits twice as
+// long as the previous one. An overloaded node is sent the request again
+// after the time it asked to wait.
+//
+// Arguments:
+//		ctx: context of the request, cancelling it stops the retries
+//		reqPay: request payload
+// Returns:
+//		The response, or an error if no node answered. Error codes are
+//		returned in the response.
+func (c *Client) Do(ctx context.Context, reqPay *pb.KVRequest) (*pb.KVResponse, error) {
+	if !routedByKey(reqPay.Command) {
+		return c.DoAt(ctx, c.anyNode(), reqPay)
+	}
+	reqPay = proto.Clone(reqPay).(*pb.KVRequest)
+	cl := c.newCall()
+	defer c.endCall(cl)
+
+	var err error
+	next := ""
+	failovers := 0
+	timeout := c.opts.Timeout
+	for attempt := 0; attempt <= c.opts.Retries; attempt++ {
+		r := c.getRing()
+		addr := next
+		if addr == "" {
+			addr = r.nodeFor(reqPay.Key, failovers)
+		}
+		if addr == "" {
+			return nil, ErrNoNodes
+		}
+		// A node standing in for the owner forwards the request as usual
+		if failovers == 0 {
+			reqPay.ForwardMode = forwardRedirect
+		} else {
+			reqPay.ForwardMode = ""
+		}
+		reqPay.RingEpoch = r.epoch
+
+		if err = c.send(addr, cl, reqPay); err != nil {
+			return nil, err
+		}
+		var respPay *pb.KVResponse
+		respPay, err = c.wait(ctx, cl, timeout)
+		if err == ErrTimeout {
+			failovers++
+			next = ""
+			timeout *= 2
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch respPay.ErrCode {
+		case MOVED_ERR:
+			if respPay.RingEpoch != r.epoch {
+				_ = c.refreshFrom(ctx, addr)
+			}
+			next = respPay.Owner
+			err = respError(respPay)
+			continue
+		case SYS_OVERLOAD_ERR:
+			if attempt == c.opts.Retries {
+				return respPay, nil
+			}
+			if err = sleepContext(ctx, overloadWait(respPay)); err != nil {
+				return nil, err
+			}
+			next = addr
+			continue
+		}
+		if respPay.RingEpoch != 0 && respPay.RingEpoch != r.epoch {
+			c.refreshInBackground()
+		}
+		return respPay, nil
+	}
+	return nil, err
+}
+
+// Send a request to a given node and wait for its response, sending it
+// again like Do when it times out or the node is overloaded
+//
+// Arguments:
+//		ctx: context of the request, cancelling it stops the retries
+//		addr: address of the node
+//		reqPay: request payload
+// Returns:
+//		The response, or an error if the node didn't answer
+func (c *Client) DoAt(ctx context.Context, addr string, reqPay *pb.KVRequest) (*pb.KVResponse, error) {
+	cl := c.newCall()
+	defer c.endCall(cl)
+
+	var err error
+	timeout := c.opts.Timeout
+	for attempt := 0; attempt <= c.opts.Retries; attempt++ {
+		if err = c.send(addr, cl, reqPay); err != nil {
+			return nil, err
+		}
+		var respPay *pb.KVResponse
+		respPay, err = c.wait(ctx, cl, timeout)
+		if err == ErrTimeout {
+			timeout *= 2
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if respPay.ErrCode == SYS_OVERLOAD_ERR && attempt < c.opts.Retries {
+			if err = sleepContext(ctx, overloadWait(respPay)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return respPay, nil
+	}
+	return nil, err
+}
+
+// Get how long an overloaded node asked to wait before sending the
+// request again
+func overloadWait(respPay *pb.KVResponse) time.Duration {
+	if respPay.OverloadWaitTime <= 0 {
+		return 0
+	}
+	return time.Duration(respPay.OverloadWaitTime) * time.Millisecond
+}
+
+// Wait for a delay, or until the context is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get a node to send a request without a key to
+func (c *Client) anyNode() string {
+	addrs := c.getRing().addrs
+	if len(addrs) == 0 {
+		addrs = c.seeds
+	}
+	return addrs[int(atomic.AddUint32(&c.next, 1))%len(addrs)]
+}
+
+// Send a request to a node without waiting for a response, for commands
+// that get none like SHUTDOWN
+func (c *Client) Notify(addr string, reqPay *pb.KVRequest) error {
+	cl := c.newCall()
+	defer c.endCall(cl)
+	return c.send(addr, cl, reqPay)
+}
+
+// Get the value and version of a key
+func (c *Client) Get(ctx context.Context, key []byte) ([]byte, int32, error) {
+	respPay, err := c.Do(ctx, &pb.KVRequest{Command: GET, Key: key})
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := respError(respPay); err != nil {
+		return nil, 0, err
+	}
+	return respPay.Value, respPay.Version, nil
+}
+
+// Store the value of a key
+func (c *Client) Put(ctx context.Context, key []byte, value []byte, version int32) error {
+	respPay, err := c.Do(ctx, &pb.KVRequest{Command: PUT, Key: key, Value: value, Version: version})
+	if err != nil {
+		return err
+	}
+	return respError(respPay)
+}
+
+// Remove a key
+func (c *Client) Remove(ctx context.Context, key []byte) error {
+	respPay, err := c.Do(ctx, &pb.KVRequest{Command: REMOVE, Key: key})
+	if err != nil {
+		return err
+	}
+	return respError(respPay)
+}
+
+// Generate a unique message ID in the following format:
+//    bytes           field
+//    0 - 3      Client IP address
+//    4 - 5      Client port number
+//    6 - 7      Randomly generated
+//    8 - 15     Timestamp in nanoseconds
+func (c *Client) newMsgID() []byte {
+	uniqueID := make([]byte, 16)
+	localAddr := c.conn.LocalAddr().(*net.UDPAddr)
+	if ip := localAddr.IP.To4(); ip != nil {
+		copy(uniqueID[0:4], ip)
+	}
+	binary.LittleEndian.PutUint16(uniqueID[4:6], uint16(localAddr.Port))
+	uniqueID[6] = uint8(rand.Intn(math.MaxUint8))
+	uniqueID[7] = uint8(rand.Intn(math.MaxUint8))
+	binary.LittleEndian.PutUint64(uniqueID[8:], uint64(time.Now().UnixNano()))
+	return uniqueID
+}
+
+// Get the CRC-32 IEEE checksum of the message ID and payload
+func getChecksum(messageID []byte, payload []byte) uint64 {
+	return uint64(crc32.ChecksumIEEE(append(append([]byte{}, messageID...), payload...)))
+}
+
+// A request waiting for its response. Every attempt of the request sends
+// the same message ID: a node that already handled it answers from its
+// response cache instead of running it again, so a retried PUT or REMOVE
+// takes effect once, and a late response to any attempt is accepted.
+type call struct {
+	msgID   []byte
+	waiting chan *pb.KVResponse
+}
+
+// Start a call, with a new message ID
+func (c *Client) newCall() *call {
+	cl := &call{msgID: c.newMsgID(), waiting: make(chan *pb.KVResponse, 1)}
+	c.pendingMutex.Lock()
+	c.pending[string(cl.msgID)] = cl.waiting
+	c.pendingMutex.Unlock()
+	return cl
+}
+
+// Stop waiting for the response of a call
+func (c *Client) endCall(cl *call) {
+	c.pendingMutex.Lock()
+	delete(c.pending, string(cl.msgID))
+	c.pendingMutex.Unlock()
+}
+
+// Send one attempt of a call to a node
+func (c *Client) send(addr string, cl *call, reqPay *pb.KVRequest) error {
+	nodeAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	reqPayBytes, err := proto.Marshal(reqPay)
+	if err != nil {
+		return err
+	}
+	reqMsgBytes, err := proto.Marshal(&pb.Msg{
+		MessageID: cl.msgID,
+		Payload:   reqPayBytes,
+		CheckSum:  getChecksum(cl.msgID, reqPayBytes),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.WriteToUDP(reqMsgBytes, nodeAddr)
+	return err
+}
+
+// Wait for the response of a call
+//
+// Returns:
+//		The response, ErrTimeout if it didn't come in time, or the error
+//		of the context
+func (c *Client) wait(ctx context.Context, cl *call, timeout time.Duration) (*pb.KVResponse, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case respPay := <-cl.waiting:
+		return respPay, nil
+	case <-timer.C:
+		return nil, ErrTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, errors.New("client closed")
+	}
+}
+
+// Hand every response to the request waiting for it, until the socket
+// is closed. Responses can come from another node than the one the
+// request was sent to.
+func (c *Client) readResponses() {
+	buffer := make([]byte, maxMsgBytes)
+	for {
+		numBytes, _, err := c.conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return
+			default:
+				continue
+			}
+		}
+
+		respMsg := &pb.Msg{}
+		if err := proto.Unmarshal(buffer[:numBytes], respMsg); err != nil {
+			continue
+		}
+		if getChecksum(respMsg.MessageID, respMsg.Payload) != respMsg.CheckSum {
+			continue
+		}
+		respPay := &pb.KVResponse{}
+		if err := proto.Unmarshal(respMsg.Payload, respPay); err != nil {
+			continue
+		}
+
+		c.pendingMutex.Lock()
+		waiting, ok := c.pending[string(respMsg.MessageID)]
+		c.pendingMutex.Unlock()
+		if ok {
+			select {
+			case waiting <- respPay:
+			default:
+			}
+		}
+	}
+}
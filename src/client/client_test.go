@@ -0,0 +1,303 @@
+package client
+
+import (
+	"context"
+	"net"
+	pb "pa2/pb/protobuf"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Answer of a fake node to the n-th request for a key it got, counting
+// from 0, nil to not answer. other is the address of the other node.
+type handler func(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse
+
+// A node answering GET_RING with a ring of two nodes and other requests
+// with its handler
+type fakeNode struct {
+	conn    *net.UDPConn
+	ring    map[string][]byte
+	epoch   uint64
+	handler handler
+	other   string
+
+	sync.Mutex
+	requests []*pb.KVRequest
+	msgIDs   [][]byte
+	rings    int
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &fakeNode{conn: conn, epoch: 1}
+}
+
+func (f *fakeNode) addr() string {
+	return f.conn.LocalAddr().String()
+}
+
+func (f *fakeNode) serve() {
+	buffer := make([]byte, maxMsgBytes)
+	for {
+		numBytes, clientAddr, err := f.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		reqMsg := &pb.Msg{}
+		reqPay := &pb.KVRequest{}
+		if proto.Unmarshal(buffer[:numBytes], reqMsg) != nil || proto.Unmarshal(reqMsg.Payload, reqPay) != nil {
+			continue
+		}
+
+		f.Lock()
+		var respPay *pb.KVResponse
+		if reqPay.Command == GET_RING {
+			f.rings++
+			respPay = &pb.KVResponse{NodeList: f.ring, RingEpoch: f.epoch}
+		} else {
+			respPay = f.handler(reqPay, len(f.requests), f.other)
+			f.requests = append(f.requests, reqPay)
+			f.msgIDs = append(f.msgIDs, reqMsg.MessageID)
+		}
+		f.Unlock()
+		if respPay == nil {
+			continue
+		}
+
+		respPayBytes, _ := proto.Marshal(respPay)
+		respMsgBytes, _ := proto.Marshal(&pb.Msg{
+			MessageID: reqMsg.MessageID,
+			Payload:   respPayBytes,
+			CheckSum:  getChecksum(reqMsg.MessageID, respPayBytes),
+		})
+		f.conn.WriteToUDP(respMsgBytes, clientAddr)
+	}
+}
+
+func answer(errCode uint32) handler {
+	return func(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse {
+		return &pb.KVResponse{ErrCode: errCode, RingEpoch: 1}
+	}
+}
+
+func silent(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse {
+	return nil
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name  string
+		owner handler
+		other handler
+		// Error code of the response, or the error of Do
+		wantErrCode uint32
+		wantErr     error
+		// Number of requests each node gets
+		wantOwner, wantOther int
+		// Whether the owner is asked for the ring again
+		wantRefresh bool
+	}{
+		{
+			name:        "owner answers",
+			owner:       answer(NO_ERR),
+			other:       answer(NO_ERR),
+			wantErrCode: NO_ERR,
+			wantOwner:   1,
+		},
+		{
+			name:        "error code of the owner",
+			owner:       answer(KEY_DNE_ERR),
+			other:       answer(NO_ERR),
+			wantErrCode: KEY_DNE_ERR,
+			wantOwner:   1,
+		},
+		{
+			name:        "owner times out",
+			owner:       silent,
+			other:       answer(NO_ERR),
+			wantErrCode: NO_ERR,
+			wantOwner:   1,
+			wantOther:   1,
+		},
+		{
+			name: "redirect",
+			owner: func(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse {
+				return &pb.KVResponse{ErrCode: MOVED_ERR, Owner: other, RingEpoch: 1}
+			},
+			other:       answer(NO_ERR),
+			wantErrCode: NO_ERR,
+			wantOwner:   1,
+			wantOther:   1,
+		},
+		{
+			name: "redirect from a newer ring",
+			owner: func(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse {
+				return &pb.KVResponse{ErrCode: MOVED_ERR, Owner: other, RingEpoch: 2}
+			},
+			other:       answer(NO_ERR),
+			wantErrCode: NO_ERR,
+			wantOwner:   1,
+			wantOther:   1,
+			wantRefresh: true,
+		},
+		{
+			name: "overloaded once",
+			owner: func(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse {
+				if n == 0 {
+					return &pb.KVResponse{ErrCode: SYS_OVERLOAD_ERR, OverloadWaitTime: 1, RingEpoch: 1}
+				}
+				return &pb.KVResponse{ErrCode: NO_ERR, RingEpoch: 1}
+			},
+			other:       answer(NO_ERR),
+			wantErrCode: NO_ERR,
+			wantOwner:   2,
+		},
+		{
+			name:        "always overloaded",
+			owner:       answer(SYS_OVERLOAD_ERR),
+			other:       answer(NO_ERR),
+			wantErrCode: SYS_OVERLOAD_ERR,
+			wantOwner:   4,
+		},
+		{
+			name: "redirected forever",
+			owner: func(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse {
+				return &pb.KVResponse{ErrCode: MOVED_ERR, Owner: other, RingEpoch: 1}
+			},
+			other: func(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse {
+				return &pb.KVResponse{ErrCode: MOVED_ERR, Owner: other, RingEpoch: 1}
+			},
+			wantErr:   &Error{Code: MOVED_ERR},
+			wantOwner: 2,
+			wantOther: 2,
+		},
+		{
+			name:      "nobody answers",
+			owner:     silent,
+			other:     silent,
+			wantErr:   ErrTimeout,
+			wantOwner: 2,
+			wantOther: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := newFakeNode(t), newFakeNode(t)
+			nodeList := map[string][]byte{a.addr(): nil, b.addr(): nil}
+			a.ring, b.ring = nodeList, nodeList
+			a.other, b.other = b.addr(), a.addr()
+			a.handler, b.handler = silent, silent
+			go a.serve()
+			go b.serve()
+
+			c, err := New([]string{a.addr()}, Options{Timeout: 20 * time.Millisecond, Retries: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			key := []byte("key")
+			owner, other := a, b
+			if c.Owner(key) == b.addr() {
+				owner, other = b, a
+			}
+			owner.Lock()
+			owner.handler = tt.owner
+			owner.rings = 0
+			owner.Unlock()
+			other.Lock()
+			other.handler = tt.other
+			other.Unlock()
+
+			respPay, err := c.Do(context.Background(), &pb.KVRequest{Command: GET, Key: key})
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("got error %v", err)
+			} else if respPay.ErrCode != tt.wantErrCode {
+				t.Fatalf("got error code %#x, want %#x", respPay.ErrCode, tt.wantErrCode)
+			}
+
+			owner.Lock()
+			defer owner.Unlock()
+			other.Lock()
+			defer other.Unlock()
+			if len(owner.requests) != tt.wantOwner || len(other.requests) != tt.wantOther {
+				t.Fatalf("got %d requests to the owner and %d to the other node, want %d and %d",
+					len(owner.requests), len(other.requests), tt.wantOwner, tt.wantOther)
+			}
+			if refreshed := owner.rings > 0; refreshed != tt.wantRefresh {
+				t.Fatalf("got the ring fetched again %v, want %v", refreshed, tt.wantRefresh)
+			}
+			// The owner is asked to redirect, a node standing in for it
+			// to forward as usual
+			if len(owner.requests) > 0 && owner.requests[0].ForwardMode != forwardRedirect {
+				t.Fatalf("got forward mode %q to the owner, want %q", owner.requests[0].ForwardMode, forwardRedirect)
+			}
+			// Every attempt has the same message ID
+			for _, msgID := range append(owner.msgIDs, other.msgIDs...) {
+				if string(msgID) != string(owner.msgIDs[0]) {
+					t.Fatal("got attempts with different message IDs")
+				}
+			}
+		})
+	}
+}
+
+func TestDoAt(t *testing.T) {
+	f := newFakeNode(t)
+	f.ring = map[string][]byte{f.addr(): nil}
+	f.handler = func(reqPay *pb.KVRequest, n int, other string) *pb.KVResponse {
+		switch n {
+		case 0:
+			return nil
+		case 1:
+			return &pb.KVResponse{ErrCode: SYS_OVERLOAD_ERR, OverloadWaitTime: 1}
+		}
+		return &pb.KVResponse{ErrCode: NO_ERR}
+	}
+	go f.serve()
+
+	c, err := New([]string{f.addr()}, Options{Timeout: 20 * time.Millisecond, Retries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A timeout and an overload are retried on the same node
+	respPay, err := c.DoAt(context.Background(), f.addr(), &pb.KVRequest{Command: GET_PID})
+	if err != nil || respPay.ErrCode != NO_ERR {
+		t.Fatalf("got %v error %v, want no error", respPay, err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if len(f.requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(f.requests))
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	f := newFakeNode(t)
+	f.ring = map[string][]byte{f.addr(): nil}
+	go f.serve()
+
+	c, err := New([]string{f.addr()}, Options{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("got error %v closing again", err)
+	}
+}
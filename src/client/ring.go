@@ -0,0 +1,52 @@
+package client
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+// The hash ring as seen by a node, cached by the client
+type ring struct {
+	// Hashes of the nodes, sorted, and the address of the node at each
+	hashes []uint32
+	addrs  []string
+	// Epoch of the ring on the node it was fetched from
+	epoch uint64
+}
+
+// Build the ring of a membership list, keyed by node address
+func newRing(nodeList map[string][]byte, epoch uint64) *ring {
+	r := &ring{epoch: epoch}
+	for addr := range nodeList {
+		r.addrs = append(r.addrs, addr)
+	}
+	sort.Slice(r.addrs, func(i, j int) bool { return hashKey(r.addrs[i]) < hashKey(r.addrs[j]) })
+	for _, addr := range r.addrs {
+		r.hashes = append(r.hashes, hashKey(addr))
+	}
+	return r
+}
+
+// Get the position of the node owning a key: the first node clockwise
+// from the hash of the key, like the servers do
+func (r *ring) ownerIndex(key []byte) int {
+	hash := hashKeyfromKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	return i % len(r.hashes)
+}
+
+// Get the n-th node after the owner of a key, 0 for the owner itself
+func (r *ring) nodeFor(key []byte, n int) string {
+	if len(r.addrs) == 0 {
+		return ""
+	}
+	return r.addrs[(r.ownerIndex(key)+n)%len(r.addrs)]
+}
+
+func hashKeyfromKey(key []byte) uint32 {
+	return crc32.ChecksumIEEE(key)
+}
+
+func hashKey(addr string) uint32 {
+	return crc32.ChecksumIEEE([]byte(addr))
+}
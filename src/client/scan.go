@@ -0,0 +1,96 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	pb "pa2/pb/protobuf"
+	"sort"
+	"sync"
+)
+
+// Get the pairs of a node, a page at a time. A node answers with as
+// many pairs as fit in a datagram and tells whether there are more.
+//
+// Arguments:
+//		ctx, startAfter, prefix, limit: same as Scan
+//		addr: address of the node
+// Returns:
+//		The pairs found, or an error if the node didn't answer
+func (c *Client) scanNode(ctx context.Context, addr string, startAfter []byte, prefix []byte, limit uint32) ([]*pb.KVEntry, error) {
+	var entries []*pb.KVEntry
+	for {
+		reqPay := &pb.KVRequest{Command: SCAN, Key: startAfter, Value: prefix, Limit: limit}
+		if limit > 0 {
+			reqPay.Limit = limit - uint32(len(entries))
+		}
+		respPay, err := c.DoAt(ctx, addr, reqPay)
+		if err == nil {
+			err = respError(respPay)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, respPay.Entries...)
+		if !respPay.More || len(respPay.Entries) == 0 || (limit > 0 && uint32(len(entries)) >= limit) {
+			return entries, nil
+		}
+		startAfter = respPay.Entries[len(respPay.Entries)-1].Key
+	}
+}
+
+// Get the key-value pairs of the whole cluster in key order. Every node
+// of the cached ring is asked for its own pairs.
+//
+// Arguments:
+//		ctx: context of the scan
+//		startAfter: only keys greater than this one, nil for every key
+//		prefix: only keys starting with it
+//		limit: maximum number of pairs, 0 for every pair
+// Returns:
+//		The pairs found, or an error if a node didn't answer
+func (c *Client) Scan(ctx context.Context, startAfter []byte, prefix []byte, limit uint32) ([]*pb.KVEntry, error) {
+	var wg sync.WaitGroup
+	var entriesMutex sync.Mutex
+	var entries []*pb.KVEntry
+	var scanErr error
+	for _, addr := range c.Nodes() {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			nodeEntries, err := c.scanNode(ctx, addr, startAfter, prefix, limit)
+
+			entriesMutex.Lock()
+			defer entriesMutex.Unlock()
+			if err != nil {
+				scanErr = fmt.Errorf("could not scan %v: %v", addr, err)
+				return
+			}
+			entries = append(entries, nodeEntries...)
+		}(addr)
+	}
+	wg.Wait()
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return sortEntries(entries, limit), nil
+}
+
+// Sort pairs by key, drop the duplicated keys and keep the first limit
+// ones. A key moving between two nodes may be listed by both.
+func sortEntries(entries []*pb.KVEntry, limit uint32) []*pb.KVEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+	unique := entries[:0]
+	for _, entry := range entries {
+		if len(unique) > 0 && bytes.Equal(unique[len(unique)-1].Key, entry.Key) {
+			continue
+		}
+		unique = append(unique, entry)
+	}
+	if limit > 0 && uint32(len(unique)) > limit {
+		unique = unique[:limit]
+	}
+	return unique
+}
@@ -12,15 +12,13 @@ import (
 
 // Print the usage of the program
 func usage() {
-	fmt.Println("Usage: go run src/server/pa2server.go [serverPortNum]")
+	fmt.Println("Usage: go run src/server/pa2server.go [serverPortNum] [serverListFile] [configFile (optional)]")
 }
 
-var localPort = os.Args[1]
-
 func main() {
 	rand.Seed(time.Now().UnixNano())
 	// Check that number of arguments is correct
-	if len(os.Args) != 3 {
+	if len(os.Args) != 3 && len(os.Args) != 4 {
 		fmt.Println("Error: number of arguments is incorrect")
 		usage()
 		return
@@ -34,6 +32,15 @@ func main() {
 		return
 	}
 
+	// Load the optional config file
+	config := pa2lib.DefaultConfig()
+	if len(os.Args) == 4 {
+		if config, err = pa2lib.LoadConfig(os.Args[3]); err != nil {
+			fmt.Println("Error: could not load config file:", err)
+			return
+		}
+	}
+
 	// Start the server
-	pa2lib.StartServer(os.Args[2], port)
+	pa2lib.StartServer(os.Args[2], port, config)
 }
@@ -2,7 +2,6 @@ package pa2lib
 
 import (
 	"encoding/binary"
-	"fmt"
 	"log"
 	"net"
 	"os"
@@ -12,15 +11,18 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
-func unmarshalKVRequest(buf []byte)(pb.KVRequest, []byte, int) {
-	// Unmarshal the request
+func unmarshalKVRequest(buf []byte)(*pb.KVRequest, []byte, int) {
+	// Unmarshal the request, dropping it if the checksum is wrong
 	msgID, payload,_ := unmarshalMsg(buf)
+	if msgID == nil {
+		return nil, nil, -1
+	}
 
 	// Unmarshal the payload
-	reqPay := pb.KVRequest {}
-	err := proto.Unmarshal(payload, &reqPay)
+	reqPay := &pb.KVRequest {}
+	err := proto.Unmarshal(payload, reqPay)
 	if err != nil {
-		return pb.KVRequest{}, nil, -1
+		return nil, nil, -1
 	} else {
 		return reqPay, msgID, 0
 	}
@@ -32,23 +34,50 @@ func unmarshalKVRequest(buf []byte)(pb.KVRequest, []byte, int) {
 //
 // Arguments:
 // 		clientAddr: address to return to the response to
+//		stream: TCP connection the request came on, nil over UDP
 //		msgID: message ID of the request
 //		reqPay: unmarshalled payload
-func handleKVRequest(clientAddr *net.UDPAddr, msgID []byte, reqPay pb.KVRequest) () {
+func (n *Node) handleKVRequest(clientAddr *net.UDPAddr, stream responseStream, msgID []byte, reqPay *pb.KVRequest) () {
 	log.Println("start handling request")
 	log.Println("sender IP:", net.IPv4(msgID[0],msgID[1],msgID[2],msgID[3]).String(), ":", binary.LittleEndian.Uint16(msgID[4:6]))
 	log.Println("command:", reqPay.Command)
+	if reqPay.Command == FORWARD_ACK {
+		n.forwardAcked(msgID)
+		return
+	}
+	// The node that forwarded the request over UDP waits for an ack
+	if stream == nil && isForwardedCommand(reqPay.Command) {
+		defer n.sendForwardAck(clientAddr, msgID)
+	}
 	if reqPay.Addr == nil {
 		reqPay.Addr = []byte(clientAddr.String())
 	}
 
 	// Try to find the response in the cache
-	if respMsgBytes, ok := GetCachedResponse(msgID); ok {
+	if respMsgBytes, ok := n.GetCachedResponse(msgID); ok {
 		// Send the message back to the client
-		_, _ = conn.WriteToUDP(respMsgBytes, clientAddr)
+		n.writeToClient(clientAddr, stream, respMsgBytes)
 	} else {
 		// Handle the command
-		respPay := pb.KVResponse{}
+		respPay := &pb.KVResponse{}
+
+		// A node on the minority side of a partition refuses what its
+		// mode doesn't allow, and tells the client about its mode
+		mode, fenced := n.fencedCommand(reqPay.Command)
+		if mode != MODE_NORMAL {
+			respPay.ClusterMode = mode
+		}
+		if fenced {
+			respPay.ErrCode = NO_QUORUM_ERR
+			n.sendResponse(clientAddrOf(reqPay.Command, clientAddr, reqPay.Addr), stream, msgID, respPay)
+			return
+		}
+
+		// A request forwarded to a node that doesn't own its key goes on
+		// to the owner, within the hop limit
+		if n.rerouteForward(clientAddr, stream, msgID, reqPay) {
+			return
+		}
 
 		/*
 			If the command is PUT, GET or REMOVE, check whether the key exists in
@@ -57,39 +86,60 @@ func handleKVRequest(clientAddr *net.UDPAddr, msgID []byte, reqPay pb.KVRequest)
 		switch reqPay.Command {
 		case PUT:
 			// respPay.ErrCode = Put(reqPay.Key, reqPay.Value, reqPay.Version)
-			if node, existed := checkNode(reqPay.Key); existed {
-				respPay.ErrCode = Put(reqPay.Key, reqPay.Value, &reqPay.Version)
-				//normalReplicate(PUT, reqPay.Key, reqPay.Value, reqPay.Version, node)
+			if node, existed := n.checkNode(reqPay.Key); existed {
+				respPay.ErrCode = n.putOwnedWithTTL(reqPay.Key, reqPay.Value, &reqPay.Version, reqPay.TtlMs, reqPay.Flags)
 			} else {
-				sendRequestToCorrectNode(node, reqPay, msgID)
+				n.forwardRequest(node, reqPay, msgID, clientAddr, stream)
 				return
 			}
 		case GET:
 			// var version int32
 			// respPay.Value, version, respPay.ErrCode = Get(reqPay.Key)
 			// respPay.Version = &version
-			if node, existed := checkNode(reqPay.Key); existed {
+			if node, existed := n.checkNode(reqPay.Key); existed {
+				n.expireIfDue(reqPay.Key)
 				var version int32
-				respPay.Value, version, respPay.ErrCode = Get(reqPay.Key)
+				respPay.Value, version, respPay.ErrCode = n.getOwned(reqPay.Key)
 				respPay.Version = version
+				respPay.Flags = n.getFlags(reqPay.Key)
 			} else {
-				sendRequestToCorrectNode(node, reqPay, msgID)
+				n.forwardRequest(node, reqPay, msgID, clientAddr, stream)
 				return
 			}
 		case REMOVE:
 			// respPay.ErrCode = Remove(reqPay.Key)
-			if node, existed := checkNode(reqPay.Key); existed {
-				respPay.ErrCode = Remove(reqPay.Key)
-				//normalReplicate(REMOVE, reqPay.Key, reqPay.Value, reqPay.Version, node)
+			if node, existed := n.checkNode(reqPay.Key); existed {
+				respPay.ErrCode = n.removeOwned(reqPay.Key)
+			} else {
+				n.forwardRequest(node, reqPay, msgID, clientAddr, stream)
+				return
+			}
+		case EXPIRE:
+			if node, existed := n.checkNode(reqPay.Key); existed {
+				respPay.ErrCode = n.expireOwned(reqPay.Key, reqPay.TtlMs)
+			} else {
+				n.forwardRequest(node, reqPay, msgID, clientAddr, stream)
+				return
+			}
+		case INCR:
+			if node, existed := n.checkNode(reqPay.Key); existed {
+				respPay.Value, respPay.ErrCode = n.incrOwned(reqPay.Key, reqPay.Delta, reqPay.Condition == COND_PRESENT, reqPay.UnsignedCounter)
+			} else {
+				n.forwardRequest(node, reqPay, msgID, clientAddr, stream)
+				return
+			}
+		case PUT_IF:
+			if node, existed := n.checkNode(reqPay.Key); existed {
+				respPay.Version, respPay.ErrCode = n.putIfOwned(reqPay.Key, reqPay.Value, reqPay.Condition, reqPay.Version, reqPay.TtlMs, reqPay.Flags)
 			} else {
-				sendRequestToCorrectNode(node, reqPay, msgID)
+				n.forwardRequest(node, reqPay, msgID, clientAddr, stream)
 				return
 			}
 		case SHUTDOWN:
-			shutdown <- true
+			n.Stop()
 			return
 		case WIPEOUT:
-			respPay.ErrCode = RemoveAll()
+			respPay.ErrCode = n.RemoveAll()
 		case IS_ALIVE:
 			respPay.ErrCode = NO_ERR
 		case GET_PID:
@@ -97,90 +147,176 @@ func handleKVRequest(clientAddr *net.UDPAddr, msgID []byte, reqPay pb.KVRequest)
 			respPay.Pid = pid
 			respPay.ErrCode = NO_ERR
 		case GET_MEMBERSHIP_CNT:
-			members := int32(1) // Unused, return 1 for now
-			respPay.MembershipCount = members
+			alive, suspect, dead, _ := n.countMembers()
+			respPay.MembershipCount = int32(alive)
+			respPay.SuspectCount = int32(suspect)
+			respPay.DeadCount = int32(dead)
 			respPay.ErrCode = NO_ERR
 		case GET_MEMBERSHIP_LIST:
 			var version int32
-			respPay.NodeList, version, respPay.ErrCode = GetMemberShipList()
+			respPay.NodeList, version, respPay.ErrCode = n.GetMemberShipList()
 			respPay.Version = version
 
 		//forward request
 		case PUT_FORWARD:
-			respPay.ErrCode = Put(reqPay.Key, reqPay.Value, &reqPay.Version)
-			clientAddr, _ = net.ResolveUDPAddr("udp", string(reqPay.Addr))
+			respPay.ErrCode = n.putOwnedWithTTL(reqPay.Key, reqPay.Value, &reqPay.Version, reqPay.TtlMs, reqPay.Flags)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
 
 		case GET_FORWARD:
+			n.expireIfDue(reqPay.Key)
 			var version int32
-			respPay.Value, version, respPay.ErrCode = Get(reqPay.Key)
+			respPay.Value, version, respPay.ErrCode = n.getOwned(reqPay.Key)
 			respPay.Version = version
-			clientAddr, _ = net.ResolveUDPAddr("udp", string(reqPay.Addr))
+			respPay.Flags = n.getFlags(reqPay.Key)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
 
 		case REMOVE_FORWARD:
 			// respPay.ErrCode = Remove(reqPay.Key)
-			respPay.ErrCode = Remove(reqPay.Key)
-			clientAddr, _ = net.ResolveUDPAddr("udp", string(reqPay.Addr))
+			respPay.ErrCode = n.removeOwned(reqPay.Key)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
+
+		case EXPIRE_FORWARD:
+			respPay.ErrCode = n.expireOwned(reqPay.Key, reqPay.TtlMs)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
+
+		case INCR_FORWARD:
+			respPay.Value, respPay.ErrCode = n.incrOwned(reqPay.Key, reqPay.Delta, reqPay.Condition == COND_PRESENT, reqPay.UnsignedCounter)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
+
+		case PUT_IF_FORWARD:
+			respPay.Version, respPay.ErrCode = n.putIfOwned(reqPay.Key, reqPay.Value, reqPay.Condition, reqPay.Version, reqPay.TtlMs, reqPay.Flags)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
+
+		case GET_FALLBACK:
+			respPay.Value, respPay.Version, respPay.ErrCode = n.getFallback(reqPay.Key)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
+
+		case PUT_FALLBACK:
+			respPay.ErrCode = n.putFallback(reqPay.Key, reqPay.Value, reqPay.Version, reqPay.TtlMs, reqPay.Flags)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
+
+		case REMOVE_FALLBACK:
+			respPay.ErrCode = n.removeFallback(reqPay.Key)
+			clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
+
+		case HINT_PUT, HINT_REMOVE:
+			respPay.ErrCode = n.applyHint(reqPay)
 
 		case PUT_REPLICATE_SON:
-			PutReplicate(reqPay.Key, reqPay.Value, &reqPay.Version, 0)
+			n.PutReplicate(reqPay.Key, reqPay.Value, &reqPay.Version, 0)
 			return
 		case PUT_REPLICATE_GRANDSON:
-			PutReplicate(reqPay.Key, reqPay.Value, &reqPay.Version, 1)
+			n.PutReplicate(reqPay.Key, reqPay.Value, &reqPay.Version, 1)
 			return
 		case REMOVE_REPLICATE_SON:
-			RemoveReplicate(reqPay.Key, 0)
+			n.RemoveReplicate(reqPay.Key, 0)
 			return
 		case REMOVE_REPLICATE_GRANDSON:
-			RemoveReplicate(reqPay.Key, 1)
+			n.RemoveReplicate(reqPay.Key, 1)
 			return
 		case HELLO:
 			addr, _ := net.ResolveUDPAddr("udp", string(reqPay.Addr))
-			receiveHello(addr, msgID)
+			n.receiveHello(addr, msgID)
 			return
+		case JOIN:
+			respPay.NodeList, respPay.ErrCode = n.handleJoin(reqPay)
+		case GRANDSON_DIED, SON_DIED:
+			// Notifications sent from the server socket, answering them
+			// would make the sender read our response as a request
+			return
+		case GOSSIP_DIGEST:
+			respPay.NodeList = n.handleGossipDigest(reqPay.Digest)
+		case GOSSIP_SYNC:
+			respPay.NodeList = n.handleGossipSync(reqPay)
+		case PING:
+			respPay.NodeList, respPay.ErrCode = n.handlePing(reqPay)
+		case PING_REQ:
+			var acked bool
+			if respPay.NodeList, acked = n.handlePingReq(reqPay); !acked {
+				// No response, the sender gives up on its own
+				return
+			}
+		case LEAVE:
+			respPay.ErrCode = n.handleLeave(string(reqPay.Addr), reqPay.Incarnation)
+		case HANDOFF_REPLICATE_SON:
+			respPay.ErrCode = n.PutReplicate(reqPay.Key, reqPay.Value, &reqPay.Version, 0)
+		case HANDOFF_REPLICATE_GRANDSON:
+			respPay.ErrCode = n.PutReplicate(reqPay.Key, reqPay.Value, &reqPay.Version, 1)
+		case MIGRATE_PUT:
+			respPay.ErrCode = n.PutIfAbsent(reqPay.Key, reqPay.Value, &reqPay.Version)
+		case MIGRATE_BEGIN:
+			respPay.ErrCode = n.beginIncomingMigration(n.migrationRangeFromRequest(reqPay))
+		case MIGRATE_DONE:
+			respPay.ErrCode = n.endIncomingMigration(n.migrationRangeFromRequest(reqPay))
+		case MIGRATE_GET:
+			var version int32
+			respPay.Value, version, respPay.ErrCode = n.Get(reqPay.Key)
+			respPay.Version = version
+		case MIGRATE_DUAL_PUT:
+			respPay.ErrCode = n.Put(reqPay.Key, reqPay.Value, &reqPay.Version)
+		case MIGRATE_DUAL_REMOVE:
+			respPay.ErrCode = n.Remove(reqPay.Key)
+
+		//rebalance admin commands
+		case REBALANCE_STATUS:
+			respPay.Rebalance = n.GetRebalanceStatus()
+			respPay.ErrCode = NO_ERR
+		case REBALANCE_PAUSE:
+			respPay.ErrCode = n.SetRebalancePaused(true)
+		case REBALANCE_RESUME:
+			respPay.ErrCode = n.SetRebalancePaused(false)
+		case REBALANCE_CANCEL:
+			respPay.ErrCode = n.CancelRebalance()
+		case CLUSTER_HEALTH:
+			respPay.Health = n.handleClusterHealth(string(reqPay.Value))
+			respPay.ErrCode = NO_ERR
+		case MEMBERSHIP_QUERY:
+			respPay.NodeList, respPay.ErrCode = n.handleMembershipQuery(string(reqPay.Value))
+		case SCAN:
+			respPay.Entries, respPay.More = n.scanLocal(reqPay.Key, reqPay.Value, reqPay.Limit, maxScanPageBytes)
+			respPay.ErrCode = NO_ERR
+		case WATCH_MEMBERSHIP:
+			respPay.NodeList, respPay.ErrCode = n.handleWatchMembership(clientAddr, stream, msgID)
+		case DECOMMISSION:
+			respPay.ErrCode = n.StartDecommission()
+		case GET_RING:
+			respPay.NodeList = n.getRingMembership()
+			respPay.RingEpoch = n.ringEpoch()
+			respPay.ErrCode = NO_ERR
 		default:
 			respPay.ErrCode = UNKNOWN_CMD_ERR
 		}
 
+		// A client caching the ring learns when it changed
+		if reqPay.RingEpoch != 0 {
+			respPay.RingEpoch = n.ringEpoch()
+		}
+
 		// Send the response
-		sendResponse(clientAddr, msgID, respPay)
+		n.sendResponse(clientAddr, stream, msgID, respPay)
 	}
 
 
 }
 
-func KVReqHandler(port int) {
-	// Start the UDP server
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		log.Println("Error resolving server address:", err)
-		return
-	}
-
-	conn, err = net.ListenUDP("udp", addr)
-	if err != nil {
-		log.Println("Error setting up the UDP server:", err)
-		return
-	}
-
-	defer conn.Close()
-
+// Reads the requests of the UDP connection of the node until it stops
+func (n *Node) KVReqHandler() {
 	rcvBuffer := make([]byte, 11000)
 	for {
 		select {
-		case <- shutdown:
-			fmt.Println("Server shutdown")
+		case <- n.stopped:
 			return
 		default:
 			// Set up the receive timeout to 100ms
 			deadline := time.Now().Add(100*1000*1000)
-			_ = conn.SetReadDeadline(deadline)
+			_ = n.conn.SetReadDeadline(deadline)
 
-			numBytes, clientAddr, err := conn.ReadFromUDP(rcvBuffer)
+			numBytes, clientAddr, err := n.conn.ReadFromUDP(rcvBuffer)
 			if err == nil {
 				// Unmarshal and handle the request in a different thread
 				reqPay, id, err := unmarshalKVRequest(rcvBuffer[:numBytes])
 				if err == 0 {
-					go handleKVRequest(clientAddr, id, reqPay)
+					go n.handleKVRequest(clientAddr, nil, id, reqPay)
 				}
 			}
 		}
@@ -1,7 +1,6 @@
 package pa2lib
 
 import (
-	"sync"
 	"time"
 	"bytes"
 	"runtime"
@@ -14,12 +13,6 @@ type CacheVal struct {
 	ttl int8
 }
 
-// In-memory response cache
-var Cache = []CacheVal{}
-
-// Mutex for locking the Cache map
-var cacheMutex = &sync.Mutex{}
-
 // Caches a response for a particular message ID
 //
 // Arguments:
@@ -27,12 +20,12 @@ var cacheMutex = &sync.Mutex{}
 //		respMsgBytes: the raw response message
 // Returns:
 //		True if cached successfully, false if not enough room
-func CacheResponse(msgID []byte, respMsgBytes []byte) (bool) {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
+func (n *Node) CacheResponse(msgID []byte, respMsgBytes []byte) (bool) {
+	n.cacheMutex.Lock()
+	defer n.cacheMutex.Unlock()
 	if IsAllocatePossible(len(msgID) + len(respMsgBytes) + 1) {
 		cacheVal := CacheVal {id: msgID, response: respMsgBytes, ttl: 4}
-		Cache = append(Cache, cacheVal)
+		n.Cache = append(n.Cache, cacheVal)
 
 		return true
 	} else {
@@ -48,41 +41,43 @@ func CacheResponse(msgID []byte, respMsgBytes []byte) (bool) {
 // Returns:
 //		The raw response if it was found, nil otherwise
 //		True if the response was found, false otherwise
-func GetCachedResponse(msgID []byte) ([]byte, bool) {
-	cacheMutex.Lock()
-	for _, v := range Cache {
+func (n *Node) GetCachedResponse(msgID []byte) ([]byte, bool) {
+	n.cacheMutex.Lock()
+	for _, v := range n.Cache {
 		if bytes.Equal(v.id, msgID) {
-			cacheMutex.Unlock()
+			n.cacheMutex.Unlock()
 			return v.response, true
 		}
 	}
 
-	cacheMutex.Unlock()
+	n.cacheMutex.Unlock()
 	return nil, false
 }
 
 // Loops every second to remove all items in the cache whose
 // TTL has run out. Should be called as a goroutine so it can
 // run in the background
-func CacheTTLManager() () {
+func (n *Node) CacheTTLManager() () {
 	for {
-		cacheMutex.Lock()
+		n.cacheMutex.Lock()
 		i := 0
-		for _, v := range Cache {
+		for _, v := range n.Cache {
 			// If TTL is zero remove from cache
 			if v.ttl > 0 {
 				v.ttl -= 1
-				Cache[i] = v
+				n.Cache[i] = v
 				i += 1
 			}
 		}
-		Cache = Cache[:i]
-		cacheMutex.Unlock()
+		n.Cache = n.Cache[:i]
+		n.cacheMutex.Unlock()
 
 		// Run the GC explicitly since many items may have been removed 
 		runtime.GC()
 
 		// Sleep for 1 second
-		time.Sleep(time.Second)
+		if !n.sleep(time.Second) {
+			return
+		}
 	}
 }
@@ -0,0 +1,149 @@
+package pa2lib
+
+import (
+	"math"
+	"strconv"
+)
+
+// Conditions of a PUT_IF, in the condition field of the request
+const (
+	// Always store the value
+	COND_NONE = 0
+	// Store the value only if the key does not exist
+	COND_ABSENT = 1
+	// Store the value only if the key exists
+	COND_PRESENT = 2
+	// Store the value only if the key exists with the version of the request
+	COND_VERSION = 3
+)
+
+// Handle a PUT_IF of a key this node owns: store the value if the
+// condition holds, with the version of the key plus one, so a reader can
+// tell the value changed since it read it.
+//
+// Arguments:
+//		key, value: same as Put
+//		condition: one of the COND_ constants
+//		version: version the key must have for COND_VERSION
+//		ttlMs: time to live in milliseconds, 0 for none
+//		flags: flags of a memcached client, 0 for none
+// Returns:
+//		The new version of the key, and an error code: KEY_DNE_ERR if
+//		the key must exist but doesn't, CONDITION_FAILED_ERR if it
+//		exists when it must not or with another version
+func (n *Node) putIfOwned(key []byte, value []byte, condition uint32, version int32, ttlMs uint64, flags uint32) (int32, uint32) {
+	n.condMutex.Lock()
+	defer n.condMutex.Unlock()
+
+	n.expireIfDue(key)
+	_, current, errCode := n.getOwned(key)
+	if errCode != NO_ERR && errCode != KEY_DNE_ERR {
+		return 0, errCode
+	}
+	exists := errCode == NO_ERR
+
+	switch condition {
+	case COND_NONE:
+	case COND_ABSENT:
+		if exists {
+			return current, CONDITION_FAILED_ERR
+		}
+	case COND_PRESENT:
+		if !exists {
+			return 0, KEY_DNE_ERR
+		}
+	case COND_VERSION:
+		if !exists {
+			return 0, KEY_DNE_ERR
+		}
+		if current != version {
+			return current, CONDITION_FAILED_ERR
+		}
+	default:
+		return 0, UNKNOWN_CMD_ERR
+	}
+
+	next := current + 1
+	if errCode := n.putOwnedWithTTL(key, value, &next, ttlMs, flags); errCode != NO_ERR {
+		return 0, errCode
+	}
+	return next, NO_ERR
+}
+
+// Add a delta to a counter read as a signed 64-bit integer
+//
+// Returns:
+//		The new value, false on overflow
+func addSigned(n int64, delta int64) (int64, bool) {
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, false
+	}
+	return n + delta, true
+}
+
+// Add a delta to a counter read as an unsigned 64-bit integer, like
+// memcached: it wraps around on overflow and stops at 0
+func addUnsigned(n uint64, delta int64) uint64 {
+	if delta >= 0 {
+		return n + uint64(delta)
+	}
+	d := uint64(-(delta + 1)) + 1
+	if d > n {
+		return 0
+	}
+	return n - d
+}
+
+// Handle an INCR of a key this node owns: add a delta to its value, read
+// as a decimal integer. The time to live of the key is kept and its
+// version goes up by one.
+//
+// Arguments:
+//		key: key to increment
+//		delta: amount to add, negative to decrement
+//		mustExist: if false, a missing key counts as 0
+//		unsigned: read the value as an unsigned counter, see addUnsigned
+// Returns:
+//		The new value, and an error code, INVALID_VAL_ERR if the value
+//		isn't an integer or overflows
+func (n *Node) incrOwned(key []byte, delta int64, mustExist bool, unsigned bool) ([]byte, uint32) {
+	n.condMutex.Lock()
+	defer n.condMutex.Unlock()
+
+	n.expireIfDue(key)
+	value, version, errCode := n.getOwned(key)
+	switch errCode {
+	case NO_ERR:
+	case KEY_DNE_ERR:
+		if mustExist {
+			return nil, errCode
+		}
+		value = []byte("0")
+	default:
+		return nil, errCode
+	}
+
+	if unsigned {
+		count, err := strconv.ParseUint(string(value), 10, 64)
+		if err != nil {
+			return nil, INVALID_VAL_ERR
+		}
+		value = []byte(strconv.FormatUint(addUnsigned(count, delta), 10))
+	} else {
+		count, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return nil, INVALID_VAL_ERR
+		}
+		if count, ok := addSigned(count, delta); ok {
+			value = []byte(strconv.FormatInt(count, 10))
+		} else {
+			return nil, INVALID_VAL_ERR
+		}
+	}
+
+	version++
+	if errCode := n.putOwned(key, value, &version); errCode != NO_ERR {
+		return nil, errCode
+	}
+	return value, NO_ERR
+}
@@ -0,0 +1,234 @@
+package pa2lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds the tunable settings of a node. Every field has a
+// default so a node can run without a config file.
+type Config struct {
+	// Maximum number of bytes per second moved by the rebalancer,
+	// 0 means unlimited
+	RebalanceBytesPerSec uint64
+	// Maximum number of keys per second moved by the rebalancer,
+	// 0 means unlimited
+	RebalanceOpsPerSec uint32
+	// Addresses ("ip:port") contacted to join the cluster. When empty,
+	// the nodes of the server list file are used as seeds
+	Seeds []string
+	// IP address other nodes reach this node on. When empty, the IP of
+	// the interface used for outgoing traffic is used
+	AdvertiseIP string
+	// Time between two gossip rounds
+	GossipIntervalMs uint32
+	// Time between two probes of the failure detector
+	ProbeIntervalMs uint32
+	// Time to wait for the ack of a direct ping
+	ProbeTimeoutMs uint32
+	// Number of members asked to ping a node that missed a direct ping
+	IndirectProbes uint32
+	// Time a node stays suspected before being declared dead
+	SuspectTimeoutMs uint32
+	// Failure detector deciding which nodes to suspect, "timeout" to
+	// suspect a node as soon as it misses a probe, or "phi" to adapt to
+	// the observed delay between its messages
+	FailureDetector string
+	// Phi above which the phi detector suspects a node
+	PhiThreshold float64
+	// Metadata of the node spread to the others through gossip, set
+	// with "tag.<name> = value" lines
+	Tags map[string]string
+	// Number of nodes the cluster is meant to have. A node that sees
+	// half of them or fewer alive is on the minority side of a partition.
+	// 0 disables the check
+	ClusterSize uint32
+	// What a node on the minority side still serves: "read-only",
+	// "reject-all" or "allow"
+	MinorityMode string
+	// Transport of the requests sent to other nodes, "tcp" or "udp".
+	// Over TCP, a node that doesn't listen on TCP is sent UDP instead
+	NodeTransport string
+	// How a request for a key owned by another node is answered:
+	// "direct" (the owner answers the client), "redirect" (the client is
+	// told the owner) or "proxy" (the contacted node relays the answer)
+	ForwardMode string
+	// Whether writes go to the replicas of a node that doesn't answer,
+	// like reads do. Off by default: such a write is replayed to the
+	// owner when it answers again, but it is lost if the replica holding
+	// it fails first.
+	SloppyQuorum bool
+	// Number of times a request can be forwarded between nodes before
+	// the client gets TOO_MANY_HOPS_ERR
+	MaxHops uint32
+	// Whether the nodes a forwarded request went through are logged at
+	// every hop
+	TraceForwards bool
+	// Port of the KVService gRPC API, 0 to not serve it
+	GRPCPort uint32
+	// Port of the HTTP/JSON gateway, 0 to not serve it
+	HTTPPort uint32
+	// Port of the Redis protocol listener, 0 to not serve it
+	RedisPort uint32
+	// Port of the memcached protocol listener, 0 to not serve it
+	MemcachePort uint32
+}
+
+// Returns the settings used when no config file is given
+func DefaultConfig() Config {
+	return Config{
+		RebalanceBytesPerSec: 1024 * 1024,
+		RebalanceOpsPerSec:   1000,
+		GossipIntervalMs:     1000,
+		ProbeIntervalMs:      1000,
+		ProbeTimeoutMs:       200,
+		IndirectProbes:       3,
+		SuspectTimeoutMs:     5000,
+		FailureDetector:      DETECTOR_TIMEOUT,
+		PhiThreshold:         8,
+		MinorityMode:         MINORITY_READ_ONLY,
+		NodeTransport:        TRANSPORT_TCP,
+		ForwardMode:          FORWARD_DIRECT,
+		MaxHops:              3,
+	}
+}
+
+// Reads a config file made of "key = value" lines and applies it on
+// top of the defaults. Empty lines and lines starting with '#' are
+// ignored.
+//
+// Arguments:
+//		configFile: path of the config file
+// Returns:
+//		The config, or an error if the file can't be read or contains an
+//		invalid line
+func LoadConfig(configFile string) (Config, error) {
+	config := DefaultConfig()
+	file, err := os.Open(configFile)
+	if err != nil {
+		return config, err
+	}
+	defer file.Close()
+
+	buf := bufio.NewReader(file)
+	for lineNum := 1; ; lineNum++ {
+		line, err := buf.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return config, err
+		}
+
+		if err := applyConfigLine(&config, line); err != nil {
+			return config, fmt.Errorf("%s:%d: %v", configFile, lineNum, err)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	log.Printf("Loaded config %s: %+v\n", configFile, config)
+	return config, nil
+}
+
+// Parses a single "key = value" line into the config
+func applyConfigLine(c *Config, line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	s := strings.SplitN(line, "=", 2)
+	if len(s) != 2 {
+		return fmt.Errorf("expected key = value, got %q", line)
+	}
+	key := strings.TrimSpace(s[0])
+	value := strings.TrimSpace(s[1])
+
+	var err error
+	switch key {
+	case "rebalance_bytes_per_sec":
+		c.RebalanceBytesPerSec, err = strconv.ParseUint(value, 10, 64)
+	case "rebalance_ops_per_sec":
+		c.RebalanceOpsPerSec, err = parseUint32(value)
+	case "seeds":
+		c.Seeds = nil
+		for _, seed := range strings.Split(value, ",") {
+			if seed = strings.TrimSpace(seed); seed != "" {
+				c.Seeds = append(c.Seeds, seed)
+			}
+		}
+	case "advertise_ip":
+		c.AdvertiseIP = value
+	case "gossip_interval_ms":
+		c.GossipIntervalMs, err = parseUint32(value)
+	case "probe_interval_ms":
+		c.ProbeIntervalMs, err = parseUint32(value)
+	case "probe_timeout_ms":
+		c.ProbeTimeoutMs, err = parseUint32(value)
+	case "indirect_probes":
+		c.IndirectProbes, err = parseUint32(value)
+	case "suspect_timeout_ms":
+		c.SuspectTimeoutMs, err = parseUint32(value)
+	case "failure_detector":
+		if value != DETECTOR_TIMEOUT && value != DETECTOR_PHI {
+			return fmt.Errorf("invalid value for %s: %q", key, value)
+		}
+		c.FailureDetector = value
+	case "cluster_size":
+		c.ClusterSize, err = parseUint32(value)
+	case "minority_mode":
+		if value != MINORITY_READ_ONLY && value != MINORITY_REJECT_ALL && value != MINORITY_ALLOW {
+			return fmt.Errorf("invalid value for %s: %q", key, value)
+		}
+		c.MinorityMode = value
+	case "node_transport":
+		if value != TRANSPORT_TCP && value != TRANSPORT_UDP {
+			return fmt.Errorf("invalid value for %s: %q", key, value)
+		}
+		c.NodeTransport = value
+	case "forward_mode":
+		if !validForwardMode(value) {
+			return fmt.Errorf("invalid value for %s: %q", key, value)
+		}
+		c.ForwardMode = value
+	case "sloppy_quorum":
+		c.SloppyQuorum, err = strconv.ParseBool(value)
+	case "max_hops":
+		c.MaxHops, err = parseUint32(value)
+	case "trace_forwards":
+		c.TraceForwards, err = strconv.ParseBool(value)
+	case "grpc_port":
+		c.GRPCPort, err = parseUint32(value)
+	case "http_port":
+		c.HTTPPort, err = parseUint32(value)
+	case "memcache_port":
+		c.MemcachePort, err = parseUint32(value)
+	case "redis_port":
+		c.RedisPort, err = parseUint32(value)
+	case "phi_threshold":
+		c.PhiThreshold, err = strconv.ParseFloat(value, 64)
+	default:
+		name := strings.TrimPrefix(key, "tag.")
+		if name == key || name == "" {
+			return fmt.Errorf("unknown key %q", key)
+		}
+		if c.Tags == nil {
+			c.Tags = make(map[string]string)
+		}
+		c.Tags[name] = value
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+	return nil
+}
+
+func parseUint32(value string) (uint32, error) {
+	n, err := strconv.ParseUint(value, 10, 32)
+	return uint32(n), err
+}
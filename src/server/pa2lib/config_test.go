@@ -0,0 +1,53 @@
+package pa2lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyConfigLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    func(c *Config)
+		wantErr bool
+	}{
+		{"empty line", "  \n", func(c *Config) {}, false},
+		{"comment", "# gossip_interval_ms = 1", func(c *Config) {}, false},
+		{"number", "gossip_interval_ms = 250\n", func(c *Config) { c.GossipIntervalMs = 250 }, false},
+		{"no spaces", "max_hops=5", func(c *Config) { c.MaxHops = 5 }, false},
+		{"64-bit number", "rebalance_bytes_per_sec = 8589934592", func(c *Config) { c.RebalanceBytesPerSec = 1 << 33 }, false},
+		{"float", "phi_threshold = 12.5", func(c *Config) { c.PhiThreshold = 12.5 }, false},
+		{"bool", "sloppy_quorum = true", func(c *Config) { c.SloppyQuorum = true }, false},
+		{"string", "advertise_ip = 10.0.0.1", func(c *Config) { c.AdvertiseIP = "10.0.0.1" }, false},
+		{"seeds", "seeds = 10.0.0.1:1, ,10.0.0.2:2", func(c *Config) { c.Seeds = []string{"10.0.0.1:1", "10.0.0.2:2"} }, false},
+		{"value with an equal sign", "tag.expr = a=b", func(c *Config) { c.Tags = map[string]string{"expr": "a=b"} }, false},
+		{"tag", "tag.zone = us-east", func(c *Config) { c.Tags = map[string]string{"zone": "us-east"} }, false},
+		{"enum", "failure_detector = phi", func(c *Config) { c.FailureDetector = DETECTOR_PHI }, false},
+		{"invalid enum", "minority_mode = maybe", nil, true},
+		{"invalid number", "max_hops = many", nil, true},
+		{"number too large", "max_hops = 4294967296", nil, true},
+		{"negative number", "probe_timeout_ms = -1", nil, true},
+		{"invalid bool", "trace_forwards = sometimes", nil, true},
+		{"unknown key", "gossip = 1", nil, true},
+		{"tag without a name", "tag. = x", nil, true},
+		{"no equal sign", "max_hops 5", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultConfig()
+			err := applyConfigLine(&got, tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := DefaultConfig()
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -1,12 +1,9 @@
 package pa2lib
 
 import (
-	"encoding/binary"
 	"fmt"
 	"hash/crc32"
-	"net"
 	"sort"
-	"strconv"
 	"sync"
 )
 
@@ -25,7 +22,12 @@ func newConsistent() *Consistent {
 
 // map the initial node list to hash ring
 func (c *Consistent) generateHashRing(nodeList map[string]*NodeVal) {
+	c.Lock()
+	defer c.Unlock()
 	for _, node := range nodeList {
+			if !node.isOn {
+				continue
+			}
 			ipAdr := node.ipAdr
 			port := node.port
 			hashKey := hashKey(ipAdr, port)
@@ -87,15 +89,11 @@ func (c *Consistent) getLastNode(node NodeVal) NodeVal{
 }
 
 // add new node
-func (c *Consistent) addNodetoHashring(msgId []byte){
+func (c *Consistent) addNodetoHashring(node NodeVal){
 	c.Lock()
 	defer c.Unlock()
-	ip := net.IPv4(msgId[0],msgId[1],msgId[2],msgId[3]).String()
-	port := binary.LittleEndian.Uint16(msgId[4:6])
-	addr := ip + ":" + strconv.Itoa(int(port))
-	node := nodeList[addr]
 	hashKey := hashKey(node.ipAdr, node.port)
-	c.circle[hashKey] = *node
+	c.circle[hashKey] = node
 }
 
 // delete an existing node
@@ -106,6 +104,23 @@ func (c *Consistent) removeNodefromHashring(ip string, port string){
 	delete(c.circle, hashKey)
 }
 
+// get the nodes around a position of the ring, whether or not a node
+// sits there. Used for a node that was already removed from the ring
+func (c *Consistent) getNeighbors(hash uint32) (NodeVal, NodeVal){
+	c.Lock()
+	defer c.Unlock()
+	keys, nodes := getSortedNodeList(c.circle)
+	if len(nodes) == 0 {
+		return NodeVal{}, NodeVal{}
+	}
+	i := sort.Search(len(keys), func(i int) bool {return keys[i] > hash})
+	last := nodes[(i-1+len(nodes)) % len(nodes)]
+	if keys[(i-1+len(nodes)) % len(nodes)] == hash {
+		last = nodes[(i-2+2*len(nodes)) % len(nodes)]
+	}
+	return last, nodes[i % len(nodes)]
+}
+
 // sort the node list according to keys
 func getSortedNodeList(circle map[uint32]NodeVal) ([]uint32, []NodeVal) {
 	//fmt.Println(len(circle))
@@ -115,7 +130,7 @@ func getSortedNodeList(circle map[uint32]NodeVal) ([]uint32, []NodeVal) {
 	}
 	sort.Slice(keys, func(i, j int) bool {return keys[i] < keys[j]})
 	
-	nodes := make([]NodeVal, len(circle))
+	nodes := make([]NodeVal, 0, len(circle))
 	for _, k := range keys {
 		nodes = append(nodes, circle[k])
 	}
@@ -130,15 +145,15 @@ func hashKey(ipAdr string, port string) uint32 { // we could change to Hash32 la
 	return crc32.ChecksumIEEE([]byte(ipAdr + ":" + port))
 }
 
-func checkNode(key []byte) (NodeVal, bool) {
+func (n *Node) checkNode(key []byte) (NodeVal, bool) {
 	//log.Println("circle length", len(consistent.circle))
-	node := consistent.getNode(key)
+	node := n.consistent.getNode(key)
 	//log.Println(node)
 	nodeIP := node.ipAdr
 	//log.Println(localIP, "?==", nodeIP)
 	nodePort := node.port
 	//log.Println(localPort, "?==", nodePort)
-	if localIP == nodeIP && localPort == nodePort {
+	if n.localIP == nodeIP && n.localPort == nodePort {
 		//log.Println("current node is correct")
 		return node, true
 	} else {
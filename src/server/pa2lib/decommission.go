@@ -0,0 +1,295 @@
+package pa2lib
+
+import (
+	"fmt"
+	"log"
+	pb "pa2/pb/protobuf"
+	"time"
+)
+
+// State reported by REBALANCE_STATUS while the node is leaving
+const REBALANCE_DECOMMISSIONING = "decommissioning"
+
+// Number of times the LEAVE is sent to the son before leaving without
+// its acknowledgement
+const leaveAttempts = 10
+
+// A set of keys to copy to a node, the store they are read from and the
+// command storing them there. The node is the one the given number of
+// places after this node in the ring.
+type handoffStep struct {
+	keys     [][]byte
+	read     func(key []byte) ([]byte, int32, uint32)
+	distance int
+	target   NodeVal
+	cmd      uint32
+}
+
+// How a handoff ended
+type handoffResult int
+
+const (
+	handoffDone handoffResult = iota
+	handoffCanceled
+	// A target left the ring or a node joined before it, every step
+	// starts over with the new targets
+	handoffRetarget
+)
+
+// Get the node the given number of places after this node in the ring
+func (n *Node) successor(distance int) NodeVal {
+	node := n.localNode()
+	for i := 0; i < distance; i++ {
+		node = n.consistent.getNextNode(node)
+	}
+	return node
+}
+
+// Get the keys of a store
+func storeKeys(store []StoreVal) [][]byte {
+	keys := make([][]byte, 0, len(store))
+	for _, KVPair := range store {
+		keys = append(keys, KVPair.key)
+	}
+	return keys
+}
+
+// Start handing this node's data over to the nodes that take it over,
+// then leave the cluster. Runs in the background, its progress is
+// reported by REBALANCE_STATUS.
+//
+// Returns:
+//		NO_ERR
+func (n *Node) StartDecommission() uint32 {
+	n.rebalancer.Lock()
+	defer n.rebalancer.Unlock()
+	if n.rebalancer.decommissioning {
+		return NO_ERR
+	}
+	n.rebalancer.decommissioning = true
+	log.Println("Decommission started")
+	go n.decommission()
+	return NO_ERR
+}
+
+// Get the node that receives a copy of every write while this node
+// is being decommissioned
+//
+// Returns:
+//		The son of this node, and false if the node isn't leaving
+func (n *Node) handoffTarget() (NodeVal, bool) {
+	n.rebalancer.Lock()
+	decommissioning := n.rebalancer.decommissioning
+	n.rebalancer.Unlock()
+	if !decommissioning {
+		return NodeVal{}, false
+	}
+	son := n.consistent.getNextNode(n.localNode())
+	return son, !sameNode(son, n.localNode())
+}
+
+// Waits while the rebalancer is paused
+//
+// Returns:
+//		False if the decommission was canceled, true otherwise
+func (r *Rebalancer) decommissionCheckpoint() bool {
+	for {
+		r.Lock()
+		paused, decommissioning := r.paused, r.decommissioning
+		r.Unlock()
+		if !decommissioning {
+			return false
+		}
+		if !paused {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Copy a key to the target of a step with its current value. Writes
+// copied to the son wait until it is acknowledged, so they always reach
+// the son after it.
+//
+// Returns:
+//		The number of bytes sent, and an error if the node didn't
+//		acknowledge the key
+func (r *Rebalancer) handoffKey(step handoffStep, key []byte) (int, error) {
+	r.handoffMutex.Lock()
+	defer r.handoffMutex.Unlock()
+
+	value, version, errCode := step.read(key)
+	if errCode != NO_ERR {
+		// Removed since the step started
+		return 0, nil
+	}
+	reqPay := &pb.KVRequest{
+		Command: step.cmd,
+		Key:     key,
+		Value:   value,
+		Version: version,
+	}
+	respPay, err := r.node.sendRequestAndWait(step.target, reqPay, migrateTimeout, migrateAttempts)
+	if err == nil && respPay.ErrCode != NO_ERR {
+		err = fmt.Errorf("error code %v", respPay.ErrCode)
+	}
+	return len(key) + len(value) + 4, err
+}
+
+// Copy a set of keys to a node, retrying every key until it is
+// acknowledged or the node is no longer the target. A target found dead
+// leaves the ring, so the decommission doesn't wait for it forever.
+//
+// Returns:
+//		How the handoff ended
+func (r *Rebalancer) handoff(step handoffStep, ops *rateLimiter, bandwidth *rateLimiter) handoffResult {
+	for _, key := range step.keys {
+		ops.wait(1)
+
+		var size int
+		for {
+			if !r.decommissionCheckpoint() {
+				return handoffCanceled
+			}
+			if !sameNode(r.node.successor(step.distance), step.target) {
+				return handoffRetarget
+			}
+			var err error
+			if size, err = r.handoffKey(step, key); err == nil {
+				break
+			}
+			log.Println("Failed to hand off key to", step.target.ipAdr+":"+step.target.port, err)
+			if !r.node.sleep(time.Second) {
+				return handoffCanceled
+			}
+		}
+
+		bandwidth.wait(float64(size))
+		r.Lock()
+		r.keysMoved++
+		r.bytesMoved += uint64(size)
+		r.Unlock()
+	}
+	return handoffDone
+}
+
+// Get the steps of a handoff, with the keys this node holds and the
+// targets in the ring now
+func (n *Node) handoffSteps() []handoffStep {
+	n.mutex.Lock()
+	owned := storeKeys(n.KVStore)
+	fatherKeys := storeKeys(n.repKVStore[0])
+	grandfatherKeys := storeKeys(n.repKVStore[1])
+	n.mutex.Unlock()
+
+	readFather := func(key []byte) ([]byte, int32, uint32) { return n.GetReplicate(key, 0) }
+	readGrandfather := func(key []byte) ([]byte, int32, uint32) { return n.GetReplicate(key, 1) }
+	steps := []handoffStep{
+		{keys: owned, read: n.Get, distance: 1, cmd: MIGRATE_PUT},
+		{keys: owned, read: n.Get, distance: 2, cmd: HANDOFF_REPLICATE_SON},
+		{keys: owned, read: n.Get, distance: 3, cmd: HANDOFF_REPLICATE_GRANDSON},
+		{keys: fatherKeys, read: readFather, distance: 1, cmd: HANDOFF_REPLICATE_SON},
+		{keys: fatherKeys, read: readFather, distance: 2, cmd: HANDOFF_REPLICATE_GRANDSON},
+		{keys: grandfatherKeys, read: readGrandfather, distance: 1, cmd: HANDOFF_REPLICATE_GRANDSON},
+	}
+	for i := range steps {
+		steps[i].target = n.successor(steps[i].distance)
+	}
+	return steps
+}
+
+// Hand the owned keys and both replica stores over to the nodes that
+// will hold them once this node is gone. After this node leaves:
+//  - the son owns our keys, the grandson and great-grandson replicate them
+//  - our father's keys are replicated by our son and grandson
+//  - our grandfather's keys are replicated by our father and son
+// Every copy is made before the LEAVE is announced, so the number of
+// replicas never drops. Keys are read when they are sent, so a key
+// written or removed meanwhile is not copied with an old value. When
+// the targets change, because one died or a node joined, the handoff
+// starts over with the new ones.
+func (n *Node) decommission() {
+	self := n.localNode()
+	ops := newRateLimiter(float64(n.config.RebalanceOpsPerSec))
+	bandwidth := newRateLimiter(float64(n.config.RebalanceBytesPerSec))
+
+	result := handoffRetarget
+	for result == handoffRetarget {
+		result = handoffDone
+		for _, step := range n.handoffSteps() {
+			// In a small ring the same node can come back around
+			if sameNode(step.target, self) {
+				continue
+			}
+			if result = n.rebalancer.handoff(step, ops, bandwidth); result != handoffDone {
+				break
+			}
+		}
+		switch result {
+		case handoffCanceled:
+			log.Println("Decommission canceled")
+			return
+		case handoffRetarget:
+			log.Println("Handoff targets changed, starting over")
+		}
+	}
+
+	n.announceLeave(n.successor(1))
+
+	// Keep answering gossip for a few rounds so the LEAVE spreads
+	// to the nodes that missed it, then stop
+	n.sleep(3 * time.Duration(n.config.GossipIntervalMs) * time.Millisecond)
+	log.Println("Decommission done, exiting")
+	n.Stop()
+}
+
+// Mark this node as left and tell every other node. The son is asked
+// to acknowledge it, since it takes over our range, but a son that
+// doesn't answer is given up on and learns it from gossip.
+func (n *Node) announceLeave(son NodeVal) {
+	self := n.localNode()
+	n.markNodeLeft(self)
+
+	reqPay := &pb.KVRequest{
+		Command:     LEAVE,
+		Addr:        []byte(n.localIP + ":" + n.localPort),
+		Incarnation: self.incarnation,
+	}
+	for _, node := range n.getNodeList() {
+		if !node.isOn || sameNode(*node, self) || sameNode(*node, son) {
+			continue
+		}
+		if _, err := n.sendRequestAndWait(*node, reqPay, migrateTimeout, migrateAttempts); err != nil {
+			log.Println("Failed to send LEAVE to", node.ipAdr+":"+node.port, err)
+		}
+	}
+
+	if sameNode(son, self) {
+		return
+	}
+	for attempt := 0; attempt < leaveAttempts; attempt++ {
+		_, err := n.sendRequestAndWait(son, reqPay, migrateTimeout, migrateAttempts)
+		if err == nil {
+			return
+		}
+		log.Println("Waiting for son to acknowledge LEAVE:", err)
+		if !n.sleep(time.Second) {
+			return
+		}
+	}
+	log.Println("Son", son.ipAdr+":"+son.port, "did not acknowledge LEAVE, leaving anyway")
+}
+
+// Handle a LEAVE sent by a node that handed its data over
+//
+// Arguments:
+//		addr: "ip:port" of the node that left
+//		incarnation: incarnation the node left with
+// Returns:
+//		NO_ERR
+func (n *Node) handleLeave(addr string, incarnation uint64) uint32 {
+	node := n.nodeFromAddr(addr)
+	node.incarnation = incarnation
+	n.markNodeLeft(node)
+	return NO_ERR
+}
@@ -0,0 +1,195 @@
+package pa2lib
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Values of the failure_detector config key
+const (
+	DETECTOR_TIMEOUT = "timeout"
+	DETECTOR_PHI     = "phi"
+)
+
+// Number of inter-arrival times kept per node by the phi detector
+const phiWindowSize = 100
+
+// Lower bound of the standard deviation used by the phi detector, so
+// that a node with very regular heartbeats isn't suspected as soon as
+// one of them is a bit late
+const phiMinStdDevMs = 100.0
+
+// Decides which members should be suspected, based on the messages
+// received from them and the probes they missed
+type FailureDetector interface {
+	// Record that a message from the node just arrived
+	heartbeat(addr string)
+	// Record that the node didn't answer a probe
+	missed(addr string)
+	// Drop what is known about the node, when it (re)joins
+	forget(addr string)
+	// Current suspicion level of the node, higher is more suspicious
+	suspicion(addr string) float64
+	// Check whether the node should be suspected now
+	suspect(addr string) bool
+}
+
+// Creates the failure detector selected by the config
+func newFailureDetector(c Config) FailureDetector {
+	if c.FailureDetector == DETECTOR_PHI {
+		return newPhiDetector(c.PhiThreshold)
+	}
+	return newTimeoutDetector()
+}
+
+// Suspects a node as soon as it misses a probe. The suspicion level is
+// the number of probes missed in a row.
+type timeoutDetector struct {
+	misses map[string]int
+	sync.Mutex
+}
+
+func newTimeoutDetector() *timeoutDetector {
+	return &timeoutDetector{misses: make(map[string]int)}
+}
+
+func (d *timeoutDetector) heartbeat(addr string) {
+	d.Lock()
+	delete(d.misses, addr)
+	d.Unlock()
+}
+
+func (d *timeoutDetector) missed(addr string) {
+	d.Lock()
+	d.misses[addr]++
+	d.Unlock()
+}
+
+func (d *timeoutDetector) forget(addr string) {
+	d.heartbeat(addr)
+}
+
+func (d *timeoutDetector) suspicion(addr string) float64 {
+	d.Lock()
+	defer d.Unlock()
+	return float64(d.misses[addr])
+}
+
+func (d *timeoutDetector) suspect(addr string) bool {
+	return d.suspicion(addr) > 0
+}
+
+// Heartbeat history of a node: the last arrival and the recent
+// inter-arrival times in milliseconds
+type arrivalWindow struct {
+	last      time.Time
+	intervals []float64
+	missed    bool
+}
+
+// Phi-accrual failure detector. Instead of a fixed timeout, it learns
+// the distribution of the time between two messages of each node, and
+// phi tells how unlikely it is that the node is still alive given the
+// time since its last message. Phi = 1 means a 10% chance of being
+// wrong when suspecting the node, phi = 2 a 1% chance and so on.
+type phiDetector struct {
+	threshold float64
+	windows   map[string]*arrivalWindow
+	sync.Mutex
+}
+
+func newPhiDetector(threshold float64) *phiDetector {
+	return &phiDetector{threshold: threshold, windows: make(map[string]*arrivalWindow)}
+}
+
+func (d *phiDetector) heartbeat(addr string) {
+	d.Lock()
+	defer d.Unlock()
+	now := time.Now()
+	window, ok := d.windows[addr]
+	if !ok {
+		d.windows[addr] = &arrivalWindow{last: now}
+		return
+	}
+
+	window.intervals = append(window.intervals, float64(now.Sub(window.last))/float64(time.Millisecond))
+	if len(window.intervals) > phiWindowSize {
+		window.intervals = window.intervals[1:]
+	}
+	window.last = now
+	window.missed = false
+}
+
+func (d *phiDetector) missed(addr string) {
+	d.Lock()
+	defer d.Unlock()
+	if window, ok := d.windows[addr]; ok {
+		window.missed = true
+	} else {
+		d.windows[addr] = &arrivalWindow{last: time.Now(), missed: true}
+	}
+}
+
+func (d *phiDetector) forget(addr string) {
+	d.Lock()
+	delete(d.windows, addr)
+	d.Unlock()
+}
+
+func (d *phiDetector) suspicion(addr string) float64 {
+	d.Lock()
+	defer d.Unlock()
+	window, ok := d.windows[addr]
+	if !ok || len(window.intervals) == 0 {
+		return 0
+	}
+	return phi(float64(time.Since(window.last))/float64(time.Millisecond), window.intervals)
+}
+
+func (d *phiDetector) suspect(addr string) bool {
+	d.Lock()
+	window, ok := d.windows[addr]
+	learning := ok && len(window.intervals) < 2 && window.missed
+	d.Unlock()
+
+	// Until there is enough history, a missed probe is all we have
+	if learning {
+		return true
+	}
+	return d.suspicion(addr) > d.threshold
+}
+
+// Compute phi for a time since the last heartbeat, assuming the
+// inter-arrival times are normally distributed. Uses the logistic
+// approximation of the normal CDF.
+//
+// Arguments:
+//		elapsed: time since the last heartbeat in milliseconds
+//		intervals: recent inter-arrival times in milliseconds
+// Returns:
+//		-log10 of the probability that the next heartbeat comes even later
+func phi(elapsed float64, intervals []float64) float64 {
+	mean := 0.0
+	for _, interval := range intervals {
+		mean += interval
+	}
+	mean /= float64(len(intervals))
+
+	variance := 0.0
+	for _, interval := range intervals {
+		variance += (interval - mean) * (interval - mean)
+	}
+	stdDev := math.Max(math.Sqrt(variance/float64(len(intervals))), phiMinStdDevMs)
+
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	var pLater float64
+	if elapsed > mean {
+		pLater = e / (1.0 + e)
+	} else {
+		pLater = 1.0 - 1.0/(1.0+e)
+	}
+	// Avoid an infinite phi when the probability underflows
+	return -math.Log10(math.Max(pLater, 1e-300))
+}
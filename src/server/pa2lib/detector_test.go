@@ -0,0 +1,81 @@
+package pa2lib
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPhi(t *testing.T) {
+	regular := []float64{1000, 1000, 1000, 1000}
+	tests := []struct {
+		name      string
+		elapsed   float64
+		intervals []float64
+		want      float64
+	}{
+		{"right after a heartbeat", 0, regular, 0},
+		{"at the mean", 1000, regular, 0.301},
+		// The standard deviation is at least phiMinStdDevMs
+		{"two deviations late", 1200, regular, 1.643},
+		{"long silence", 5000, regular, 300},
+		{"jittery heartbeats", 1200, []float64{600, 1400, 600, 1400}, 0.511},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := phi(tt.elapsed, tt.intervals); math.Abs(got-tt.want) > 0.01 {
+				t.Fatalf("phi(%v) = %.3f, want %.3f", tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhiDetectorSuspect(t *testing.T) {
+	const addr = "127.0.0.1:2"
+	tests := []struct {
+		name string
+		// Window of the node, none if nil
+		window *arrivalWindow
+		want   bool
+	}{
+		{"unknown node", nil, false},
+		{"single heartbeat", &arrivalWindow{last: time.Now()}, false},
+		{"missed probe while learning", &arrivalWindow{last: time.Now(), intervals: []float64{1000}, missed: true}, true},
+		{"on time", &arrivalWindow{last: time.Now(), intervals: []float64{1000, 1000, 1000}}, false},
+		{"silent for long", &arrivalWindow{last: time.Now().Add(-10 * time.Second), intervals: []float64{1000, 1000, 1000}}, true},
+		{"missed probe with history", &arrivalWindow{last: time.Now(), intervals: []float64{1000, 1000, 1000}, missed: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newPhiDetector(8)
+			if tt.window != nil {
+				d.windows[addr] = tt.window
+			}
+			if got := d.suspect(addr); got != tt.want {
+				t.Fatalf("got suspect %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhiDetectorHeartbeat(t *testing.T) {
+	const addr = "127.0.0.1:2"
+	d := newPhiDetector(8)
+	d.missed(addr)
+	d.heartbeat(addr)
+	if window := d.windows[addr]; window.missed || len(window.intervals) != 1 {
+		t.Fatalf("got window %+v after a heartbeat, want one interval and no missed probe", window)
+	}
+
+	for i := 0; i < phiWindowSize+10; i++ {
+		d.heartbeat(addr)
+	}
+	if got := len(d.windows[addr].intervals); got != phiWindowSize {
+		t.Fatalf("got %d intervals, want %d", got, phiWindowSize)
+	}
+
+	d.forget(addr)
+	if d.suspicion(addr) != 0 || d.suspect(addr) {
+		t.Fatal("a forgotten node is still suspected")
+	}
+}
@@ -0,0 +1,183 @@
+package pa2lib
+
+import (
+	pb "pa2/pb/protobuf"
+	"time"
+)
+
+// Types of membership events
+const (
+	NODE_JOINED    = "joined"
+	NODE_SUSPECTED = "suspected"
+	NODE_DEAD      = "dead"
+	NODE_LEFT      = "left"
+	NODE_RECOVERED = "recovered"
+)
+
+// A change in the membership of a node
+type MembershipEvent struct {
+	Type string
+	// New state of the node
+	Node NodeVal
+	// Membership of the node before the change, empty if it was unknown
+	Previous string
+	// True if the node was learned while this node was joining, so
+	// there is nothing to hand over to it
+	Initial bool
+	Time    time.Time
+}
+
+type membershipSubscriber struct {
+	id      int
+	handler func(MembershipEvent)
+}
+
+// Register a function called on every membership event. Handlers are
+// called one at a time, in the order they subscribed, so they should
+// not block.
+//
+// Arguments:
+//		handler: function called with each event
+// Returns:
+//		An id to unsubscribe with
+func (n *Node) subscribeMembership(handler func(MembershipEvent)) int {
+	n.membershipSubscribersMutex.Lock()
+	defer n.membershipSubscribersMutex.Unlock()
+	n.nextSubscriberID++
+	n.membershipSubscribers = append(n.membershipSubscribers, membershipSubscriber{id: n.nextSubscriberID, handler: handler})
+	return n.nextSubscriberID
+}
+
+// Stop calling a handler registered with subscribeMembership
+func (n *Node) unsubscribeMembership(id int) {
+	n.membershipSubscribersMutex.Lock()
+	defer n.membershipSubscribersMutex.Unlock()
+	for i, subscriber := range n.membershipSubscribers {
+		if subscriber.id == id {
+			n.membershipSubscribers = append(n.membershipSubscribers[:i], n.membershipSubscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Send an event to every subscriber
+func (n *Node) publishMembership(event MembershipEvent) {
+	n.membershipSubscribersMutex.RLock()
+	subscribers := append([]membershipSubscriber{}, n.membershipSubscribers...)
+	n.membershipSubscribersMutex.RUnlock()
+
+	for _, subscriber := range subscribers {
+		subscriber.handler(event)
+	}
+}
+
+// Check whether a membership state puts the node in the hash ring
+func inRing(membership string) bool {
+	return membership == MEMBERSHIP_MEMBER || membership == MEMBERSHIP_SUSPECT
+}
+
+// Get the event a change of membership state is
+//
+// Arguments:
+//		previous: state before the change, empty if the node was unknown
+//		membership: state after the change
+// Returns:
+//		The type of the event, and false if the change isn't an event
+func membershipEventType(previous string, membership string) (string, bool) {
+	switch {
+	case !inRing(previous) && inRing(membership):
+		if previous == MEMBERSHIP_DEAD {
+			return NODE_RECOVERED, true
+		}
+		return NODE_JOINED, true
+	case previous == MEMBERSHIP_MEMBER && membership == MEMBERSHIP_SUSPECT:
+		return NODE_SUSPECTED, true
+	case previous == MEMBERSHIP_SUSPECT && membership == MEMBERSHIP_MEMBER:
+		return NODE_RECOVERED, true
+	case inRing(previous) && membership == MEMBERSHIP_DEAD:
+		return NODE_DEAD, true
+	case inRing(previous) && membership == MEMBERSHIP_LEFT:
+		return NODE_LEFT, true
+	}
+	return "", false
+}
+
+// Convert an event to its protobuf form
+func membershipEventToPb(event MembershipEvent) *pb.MembershipEvent {
+	return &pb.MembershipEvent{
+		Type:     event.Type,
+		Node:     nodeToPb(event.Node),
+		Previous: event.Previous,
+		Time:     uint64(event.Time.UnixNano()),
+	}
+}
+
+// Keep the hash ring made of the alive and suspected nodes
+func (n *Node) updateHashRing(event MembershipEvent) {
+	switch event.Type {
+	case NODE_JOINED, NODE_RECOVERED:
+		n.consistent.addNodetoHashring(event.Node)
+	case NODE_DEAD, NODE_LEFT:
+		n.consistent.removeNodefromHashring(event.Node.ipAdr, event.Node.port)
+	}
+}
+
+// Hand data over to the nodes entering the ring, and re-replicate the
+// data of dead nodes. A left node already handed its data over.
+func (n *Node) replicateOnMembershipChange(event MembershipEvent) {
+	if event.Initial {
+		return
+	}
+	switch {
+	case event.Type == NODE_JOINED || event.Type == NODE_RECOVERED && event.Previous == MEMBERSHIP_DEAD:
+		go n.welcomeNewNode(event.Node)
+	case event.Type == NODE_DEAD && n.hasQuorum():
+		// Without a majority the node may only be on the other side of
+		// a partition, whose side keeps the data. Like the welcome, it
+		// doesn't hold up the publisher.
+		go n.nodeDieReplicate(event.Node)
+	}
+}
+
+// Keep the failure detector in line with the membership
+func (n *Node) updateDetector(event MembershipEvent) {
+	addr := event.Node.ipAdr + ":" + event.Node.port
+	switch {
+	case event.Type == NODE_RECOVERED && event.Previous == MEMBERSHIP_SUSPECT:
+		// The node refuted its suspicion, fresh news that it is alive
+		n.detector.heartbeat(addr)
+	case event.Type == NODE_JOINED || event.Type == NODE_RECOVERED:
+		// Heartbeats from before it went away say nothing about it now
+		n.detector.forget(addr)
+	}
+}
+
+// Count the events by type
+func (n *Node) countMembershipEvent(event MembershipEvent) {
+	n.membershipEventCountsMutex.Lock()
+	n.membershipEventCounts[event.Type]++
+	n.membershipEventCountsMutex.Unlock()
+}
+
+// Returns the number of membership events seen since the node started,
+// per type
+func (n *Node) getMembershipEventCounts() map[string]uint64 {
+	n.membershipEventCountsMutex.Lock()
+	defer n.membershipEventCountsMutex.Unlock()
+	counts := make(map[string]uint64)
+	for eventType, count := range n.membershipEventCounts {
+		counts[eventType] = count
+	}
+	return counts
+}
+
+// Subscribe the parts of the server that react to membership changes.
+// The hash ring goes first, so the others see the ring after the change.
+func (n *Node) subscribeMembershipHandlers() {
+	n.subscribeMembership(n.updateHashRing)
+	n.subscribeMembership(n.updateDetector)
+	n.subscribeMembership(n.replicateOnMembershipChange)
+	n.subscribeMembership(n.dropIncomingMigrations)
+	n.subscribeMembership(n.countMembershipEvent)
+	n.subscribeMembership(n.logModeChange)
+}
@@ -0,0 +1,153 @@
+package pa2lib
+
+import (
+	"time"
+)
+
+// Time between two sweeps of the expired keys
+const expirySweepInterval = 1 * time.Second
+
+// Set the time to live of a key
+func (n *Node) setExpiry(key []byte, ttl time.Duration) {
+	n.expiriesMutex.Lock()
+	n.expiries[string(key)] = time.Now().Add(ttl)
+	n.expiriesMutex.Unlock()
+}
+
+// Remove the time to live of a key
+func (n *Node) clearExpiry(key []byte) {
+	n.expiriesMutex.Lock()
+	delete(n.expiries, string(key))
+	n.expiriesMutex.Unlock()
+}
+
+// Get the time at which a key expires
+//
+// Returns:
+//		The expiry, and false if the key has no time to live
+func (n *Node) getExpiry(key []byte) (time.Time, bool) {
+	n.expiriesMutex.Lock()
+	defer n.expiriesMutex.Unlock()
+	expiry, ok := n.expiries[string(key)]
+	return expiry, ok
+}
+
+// Set the time to live of a key, or remove it for 0
+//
+// Arguments:
+//		key: key to set the time to live of
+//		ttlMs: time to live in milliseconds
+func (n *Node) resetExpiry(key []byte, ttlMs uint64) {
+	if ttlMs > 0 {
+		n.setExpiry(key, time.Duration(ttlMs)*time.Millisecond)
+	} else {
+		n.clearExpiry(key)
+	}
+}
+
+// Remove the local copy of a key only if it still expires at a given
+// time, so a key written again since it was found due is kept
+//
+// Returns:
+//		NO_ERR if the key was removed, otherwise KEY_DNE_ERR
+func (n *Node) removeIfExpiresAt(key []byte, expiry time.Time) uint32 {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.expiriesMutex.Lock()
+	current, ok := n.expiries[string(key)]
+	if !ok || !current.Equal(expiry) {
+		n.expiriesMutex.Unlock()
+		return KEY_DNE_ERR
+	}
+	delete(n.expiries, string(key))
+	n.expiriesMutex.Unlock()
+	return n.removeLocked(key)
+}
+
+// Remove a key whose time to live ran out, unless it was written again
+// or given another time to live meanwhile
+func (n *Node) removeExpired(key []byte, expiry time.Time) {
+	n.removeOwnedWith(key, func(key []byte) uint32 {
+		return n.removeIfExpiresAt(key, expiry)
+	})
+}
+
+// Remove a key if its time to live ran out, so it isn't seen before the
+// next sweep
+func (n *Node) expireIfDue(key []byte) {
+	if expiry, ok := n.getExpiry(key); ok && time.Now().After(expiry) {
+		n.removeExpired(key, expiry)
+	}
+}
+
+// Put a key this node owns, with a time to live or without one. Like
+// a Redis SET, a put without a time to live removes the previous one,
+// and the flags of the key are replaced the same way. The time to live
+// changes before the value, so a sweep that found the previous value due
+// doesn't remove the new one.
+//
+// Arguments:
+//		key, value, version: same as Put
+//		ttlMs: time to live in milliseconds, 0 for none
+//		flags: flags of a memcached client, 0 for none
+// Returns:
+//		Error code
+func (n *Node) putOwnedWithTTL(key []byte, value []byte, version *int32, ttlMs uint64, flags uint32) uint32 {
+	previous, hadExpiry := n.getExpiry(key)
+	n.resetExpiry(key, ttlMs)
+	errCode := n.putOwned(key, value, version)
+	if errCode != NO_ERR {
+		// The previous value stays, with its time to live
+		n.expiriesMutex.Lock()
+		if hadExpiry {
+			n.expiries[string(key)] = previous
+		} else {
+			delete(n.expiries, string(key))
+		}
+		n.expiriesMutex.Unlock()
+		return errCode
+	}
+	n.setFlags(key, flags)
+	return errCode
+}
+
+// Handle an EXPIRE of a key this node owns
+//
+// Arguments:
+//		key: key to expire
+//		ttlMs: time to live in milliseconds, 0 to remove the time to live
+// Returns:
+//		NO_ERR if the key exists, otherwise KEY_DNE_ERR
+func (n *Node) expireOwned(key []byte, ttlMs uint64) uint32 {
+	n.expireIfDue(key)
+	if _, _, errCode := n.getOwned(key); errCode != NO_ERR {
+		return errCode
+	}
+	n.resetExpiry(key, ttlMs)
+	return NO_ERR
+}
+
+// Loops forever to remove the keys whose time to live ran out. Should
+// be called as a goroutine so it can run in the background
+func (n *Node) ExpiryLoop() {
+	for {
+		if !n.sleep(expirySweepInterval) {
+			return
+		}
+
+		now := time.Now()
+		due := make(map[string]time.Time)
+		n.expiriesMutex.Lock()
+		for key, expiry := range n.expiries {
+			if now.After(expiry) {
+				due[key] = expiry
+			}
+		}
+		n.expiriesMutex.Unlock()
+
+		// A key written since it was found due keeps its new value
+		for key, expiry := range due {
+			n.removeExpired([]byte(key), expiry)
+		}
+	}
+}
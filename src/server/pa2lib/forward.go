@@ -0,0 +1,344 @@
+package pa2lib
+
+import (
+	"log"
+	"net"
+	pb "pa2/pb/protobuf"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Time to wait for the ack of a request forwarded over UDP, doubled at
+// every attempt, and number of attempts per node
+const forwardTimeout = 100 * time.Millisecond
+const forwardAttempts = 3
+
+// Check whether a command is a client request forwarded by another node,
+// carrying the address of the client
+func isForwardedCommand(cmd uint32) bool {
+	switch cmd {
+	case PUT_FORWARD, GET_FORWARD, REMOVE_FORWARD, EXPIRE_FORWARD, INCR_FORWARD, PUT_IF_FORWARD,
+		GET_FALLBACK, PUT_FALLBACK, REMOVE_FALLBACK:
+		return true
+	}
+	return false
+}
+
+// Turn a client command into the command sending it to a replica of the
+// node owning its key
+//
+// Returns:
+//		False if the command can't be served by a replica
+func setFallbackCommand(reqPay *pb.KVRequest) bool {
+	switch reqPay.Command {
+	case GET:
+		reqPay.Command = GET_FALLBACK
+	case PUT:
+		reqPay.Command = PUT_FALLBACK
+	case REMOVE:
+		reqPay.Command = REMOVE_FALLBACK
+	default:
+		return false
+	}
+	return true
+}
+
+// Get the nodes a request for a key is sent to, in order: the owner,
+// then for reads (and writes with a sloppy quorum) its son and grandson,
+// which hold the replicas of its keys
+func (n *Node) forwardTargets(owner NodeVal, cmd uint32) []NodeVal {
+	targets := []NodeVal{owner}
+	if !isReadCommand(cmd) && !(n.config.SloppyQuorum && (cmd == PUT || cmd == REMOVE)) {
+		return targets
+	}
+
+	son := n.consistent.getNextNode(owner)
+	grandson := n.consistent.getNextNode(son)
+	for _, node := range []NodeVal{son, grandson} {
+		if !nodeExists(targets, node) {
+			targets = append(targets, node)
+		}
+	}
+	return targets
+}
+
+// Turn a command forwarded to the owner of its key back into the command
+// of the client
+//
+// Returns:
+//		False if the command isn't forwarded to an owner
+func clientCommandOf(cmd uint32) (uint32, bool) {
+	switch cmd {
+	case GET_FORWARD:
+		return GET, true
+	case PUT_FORWARD:
+		return PUT, true
+	case REMOVE_FORWARD:
+		return REMOVE, true
+	case EXPIRE_FORWARD:
+		return EXPIRE, true
+	case INCR_FORWARD:
+		return INCR, true
+	case PUT_IF_FORWARD:
+		return PUT_IF, true
+	}
+	return cmd, false
+}
+
+// Make the request sent to one of the targets of a forward. It counts one
+// more hop and adds this node to the trace of the request.
+//
+// Arguments:
+//		reqPay: request of the client
+//		fallback: true for a replica, false for the owner
+// Returns:
+//		The request, nil if the command can't go to a replica
+func (n *Node) forwardedRequest(reqPay *pb.KVRequest, fallback bool) *pb.KVRequest {
+	fwdPay := proto.Clone(reqPay).(*pb.KVRequest)
+	if fallback {
+		if !setFallbackCommand(fwdPay) {
+			return nil
+		}
+	} else {
+		setForwardCommand(fwdPay)
+	}
+	fwdPay.Hops++
+	fwdPay.Trace = append(fwdPay.Trace, n.localIP + ":" + n.localPort)
+	if n.config.TraceForwards {
+		log.Println("Forwarding request for key", string(reqPay.Key), "trace:", formatTrace(fwdPay.Trace))
+	}
+	return fwdPay
+}
+
+// Format the nodes a request went through, in order, for the logs
+func formatTrace(trace []string) string {
+	return strings.Join(trace, " -> ")
+}
+
+func inTrace(trace []string, addr string) bool {
+	for _, hop := range trace {
+		if hop == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Send a request forwarded to this node on to the owner of its key when
+// this node doesn't own it, since the two nodes see different rings. A
+// request that has been forwarded max_hops times already, or would go
+// back to a node it went through, is looping between nodes: the client
+// gets TOO_MANY_HOPS_ERR with the trace of the request.
+//
+// Arguments:
+//		clientAddr: address of the forwarding node, or the client over TCP
+//		stream: connection the request came on, nil over UDP
+//		msgID: message ID of the client request
+//		reqPay: forwarded request
+// Returns:
+//		True if the request was handled, false if this node serves it
+func (n *Node) rerouteForward(clientAddr *net.UDPAddr, stream responseStream, msgID []byte, reqPay *pb.KVRequest) bool {
+	cmd, forwarded := clientCommandOf(reqPay.Command)
+	if !forwarded {
+		return false
+	}
+	node, owned := n.checkNode(reqPay.Key)
+	if owned {
+		if n.config.TraceForwards {
+			log.Println("Serving forwarded request for key", string(reqPay.Key), "trace:", formatTrace(append(reqPay.Trace, n.localIP + ":" + n.localPort)))
+		}
+		return false
+	}
+
+	clientAddr = forwardedClientAddr(clientAddr, stream, reqPay.Addr)
+	next := node.ipAdr + ":" + node.port
+	if reqPay.Hops >= n.config.MaxHops || inTrace(reqPay.Trace, next) {
+		trace := append(reqPay.Trace, n.localIP + ":" + n.localPort, next)
+		log.Println("Routing loop for key", string(reqPay.Key), "after", reqPay.Hops, "hops, trace:", formatTrace(trace))
+		n.sendUncachedResponse(clientAddr, stream, msgID, &pb.KVResponse{ErrCode: TOO_MANY_HOPS_ERR, Trace: trace})
+		return true
+	}
+	reqPay.Command = cmd
+	n.forwardRequest(node, reqPay, msgID, clientAddr, stream)
+	return true
+}
+
+// Start waiting for the ack of a forward
+//
+// Returns:
+//		The channel the ack arrives on, and false if the message is
+//		already being forwarded
+func (n *Node) awaitForwardAck(msgID []byte) (chan bool, bool) {
+	n.pendingForwardsMutex.Lock()
+	defer n.pendingForwardsMutex.Unlock()
+	if _, pending := n.pendingForwards[string(msgID)]; pending {
+		return nil, false
+	}
+	acked := make(chan bool, 1)
+	n.pendingForwards[string(msgID)] = acked
+	return acked, true
+}
+
+func (n *Node) endForward(msgID []byte) {
+	n.pendingForwardsMutex.Lock()
+	delete(n.pendingForwards, string(msgID))
+	n.pendingForwardsMutex.Unlock()
+}
+
+// Handle the FORWARD_ACK of a node that answered a forwarded request
+func (n *Node) forwardAcked(msgID []byte) {
+	n.pendingForwardsMutex.Lock()
+	defer n.pendingForwardsMutex.Unlock()
+	if acked, pending := n.pendingForwards[string(msgID)]; pending {
+		select {
+		case acked <- true:
+		default:
+		}
+	}
+}
+
+// Tell the node that forwarded a request over UDP that it was answered
+//
+// Arguments:
+//		addr: address of the forwarding node
+//		msgID: message ID of the request
+func (n *Node) sendForwardAck(addr *net.UDPAddr, msgID []byte) {
+	reqMsgBytes, err := marshalRequestMsg(msgID, &pb.KVRequest{Command: FORWARD_ACK})
+	if err != nil {
+		return
+	}
+	if _, err := n.conn.WriteToUDP(reqMsgBytes, addr); err != nil {
+		log.Println("Could not ack forward to", addr, err)
+	}
+}
+
+// Tell the client that no node holding its key answered. The response
+// isn't cached, so a retry of the client is forwarded again.
+func (n *Node) sendUnreachable(clientAddr *net.UDPAddr, stream responseStream, msgID []byte) {
+	n.sendUncachedResponse(clientAddr, stream, msgID, &pb.KVResponse{ErrCode: UNREACHABLE_ERR})
+}
+
+// Send a response that isn't cached, for errors a retry may not get
+func (n *Node) sendUncachedResponse(clientAddr *net.UDPAddr, stream responseStream, msgID []byte, respPay *pb.KVResponse) {
+	respMsgBytes, err := marshalResponseMsg(msgID, respPay)
+	if err != nil {
+		return
+	}
+	n.writeToClient(clientAddr, stream, respMsgBytes)
+}
+
+// Get the response message of a payload
+func marshalResponseMsg(msgID []byte, respPay *pb.KVResponse) ([]byte, error) {
+	respPayBytes, err := proto.Marshal(respPay)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&pb.Msg{
+		MessageID: msgID,
+		Payload:   respPayBytes,
+		CheckSum:  getChecksum(msgID, respPayBytes),
+	})
+}
+
+// Forward a client request over UDP so the node holding its key answers
+// the client itself. The node acks the forward, without an ack it is
+// sent again with backoff, then to the replicas of the owner. If none of
+// them acks, the client gets UNREACHABLE_ERR.
+//
+// Arguments:
+//		owner: node owning the key
+//		reqPay: request payload
+//		msgID: message ID of the client request
+//		clientAddr: address of the client
+func (n *Node) forwardOverUDP(owner NodeVal, reqPay *pb.KVRequest, msgID []byte, clientAddr *net.UDPAddr) {
+	acked, first := n.awaitForwardAck(msgID)
+	if !first {
+		return
+	}
+	defer n.endForward(msgID)
+
+	for i, node := range n.forwardTargets(owner, reqPay.Command) {
+		fwdPay := n.forwardedRequest(reqPay, i > 0)
+		if fwdPay == nil {
+			break
+		}
+		timeout := forwardTimeout
+		for attempt := 0; attempt < forwardAttempts; attempt++ {
+			n.sendToNode(node, fwdPay, msgID)
+			select {
+			case <-acked:
+				return
+			case <-time.After(timeout):
+				timeout *= 2
+			}
+		}
+		log.Println("No ack of forwarded request from", node.ipAdr+":"+node.port)
+	}
+	n.sendUnreachable(clientAddr, nil, msgID)
+}
+
+// Get which replica store of this node holds a key it doesn't own: 0 if
+// this node is the son of the owner, 1 if it is the grandson
+func (n *Node) replicaFlagOf(key []byte) int {
+	owner := n.consistent.getNode(key)
+	if sameNode(n.consistent.getNextNode(owner), n.localNode()) {
+		return 0
+	}
+	return 1
+}
+
+// Handle a GET the forwarding node couldn't get to the owner of the key.
+// By now this node may own the key, otherwise it reads its replica. A
+// replica may lack a key the owner holds, so a miss there is not taken
+// as the key not existing.
+//
+// Returns:
+//		Same as Get, with UNREACHABLE_ERR instead of KEY_DNE_ERR when
+//		the replicas don't have the key
+func (n *Node) getFallback(key []byte) ([]byte, int32, uint32) {
+	if _, owned := n.checkNode(key); owned {
+		n.expireIfDue(key)
+		return n.getOwned(key)
+	}
+	for flag := 0; flag < len(n.repKVStore); flag++ {
+		if value, version, errCode := n.GetReplicate(key, flag); errCode == NO_ERR {
+			return value, version, errCode
+		}
+	}
+	return nil, 0, UNREACHABLE_ERR
+}
+
+// Handle a PUT the forwarding node couldn't get to the owner of the key,
+// with a sloppy quorum. The value goes to the replica of this node, which
+// becomes the owner's copy if the owner is found dead, and is kept as a
+// hint replayed to the owner if it was only slow. A write the owner took
+// after this one is not overwritten by the hint.
+//
+// Returns:
+//		Error code
+func (n *Node) putFallback(key []byte, value []byte, version int32, ttlMs uint64, flags uint32) uint32 {
+	if _, owned := n.checkNode(key); owned {
+		return n.putOwnedWithTTL(key, value, &version, ttlMs, flags)
+	}
+	errCode := n.PutReplicate(key, value, &version, n.replicaFlagOf(key))
+	if errCode == NO_ERR {
+		n.addHint(key, PUT, value, version, ttlMs, flags)
+	}
+	return errCode
+}
+
+// Handle a REMOVE the forwarding node couldn't get to the owner of the
+// key, with a sloppy quorum. Like a PUT, it is kept as a hint for the
+// owner, even if the replica didn't have the key.
+//
+// Returns:
+//		NO_ERR if the key existed, otherwise KEY_DNE_ERR
+func (n *Node) removeFallback(key []byte) uint32 {
+	if _, owned := n.checkNode(key); owned {
+		return n.removeOwned(key)
+	}
+	n.addHint(key, REMOVE, nil, 0, 0, 0)
+	return n.RemoveReplicate(key, n.replicaFlagOf(key))
+}
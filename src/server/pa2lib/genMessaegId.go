@@ -5,9 +5,15 @@ import (
 	"log"
 	"math"
 	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
+// Sequence number of the last message ID made by this node, updated
+// atomically. It starts at the start time in nanoseconds, so a restarted
+// node doesn't reuse the IDs still in the caches of the others.
+var lastMsgSeq = uint64(time.Now().UnixNano())
+
 // Generate a unique message ID in the following format:
 //    bytes           field
 //    0 - 3      Client IP address
@@ -46,3 +52,19 @@ func generateUniqueMsgID(clientIPaddress []byte, clientPort int)([]byte) {
 }
 
 
+
+// Generate a message ID for a request made by this node, in the same
+// format as generateUniqueMsgID but with a sequence number in place of
+// the timestamp. Two requests made at once for the same client get
+// different IDs, where the 2 random bytes alone may collide.
+//
+// Arguments:
+//     clientIPaddress: client IP address as a byte array
+//     clientPort:      client port number
+// Returns:
+//     Unique 16-byte message ID
+func generateLocalMsgID(clientIPaddress []byte, clientPort int) []byte {
+	uniqueID := generateUniqueMsgID(clientIPaddress, clientPort)
+	binary.LittleEndian.PutUint64(uniqueID[8:], atomic.AddUint64(&lastMsgSeq, 1))
+	return uniqueID
+}
@@ -0,0 +1,291 @@
+package pa2lib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	pb "pa2/pb/protobuf"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Number of membership events a Watch call can fall behind by before
+// events are dropped
+const grpcWatchBuffer = 64
+
+// Get the address of the client of a gRPC call
+func grpcClientAddr(ctx context.Context) *net.UDPAddr {
+	if p, ok := peer.FromContext(ctx); ok {
+		if addr, ok := p.Addr.(*net.TCPAddr); ok {
+			return &net.UDPAddr{IP: addr.IP, Port: addr.Port}
+		}
+	}
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// Get the gRPC status of an error of callLocal
+func localErrToStatus(err error) error {
+	if err == errNoLocalResponse || err == context.DeadlineExceeded || err == context.Canceled {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Run a request for a gRPC call and turn its error code into a gRPC
+// status
+func (n *Node) callLocalChecked(ctx context.Context, reqPay *pb.KVRequest) (*pb.KVResponse, error) {
+	respPay, err := n.callLocal(ctx, grpcClientAddr(ctx), reqPay)
+	if err != nil {
+		return nil, localErrToStatus(err)
+	}
+	return respPay, errCodeToStatus(respPay)
+}
+
+// Get the gRPC status of an error code
+//
+// Arguments:
+//		respPay: response carrying the error code
+// Returns:
+//		nil for NO_ERR, the matching gRPC status otherwise
+func errCodeToStatus(respPay *pb.KVResponse) error {
+	message := errCodeMessage(respPay.ErrCode)
+	switch respPay.ErrCode {
+	case NO_ERR:
+		return nil
+	case KEY_DNE_ERR:
+		return status.Error(codes.NotFound, message)
+	case NO_SPC_ERR:
+		return status.Error(codes.ResourceExhausted, message)
+	case SYS_OVERLOAD_ERR:
+		return status.Errorf(codes.Unavailable, "%s, retry in %d ms", message, respPay.OverloadWaitTime)
+	case KV_INTERNAL_ERR:
+		return status.Error(codes.Internal, message)
+	case UNKNOWN_CMD_ERR:
+		return status.Error(codes.Unimplemented, message)
+	case INVALID_KEY_ERR, INVALID_VAL_ERR:
+		return status.Error(codes.InvalidArgument, message)
+	case CONDITION_FAILED_ERR:
+		return status.Error(codes.FailedPrecondition, message)
+	case UNREACHABLE_ERR:
+		return status.Error(codes.Unavailable, message)
+	case TOO_MANY_HOPS_ERR:
+		return status.Errorf(codes.Unavailable, "%s: %s", message, strings.Join(respPay.Trace, " -> "))
+	case NO_QUORUM_ERR:
+		return status.Errorf(codes.Unavailable, "%s, node is in %s mode", message, respPay.ClusterMode)
+	}
+	return status.Error(codes.Unknown, message)
+}
+
+// Implementation of the KVService gRPC API on top of handleKVRequest
+type kvServer struct {
+	pb.UnimplementedKVServiceServer
+	node *Node
+}
+
+func (s *kvServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	respPay, err := s.node.callLocalChecked(ctx, &pb.KVRequest{Command: GET, Key: req.Key})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetResponse{Value: respPay.Value, Version: respPay.Version}, nil
+}
+
+func (s *kvServer) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	_, err := s.node.callLocalChecked(ctx, &pb.KVRequest{Command: PUT, Key: req.Key, Value: req.Value, Version: req.Version})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.PutResponse{}, nil
+}
+
+func (s *kvServer) Remove(ctx context.Context, req *pb.RemoveRequest) (*pb.RemoveResponse, error) {
+	_, err := s.node.callLocalChecked(ctx, &pb.KVRequest{Command: REMOVE, Key: req.Key})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RemoveResponse{}, nil
+}
+
+func (s *kvServer) Batch(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	resp := &pb.BatchResponse{}
+	for _, op := range req.Operations {
+		reqPay := &pb.KVRequest{Key: op.Key, Value: op.Value, Version: op.Version}
+		switch op.Op {
+		case pb.BatchOperation_GET:
+			reqPay.Command = GET
+		case pb.BatchOperation_PUT:
+			reqPay.Command = PUT
+		case pb.BatchOperation_REMOVE:
+			reqPay.Command = REMOVE
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unknown operation %v", op.Op)
+		}
+
+		respPay, err := s.node.callLocal(ctx, grpcClientAddr(ctx), reqPay)
+		if err != nil {
+			return nil, localErrToStatus(err)
+		}
+		resp.Results = append(resp.Results, &pb.BatchResult{
+			ErrCode: respPay.ErrCode,
+			Value:   respPay.Value,
+			Version: respPay.Version,
+		})
+	}
+	return resp, nil
+}
+
+func (s *kvServer) Scan(req *pb.ScanRequest, srv pb.KVService_ScanServer) error {
+	if _, fenced := s.node.fencedCommand(GET); fenced {
+		return status.Errorf(codes.Unavailable, "%s, node is in %s mode", errCodeMessage(NO_QUORUM_ERR), s.node.clusterMode())
+	}
+	entries, err := s.node.scanCluster(req.StartAfter, req.Prefix, req.Limit)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	for _, entry := range entries {
+		if err := srv.Send(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *kvServer) Watch(req *pb.WatchRequest, srv pb.KVService_WatchServer) error {
+	ctx := srv.Context()
+	stream := newLocalStream(grpcWatchBuffer)
+	s.node.startLocalRequest(grpcClientAddr(ctx), stream, &pb.KVRequest{Command: WATCH_MEMBERSHIP})
+	defer s.node.removeWatch(grpcClientAddr(ctx), stream)
+
+	// Renew the watch before it expires
+	renew := time.NewTicker(watchTTL / 2)
+	defer renew.Stop()
+
+	listed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-renew.C:
+			s.node.startLocalRequest(grpcClientAddr(ctx), stream, &pb.KVRequest{Command: WATCH_MEMBERSHIP})
+		case msg := <-stream.responses:
+			respPay, err := unmarshalKVResponse(msg)
+			if err != nil {
+				continue
+			}
+			if respPay.Event != nil {
+				if err := srv.Send(respPay.Event); err != nil {
+					return err
+				}
+				continue
+			}
+			if listed {
+				// Response to a renewal
+				continue
+			}
+			listed = true
+			for _, entry := range respPay.NodeList {
+				node := &pb.NodeVal{}
+				if proto.Unmarshal(entry, node) != nil || !inRing(node.Membership) {
+					continue
+				}
+				event := &pb.MembershipEvent{Type: NODE_JOINED, Node: node, Time: uint64(time.Now().UnixNano())}
+				if err := srv.Send(event); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (s *kvServer) Members(ctx context.Context, req *pb.MembersRequest) (*pb.MembersResponse, error) {
+	respPay, err := s.node.callLocalChecked(ctx, &pb.KVRequest{Command: MEMBERSHIP_QUERY, Value: []byte(req.Filter)})
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.MembersResponse{}
+	for _, entry := range respPay.NodeList {
+		node := &pb.NodeVal{}
+		if err := proto.Unmarshal(entry, node); err == nil {
+			resp.Nodes = append(resp.Nodes, node)
+		}
+	}
+	return resp, nil
+}
+
+func (s *kvServer) Health(ctx context.Context, req *pb.HealthRequest) (*pb.ClusterHealth, error) {
+	reqPay := &pb.KVRequest{Command: CLUSTER_HEALTH}
+	if req.Local {
+		reqPay.Value = []byte(HEALTH_LOCAL)
+	}
+	respPay, err := s.node.callLocalChecked(ctx, reqPay)
+	if err != nil {
+		return nil, err
+	}
+	return respPay.Health, nil
+}
+
+func (s *kvServer) GetRebalanceStatus(ctx context.Context, req *pb.AdminRequest) (*pb.RebalanceStatus, error) {
+	respPay, err := s.node.callLocalChecked(ctx, &pb.KVRequest{Command: REBALANCE_STATUS})
+	if err != nil {
+		return nil, err
+	}
+	return respPay.Rebalance, nil
+}
+
+// Run an admin command that returns nothing but an error code
+func (s *kvServer) admin(ctx context.Context, cmd uint32) (*pb.AdminResponse, error) {
+	if _, err := s.node.callLocalChecked(ctx, &pb.KVRequest{Command: cmd}); err != nil {
+		return nil, err
+	}
+	return &pb.AdminResponse{}, nil
+}
+
+func (s *kvServer) PauseRebalance(ctx context.Context, req *pb.AdminRequest) (*pb.AdminResponse, error) {
+	return s.admin(ctx, REBALANCE_PAUSE)
+}
+
+func (s *kvServer) ResumeRebalance(ctx context.Context, req *pb.AdminRequest) (*pb.AdminResponse, error) {
+	return s.admin(ctx, REBALANCE_RESUME)
+}
+
+func (s *kvServer) CancelRebalance(ctx context.Context, req *pb.AdminRequest) (*pb.AdminResponse, error) {
+	return s.admin(ctx, REBALANCE_CANCEL)
+}
+
+func (s *kvServer) Decommission(ctx context.Context, req *pb.AdminRequest) (*pb.AdminResponse, error) {
+	return s.admin(ctx, DECOMMISSION)
+}
+
+func (s *kvServer) Wipeout(ctx context.Context, req *pb.AdminRequest) (*pb.AdminResponse, error) {
+	return s.admin(ctx, WIPEOUT)
+}
+
+// Starts the gRPC server of the KVService API
+//
+// Arguments:
+//		port: port number to listen on
+func (n *Node) GRPCHandler(port int) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Println("Error setting up the gRPC server:", err)
+		return
+	}
+
+	server := grpc.NewServer()
+	pb.RegisterKVServiceServer(server, &kvServer{node: n})
+	go func() {
+		<-n.stopped
+		server.Stop()
+	}()
+	log.Println("gRPC server listening on port", port)
+	if err := server.Serve(listener); err != nil {
+		log.Println("gRPC server stopped:", err)
+	}
+}
@@ -0,0 +1,191 @@
+package pa2lib
+
+import (
+	"fmt"
+	"hash/fnv"
+	pb "pa2/pb/protobuf"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of copies of every key: the owner, its son and its grandson
+const replicationFactor = 3
+
+// Time to wait for a node to report its own health
+const healthTimeout = 200 * time.Millisecond
+const healthAttempts = 2
+
+// Value of a CLUSTER_HEALTH request asking for the node's own view only
+const HEALTH_LOCAL = "local"
+
+// Count the nodes of the node list by membership state, this node
+// included
+//
+// Returns:
+//		The number of alive, suspected, dead and left nodes
+func (n *Node) countMembers() (uint32, uint32, uint32, uint32) {
+	var alive, suspect, dead, left uint32
+	for _, node := range n.getNodeList() {
+		switch node.membership {
+		case MEMBERSHIP_MEMBER:
+			alive++
+		case MEMBERSHIP_SUSPECT:
+			suspect++
+		case MEMBERSHIP_DEAD:
+			dead++
+		case MEMBERSHIP_LEFT:
+			left++
+		}
+	}
+	return alive, suspect, dead, left
+}
+
+// Get the nodes of the hash ring, in ring order
+func (c *Consistent) getRingNodes() []NodeVal {
+	_, nodes := c.getRing()
+	return nodes
+}
+
+// Get the nodes of the hash ring and their hashes, in ring order
+func (c *Consistent) getRing() ([]uint32, []NodeVal) {
+	c.Lock()
+	defer c.Unlock()
+	return getSortedNodeList(c.circle)
+}
+
+// Get the epoch of the ring this node sees, a hash of the nodes in the
+// ring and their incarnations. Nodes that agree on the ring are on the
+// same epoch.
+func (n *Node) ringEpoch() uint64 {
+	nodeList := n.getNodeList()
+	var addrs []string
+	for _, node := range n.consistent.getRingNodes() {
+		addrs = append(addrs, node.ipAdr+":"+node.port)
+	}
+	sort.Strings(addrs)
+
+	hash := fnv.New64a()
+	for _, addr := range addrs {
+		var incarnation uint64
+		if node, ok := nodeList[addr]; ok {
+			incarnation = node.incarnation
+		}
+		fmt.Fprintf(hash, "%s %d\n", addr, incarnation)
+	}
+	return hash.Sum64()
+}
+
+// Get the nodes of the ring marshalled as a membership list, for the
+// clients that send requests straight to the owner of a key
+func (n *Node) getRingMembership() map[string][]byte {
+	entries := make(map[string][]byte)
+	for _, node := range n.consistent.getRingNodes() {
+		addr := node.ipAdr + ":" + node.port
+		entries[addr] = n.membershipEntry(addr, node)
+	}
+	return entries
+}
+
+// Count the ranges of the ring with fewer alive copies than the
+// replication factor. A range is held by its owner, its son and its
+// grandson, and a suspected node doesn't count as a copy.
+func (n *Node) underReplicatedRanges() uint32 {
+	nodes := n.consistent.getRingNodes()
+	nodeList := n.getNodeList()
+
+	var count uint32
+	for i := range nodes {
+		copies := 0
+		for j := 0; j < replicationFactor && j < len(nodes); j++ {
+			holder := nodes[(i+j)%len(nodes)]
+			if node, ok := nodeList[holder.ipAdr+":"+holder.port]; ok && node.membership == MEMBERSHIP_MEMBER {
+				copies++
+			}
+		}
+		if copies < replicationFactor {
+			count++
+		}
+	}
+	return count
+}
+
+// Count the ranges this node still has to hand over, including its
+// whole data while it is being decommissioned
+func (n *Node) pendingHandoffs() uint32 {
+	n.rebalancer.Lock()
+	defer n.rebalancer.Unlock()
+	count := uint32(len(n.rebalancer.pending))
+	if n.rebalancer.running {
+		count++
+	}
+	if n.rebalancer.decommissioning {
+		count++
+	}
+	return count
+}
+
+// Get the health of the cluster as seen by this node
+func (n *Node) localHealth() *pb.ClusterHealth {
+	alive, suspect, dead, left := n.countMembers()
+	health := &pb.ClusterHealth{
+		RingSize:              uint32(len(n.consistent.getRingNodes())),
+		AliveCount:            alive,
+		SuspectCount:          suspect,
+		DeadCount:             dead,
+		LeftCount:             left,
+		UnderReplicatedRanges: n.underReplicatedRanges(),
+		PendingHandoffs:       n.pendingHandoffs(),
+		Epoch:                 n.ringEpoch(),
+		MembershipEvents:      n.getMembershipEventCounts(),
+		ClusterSize:           n.config.ClusterSize,
+		Mode:                  n.clusterMode(),
+	}
+	return health
+}
+
+// Handle a CLUSTER_HEALTH. Unless only the local view is asked for, every
+// other node of the ring is asked for its own view, to report the epoch
+// each node is on and the handoffs pending in the whole cluster. Nodes
+// that don't answer are left out of the epochs.
+//
+// Arguments:
+//		scope: HEALTH_LOCAL for the view of this node only
+// Returns:
+//		The health of the cluster
+func (n *Node) handleClusterHealth(scope string) *pb.ClusterHealth {
+	health := n.localHealth()
+	if scope == HEALTH_LOCAL {
+		return health
+	}
+
+	self := n.localIP + ":" + n.localPort
+	health.NodeEpochs = map[string]uint64{self: health.Epoch}
+
+	var wg sync.WaitGroup
+	var healthMutex sync.Mutex
+	for _, node := range n.consistent.getRingNodes() {
+		if sameNode(node, n.localNode()) {
+			continue
+		}
+		wg.Add(1)
+		go func(node NodeVal) {
+			defer wg.Done()
+			reqPay := &pb.KVRequest{
+				Command: CLUSTER_HEALTH,
+				Value:   []byte(HEALTH_LOCAL),
+			}
+			respPay, err := n.sendRequestAndWait(node, reqPay, healthTimeout, healthAttempts)
+			if err != nil || respPay.Health == nil {
+				return
+			}
+
+			healthMutex.Lock()
+			health.NodeEpochs[node.ipAdr+":"+node.port] = respPay.Health.Epoch
+			health.PendingHandoffs += respPay.Health.PendingHandoffs
+			healthMutex.Unlock()
+		}(node)
+	}
+	wg.Wait()
+	return health
+}
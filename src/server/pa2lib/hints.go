@@ -0,0 +1,186 @@
+package pa2lib
+
+import (
+	"log"
+	pb "pa2/pb/protobuf"
+	"time"
+)
+
+// Time between two attempts to replay the hints
+const hintReplayInterval = 1 * time.Second
+
+// Largest number of keys with a hint, a write taken in with a sloppy
+// quorum when there are already that many is only kept as a replica
+const maxHints = 10000
+
+// Time a hint is kept for without reaching the owner, and the owner
+// remembers the time of its writes
+const maxHintAge = 10 * time.Minute
+
+// A write this node took in with a sloppy quorum for a key it doesn't
+// own, to replay to the owner once it answers again
+type hint struct {
+	id      uint64
+	command uint32
+	value   []byte
+	version int32
+	ttlMs   uint64
+	flags   uint32
+	// When this node took the write in, in Unix nanoseconds
+	time int64
+}
+
+// Remember a write to replay to the owner of its key. A later write of
+// the same key replaces it.
+//
+// Arguments:
+//		key: key written
+//		command: PUT or REMOVE
+//		value, version, ttlMs, flags: same as the request, for a PUT
+func (n *Node) addHint(key []byte, command uint32, value []byte, version int32, ttlMs uint64, flags uint32) {
+	n.hintsMutex.Lock()
+	defer n.hintsMutex.Unlock()
+	if _, exists := n.hints[string(key)]; !exists && len(n.hints) >= maxHints {
+		log.Println("Too many hints, the write of key", string(key), "is only kept as a replica")
+		return
+	}
+	n.nextHintID++
+	n.hints[string(key)] = hint{
+		id:      n.nextHintID,
+		command: command,
+		value:   value,
+		version: version,
+		ttlMs:   ttlMs,
+		flags:   flags,
+		time:    time.Now().UnixNano(),
+	}
+}
+
+// Forget a hint once it was replayed, unless a later write replaced it
+func (n *Node) removeHint(key string, id uint64) {
+	n.hintsMutex.Lock()
+	if h, exists := n.hints[key]; exists && h.id == id {
+		delete(n.hints, key)
+	}
+	n.hintsMutex.Unlock()
+}
+
+// Send every hint to the current owner of its key. A hint whose key is
+// now owned by this node is dropped, since the replica it was written
+// to became the copy of this node, and so is a hint older than
+// maxHintAge. The hints of an owner that doesn't answer are kept for
+// the next round.
+func (n *Node) replayHints() {
+	n.hintsMutex.Lock()
+	hints := make(map[string]hint, len(n.hints))
+	for key, h := range n.hints {
+		hints[key] = h
+	}
+	n.hintsMutex.Unlock()
+
+	oldest := time.Now().Add(-maxHintAge).UnixNano()
+	unreachable := make(map[string]bool)
+	for key, h := range hints {
+		if h.time < oldest {
+			log.Println("Dropping the hint of key", key, "which the owner never took")
+			n.removeHint(key, h.id)
+			continue
+		}
+		owner, owned := n.checkNode([]byte(key))
+		addr := owner.ipAdr + ":" + owner.port
+		if owned {
+			n.removeHint(key, h.id)
+			continue
+		}
+		if unreachable[addr] {
+			continue
+		}
+
+		reqPay := &pb.KVRequest{
+			Command:   HINT_PUT,
+			Key:       []byte(key),
+			Value:     h.value,
+			Version:   h.version,
+			TtlMs:     h.ttlMs,
+			Flags:     h.flags,
+			WriteTime: h.time,
+		}
+		if h.command == REMOVE {
+			reqPay.Command = HINT_REMOVE
+		}
+		respPay, err := n.sendRequestAndWait(owner, reqPay, migrateTimeout, 1)
+		if err != nil {
+			unreachable[addr] = true
+			continue
+		}
+		switch respPay.ErrCode {
+		case NO_ERR, KEY_DNE_ERR, CONDITION_FAILED_ERR:
+			n.removeHint(key, h.id)
+		}
+	}
+}
+
+// Record the time of a write of a key this node owns
+func (n *Node) noteWrite(key []byte, writeTime int64) {
+	n.mutex.Lock()
+	n.writeTimes[string(key)] = writeTime
+	n.mutex.Unlock()
+}
+
+// Forget the write times too old for a hint to be replayed over them
+func (n *Node) pruneWriteTimes() {
+	oldest := time.Now().Add(-maxHintAge).UnixNano()
+	n.mutex.Lock()
+	for key, writeTime := range n.writeTimes {
+		if writeTime < oldest {
+			delete(n.writeTimes, key)
+		}
+	}
+	n.mutex.Unlock()
+}
+
+// Apply a hint replayed by a node that took a write in for this node,
+// unless this node wrote the key since. The order is the one of the
+// clocks of the two nodes.
+//
+// Arguments:
+//		reqPay: HINT_PUT or HINT_REMOVE request, with the time the write
+//		was taken in
+// Returns:
+//		The error code of the write, CONDITION_FAILED_ERR if the key was
+//		written since, MOVED_ERR if this node doesn't own the key
+func (n *Node) applyHint(reqPay *pb.KVRequest) uint32 {
+	if _, owned := n.checkNode(reqPay.Key); !owned {
+		return MOVED_ERR
+	}
+	n.condMutex.Lock()
+	defer n.condMutex.Unlock()
+
+	n.mutex.Lock()
+	lastWrite, written := n.writeTimes[string(reqPay.Key)]
+	n.mutex.Unlock()
+	if written && lastWrite >= reqPay.WriteTime {
+		return CONDITION_FAILED_ERR
+	}
+
+	var errCode uint32
+	if reqPay.Command == HINT_REMOVE {
+		errCode = n.removeOwned(reqPay.Key)
+	} else {
+		errCode = n.putOwnedWithTTL(reqPay.Key, reqPay.Value, &reqPay.Version, reqPay.TtlMs, reqPay.Flags)
+	}
+	if errCode == NO_ERR || errCode == KEY_DNE_ERR {
+		// Later hints are ordered against the write, not its replay
+		n.noteWrite(reqPay.Key, reqPay.WriteTime)
+	}
+	return errCode
+}
+
+// Loops forever replaying the hints to the owners of their keys. Should
+// be called as a goroutine.
+func (n *Node) HintLoop() {
+	for n.sleep(hintReplayInterval) {
+		n.replayHints()
+		n.pruneWriteTimes()
+	}
+}
@@ -0,0 +1,130 @@
+package pa2lib
+
+import (
+	"fmt"
+	pb "pa2/pb/protobuf"
+	"testing"
+	"time"
+)
+
+// Create a node that isn't started, with itself and the given nodes in
+// its hash ring
+func newTestNode(others ...string) *Node {
+	config := DefaultConfig()
+	config.AdvertiseIP = "127.0.0.1"
+	n := NewNode(1, nil, config)
+	n.addLocalNode()
+	nodeList := n.getNodeList()
+	for _, addr := range others {
+		node := n.nodeFromAddr(addr)
+		node.membership = MEMBERSHIP_MEMBER
+		nodeList[addr] = &node
+	}
+	n.consistent.generateHashRing(nodeList)
+	return n
+}
+
+// Find a key owned, or not owned, by a node
+func testKey(n *Node, owned bool) []byte {
+	for i := 0; ; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if _, isMine := n.checkNode(key); isMine == owned {
+			return key
+		}
+	}
+}
+
+func TestApplyHint(t *testing.T) {
+	tests := []struct {
+		name string
+		// Whether the owner writes the key before or after the hinted
+		// write was taken in, nothing if empty
+		ownerWrite string
+		command    uint32
+		wantErr    uint32
+		wantValue  string
+	}{
+		{"put of a key not written", "", HINT_PUT, NO_ERR, "hinted"},
+		{"put older than the owner write", "after", HINT_PUT, CONDITION_FAILED_ERR, "owner"},
+		{"put newer than the owner write", "before", HINT_PUT, NO_ERR, "hinted"},
+		{"remove older than the owner write", "after", HINT_REMOVE, CONDITION_FAILED_ERR, "owner"},
+		{"remove newer than the owner write", "before", HINT_REMOVE, NO_ERR, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNode()
+			key := testKey(n, true)
+			version := int32(1)
+
+			// The sleeps keep the times apart on a coarse clock
+			if tt.ownerWrite == "before" {
+				n.putOwned(key, []byte("owner"), &version)
+				time.Sleep(time.Millisecond)
+			}
+			hintTime := time.Now().UnixNano()
+			if tt.ownerWrite == "after" {
+				time.Sleep(time.Millisecond)
+				n.putOwned(key, []byte("owner"), &version)
+			}
+
+			reqPay := &pb.KVRequest{Command: tt.command, Key: key, Value: []byte("hinted"), WriteTime: hintTime}
+			if errCode := n.applyHint(reqPay); errCode != tt.wantErr {
+				t.Fatalf("got error code %#x, want %#x", errCode, tt.wantErr)
+			}
+			value, _, errCode := n.Get(key)
+			if tt.wantValue == "" {
+				if errCode != KEY_DNE_ERR {
+					t.Fatalf("got %q, want the key removed", value)
+				}
+			} else if string(value) != tt.wantValue {
+				t.Fatalf("got %q, want %q", value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestApplyHintInOrder(t *testing.T) {
+	n := newTestNode()
+	key := testKey(n, true)
+	first := time.Now().UnixNano()
+	second := first + 1
+
+	// Hints from two replicas replayed out of order: the later write wins
+	reqPay := &pb.KVRequest{Command: HINT_PUT, Key: key, Value: []byte("second"), WriteTime: second}
+	if errCode := n.applyHint(reqPay); errCode != NO_ERR {
+		t.Fatalf("got error code %#x replaying the second write", errCode)
+	}
+	reqPay = &pb.KVRequest{Command: HINT_PUT, Key: key, Value: []byte("first"), WriteTime: first}
+	if errCode := n.applyHint(reqPay); errCode != CONDITION_FAILED_ERR {
+		t.Fatalf("got error code %#x replaying the first write, want %#x", errCode, CONDITION_FAILED_ERR)
+	}
+	if value, _, _ := n.Get(key); string(value) != "second" {
+		t.Fatalf("got %q, want %q", value, "second")
+	}
+}
+
+func TestApplyHintNotOwned(t *testing.T) {
+	n := newTestNode("127.0.0.1:2")
+	key := testKey(n, false)
+	reqPay := &pb.KVRequest{Command: HINT_PUT, Key: key, Value: []byte("hinted"), WriteTime: time.Now().UnixNano()}
+	if errCode := n.applyHint(reqPay); errCode != MOVED_ERR {
+		t.Fatalf("got error code %#x, want %#x", errCode, MOVED_ERR)
+	}
+}
+
+func TestGetFallback(t *testing.T) {
+	n := newTestNode("127.0.0.1:2")
+	key := testKey(n, false)
+
+	// A replica without the key can't tell whether the owner has it
+	if _, _, errCode := n.getFallback(key); errCode != UNREACHABLE_ERR {
+		t.Fatalf("got error code %#x for a key missing on the replica, want %#x", errCode, UNREACHABLE_ERR)
+	}
+
+	version := int32(3)
+	n.PutReplicate(key, []byte("replica"), &version, n.replicaFlagOf(key))
+	value, gotVersion, errCode := n.getFallback(key)
+	if errCode != NO_ERR || string(value) != "replica" || gotVersion != version {
+		t.Fatalf("got %q version %d error code %#x, want %q version %d", value, gotVersion, errCode, "replica", version)
+	}
+}
@@ -0,0 +1,303 @@
+package pa2lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	pb "pa2/pb/protobuf"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Largest body accepted by PUT /v1/keys/{key}, a value and its base64
+// encoding with some room for the JSON around it
+const maxHTTPBodyBytes = 2*maxValLengthBytes + 1024
+
+// A key-value pair in JSON, the value encoded in base64
+type httpEntry struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value"`
+	Version int32  `json:"version"`
+}
+
+// Body of a PUT with a JSON content type, the value encoded in base64
+type httpPutBody struct {
+	Value   []byte `json:"value"`
+	Version int32  `json:"version"`
+}
+
+// A node of the membership list in JSON
+type httpMember struct {
+	Addr        string            `json:"addr"`
+	Membership  string            `json:"membership"`
+	Incarnation uint64            `json:"incarnation"`
+	Suspicion   float64           `json:"suspicion"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// A node of the hash ring in JSON
+type httpRingNode struct {
+	Addr string `json:"addr"`
+	Hash uint32 `json:"hash"`
+}
+
+// Body of an error response
+type httpError struct {
+	Error       string `json:"error"`
+	ErrCode     uint32 `json:"errCode"`
+	ClusterMode string `json:"clusterMode,omitempty"`
+}
+
+// Get the HTTP status of an error code
+func httpStatusOf(errCode uint32) int {
+	switch errCode {
+	case NO_ERR:
+		return http.StatusOK
+	case KEY_DNE_ERR:
+		return http.StatusNotFound
+	case NO_SPC_ERR:
+		return http.StatusInsufficientStorage
+	case SYS_OVERLOAD_ERR, NO_QUORUM_ERR, UNREACHABLE_ERR, TOO_MANY_HOPS_ERR:
+		return http.StatusServiceUnavailable
+	case UNKNOWN_CMD_ERR:
+		return http.StatusNotImplemented
+	case INVALID_KEY_ERR, INVALID_VAL_ERR:
+		return http.StatusBadRequest
+	case CONDITION_FAILED_ERR:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, httpError{Error: err.Error()})
+}
+
+// Make a request for an HTTP call, and write the error response if it
+// failed
+//
+// Returns:
+//		The response payload, nil if an error response was written
+func (n *Node) callFromHTTP(w http.ResponseWriter, r *http.Request, reqPay *pb.KVRequest) *pb.KVResponse {
+	clientAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	if addr, err := net.ResolveUDPAddr("udp", r.RemoteAddr); err == nil {
+		clientAddr = addr
+	}
+
+	respPay, err := n.callLocal(r.Context(), clientAddr, reqPay)
+	if err != nil {
+		writeHTTPError(w, http.StatusGatewayTimeout, err)
+		return nil
+	}
+	if respPay.ErrCode != NO_ERR {
+		if respPay.ErrCode == SYS_OVERLOAD_ERR && respPay.OverloadWaitTime > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(respPay.OverloadWaitTime+999)/1000))
+		}
+		writeJSON(w, httpStatusOf(respPay.ErrCode), httpError{
+			Error:       errCodeMessage(respPay.ErrCode),
+			ErrCode:     respPay.ErrCode,
+			ClusterMode: respPay.ClusterMode,
+		})
+		return nil
+	}
+	return respPay
+}
+
+// Handle GET, PUT and DELETE /v1/keys/{key}. The key is the rest of the
+// path, percent-decoded. Values are sent as JSON with the value in
+// base64, or as the raw body with ?raw (GET) or a non-JSON content type
+// (PUT).
+func (n *Node) handleHTTPKey(w http.ResponseWriter, r *http.Request) {
+	key := []byte(strings.TrimPrefix(r.URL.Path, "/v1/keys/"))
+	if len(key) == 0 {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("missing key"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		respPay := n.callFromHTTP(w, r, &pb.KVRequest{Command: GET, Key: key})
+		if respPay == nil {
+			return
+		}
+		if _, raw := r.URL.Query()["raw"]; raw {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("X-Version", strconv.Itoa(int(respPay.Version)))
+			_, _ = w.Write(respPay.Value)
+			return
+		}
+		writeJSON(w, http.StatusOK, httpEntry{Key: string(key), Value: respPay.Value, Version: respPay.Version})
+
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPBodyBytes))
+		if err != nil {
+			writeHTTPError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		reqPay := &pb.KVRequest{Command: PUT, Key: key, Value: body}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			var put httpPutBody
+			if err := json.Unmarshal(body, &put); err != nil {
+				writeHTTPError(w, http.StatusBadRequest, err)
+				return
+			}
+			reqPay.Value, reqPay.Version = put.Value, put.Version
+		} else if version := r.URL.Query().Get("version"); version != "" {
+			v, err := strconv.ParseInt(version, 10, 32)
+			if err != nil {
+				writeHTTPError(w, http.StatusBadRequest, err)
+				return
+			}
+			reqPay.Version = int32(v)
+		}
+		if n.callFromHTTP(w, r, reqPay) != nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case http.MethodDelete:
+		if n.callFromHTTP(w, r, &pb.KVRequest{Command: REMOVE, Key: key}) != nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// Handle GET /v1/keys: the keys of the whole cluster in byte order,
+// filtered by ?prefix, after ?start_after and at most ?limit of them
+func (n *Node) handleHTTPScan(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var limit uint32
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = parseUint32(value); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if _, fenced := n.fencedCommand(GET); fenced {
+		writeJSON(w, http.StatusServiceUnavailable, httpError{Error: errCodeMessage(NO_QUORUM_ERR), ErrCode: NO_QUORUM_ERR, ClusterMode: n.clusterMode()})
+		return
+	}
+
+	entries, err := n.scanCluster([]byte(query.Get("start_after")), []byte(query.Get("prefix")), limit)
+	if err != nil {
+		writeHTTPError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	result := []httpEntry{}
+	for _, entry := range entries {
+		result = append(result, httpEntry{Key: string(entry.Key), Value: entry.Value, Version: entry.Version})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Get the name of a membership state, "alive" for a member
+func membershipName(membership string) string {
+	if membership == MEMBERSHIP_MEMBER {
+		return "alive"
+	}
+	return membership
+}
+
+// Handle GET /v1/members: the membership list, filtered by the tags of
+// ?filter
+func (n *Node) handleHTTPMembers(w http.ResponseWriter, r *http.Request) {
+	respPay := n.callFromHTTP(w, r, &pb.KVRequest{Command: MEMBERSHIP_QUERY, Value: []byte(r.URL.Query().Get("filter"))})
+	if respPay == nil {
+		return
+	}
+	members := []httpMember{}
+	for addr, entry := range respPay.NodeList {
+		node := &pb.NodeVal{}
+		if err := proto.Unmarshal(entry, node); err != nil {
+			continue
+		}
+		members = append(members, httpMember{
+			Addr:        addr,
+			Membership:  membershipName(node.Membership),
+			Incarnation: node.Incarnation,
+			Suspicion:   node.Suspicion,
+			Tags:        node.Tags,
+		})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+	writeJSON(w, http.StatusOK, members)
+}
+
+// Handle GET /v1/ring: the nodes of the hash ring in ring order
+func (n *Node) handleHTTPRing(w http.ResponseWriter, r *http.Request) {
+	hashes, nodes := n.consistent.getRing()
+	ring := []httpRingNode{}
+	for i, node := range nodes {
+		ring = append(ring, httpRingNode{Addr: node.ipAdr + ":" + node.port, Hash: hashes[i]})
+	}
+	writeJSON(w, http.StatusOK, ring)
+}
+
+// Handle GET /v1/health: the CLUSTER_HEALTH report, of this node only
+// with ?local
+func (n *Node) handleHTTPHealth(w http.ResponseWriter, r *http.Request) {
+	reqPay := &pb.KVRequest{Command: CLUSTER_HEALTH}
+	if _, local := r.URL.Query()["local"]; local {
+		reqPay.Value = []byte(HEALTH_LOCAL)
+	}
+	respPay := n.callFromHTTP(w, r, reqPay)
+	if respPay == nil {
+		return
+	}
+	body, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(proto.MessageV2(respPay.Health))
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// Wrap a handler to only accept GET
+func getOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Starts the HTTP/JSON gateway
+//
+// Arguments:
+//		port: port number to listen on
+func (n *Node) HTTPHandler(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys/", n.handleHTTPKey)
+	mux.HandleFunc("/v1/keys", getOnly(n.handleHTTPScan))
+	mux.HandleFunc("/v1/members", getOnly(n.handleHTTPMembers))
+	mux.HandleFunc("/v1/ring", getOnly(n.handleHTTPRing))
+	mux.HandleFunc("/v1/health", getOnly(n.handleHTTPHealth))
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+	n.track(server)
+	log.Println("HTTP gateway listening on port", port)
+	if err := server.ListenAndServe(); err != nil {
+		log.Println("HTTP gateway stopped:", err)
+	}
+}
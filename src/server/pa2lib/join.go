@@ -0,0 +1,115 @@
+package pa2lib
+
+import (
+	"log"
+	pb "pa2/pb/protobuf"
+	"time"
+)
+
+// Time to wait for a seed to answer a JOIN
+const joinTimeout = 200 * time.Millisecond
+const joinAttempts = 3
+
+// Time to wait for the answers of a gossip round
+const gossipTimeout = 100 * time.Millisecond
+const gossipAttempts = 2
+
+// Get the addresses to contact to join the cluster
+func (n *Node) getSeeds() []string {
+	if len(n.config.Seeds) > 0 {
+		return n.config.Seeds
+	}
+
+	var seeds []string
+	for addr := range n.startNodeList {
+		seeds = append(seeds, addr)
+	}
+	return seeds
+}
+
+// Announce this node to the seeds until one of them answers with the
+// current membership, which is then merged into our node list. If no
+// seed answers, this node keeps running as the first node of the
+// cluster and others join it later.
+func (n *Node) joinCluster() {
+	self := n.localIP + ":" + n.localPort
+	for _, seed := range n.getSeeds() {
+		if seed == self {
+			continue
+		}
+
+		reqPay := &pb.KVRequest{
+			Command:     JOIN,
+			Addr:        []byte(self),
+			Incarnation: n.localNode().incarnation,
+			NodeList:    map[string][]byte{self: n.membershipEntry(self, n.localNode())},
+		}
+		respPay, err := n.sendRequestAndWait(n.nodeFromAddr(seed), reqPay, joinTimeout, joinAttempts)
+		if err != nil || respPay.ErrCode != NO_ERR {
+			log.Println("Failed to join through seed", seed, err)
+			continue
+		}
+
+		log.Println("Joined the cluster through seed", seed)
+		n.loadMembership(nodeListParseFromByteArray(respPay.NodeList))
+		return
+	}
+	log.Println("No seed answered, starting a new cluster")
+}
+
+// Fill the node list and hash ring with the membership received from a
+// seed. Unlike a gossip merge this triggers no replication, since this
+// node doesn't own any keys yet.
+func (n *Node) loadMembership(newNodeList map[string]NodeVal) {
+	for _, node := range newNodeList {
+		n.applyNodeUpdate(node, false)
+	}
+}
+
+// Handle a JOIN sent by a new node. The node is added to the node list
+// and hash ring, then gossip spreads it to everyone else.
+//
+// Arguments:
+//		reqPay: JOIN request with the address the new node listens on, its
+//		incarnation and its node list entry
+// Returns:
+//		The membership list to send back, and an error code
+func (n *Node) handleJoin(reqPay *pb.KVRequest) (map[string][]byte, uint32) {
+	addr := string(reqPay.Addr)
+	node := n.nodeFromAddr(addr)
+	if entry, ok := nodeListParseFromByteArray(reqPay.NodeList)[addr]; ok {
+		node.tags = entry.tags
+	}
+	node.time = uint64(time.Now().UnixNano())
+	node.incarnation = reqPay.Incarnation
+	n.markNodeAlive(node)
+
+	nodeList, _, _ := n.GetMemberShipList()
+	return nodeList, NO_ERR
+}
+
+// Check whether this node knows no other node that is on
+func (n *Node) alone() bool {
+	self := n.localIP + ":" + n.localPort
+	n.nodeListMutex.RLock()
+	defer n.nodeListMutex.RUnlock()
+	for addr, node := range n.nodeList {
+		if node.isOn && addr != self {
+			return false
+		}
+	}
+	return true
+}
+
+// Loops forever doing a gossip round every interval. Should be
+// called as a goroutine so it can run in the background
+func (n *Node) GossipLoop() {
+	for n.sleep(time.Duration(n.config.GossipIntervalMs) * time.Millisecond) {
+		// Gossip only reaches known nodes, so a node that found no seed
+		// keeps asking them, in case they started after it
+		if n.alone() {
+			n.joinCluster()
+		}
+		n.doGossip()
+	}
+}
@@ -2,7 +2,7 @@ package pa2lib
 
 import (
 	"bytes"
-	"sync"
+	"time"
 )
 
 // Constants defining the maximum allowable length in
@@ -17,13 +17,6 @@ type StoreVal struct {
 	version int32
 }
 
-// In-memory key-value store data structure
-var KVStore = []StoreVal{}
-var repKVStore = [2][]StoreVal{};
-
-// Mutex for locking the KVStore object
-var mutex = &sync.Mutex{}
-
 // Get the value and version for a particular key
 //
 // Arguments:
@@ -32,10 +25,10 @@ var mutex = &sync.Mutex{}
 //		Byte array containing the value if the key exists
 //		Version of the entry if the key exists
 //		NO_ERR if key exists, otherwise KEY_DNE_ERR
-func Get(key []byte) ([]byte, int32, uint32) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	for _, value := range KVStore {
+func (n *Node) Get(key []byte) ([]byte, int32, uint32) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, value := range n.KVStore {
 		if bytes.Equal(key, value.key) {
 			return value.value, value.version, NO_ERR
 		}
@@ -52,7 +45,46 @@ func Get(key []byte) ([]byte, int32, uint32) {
 //		version: pointer to the version of the pair
 // Returns:
 //		Error code 
-func Put(key []byte, value []byte, version *int32) (uint32) {
+func (n *Node) Put(key []byte, value []byte, version *int32) (uint32) {
+	if len(key) > maxKeyLengthBytes {
+		return INVALID_KEY_ERR
+	} else if len(value) > maxValLengthBytes {
+		return INVALID_VAL_ERR
+	} else if !IsAllocatePossible(len(key) + len(value) + 4) {
+		return NO_SPC_ERR
+	} else {
+		storeVal := StoreVal {key: key, value: value}
+		if version == nil {
+			storeVal.version = 0
+		} else {
+			storeVal.version = *version
+		}
+
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		for i, value := range n.KVStore {
+			if bytes.Equal(key, value.key) {
+				n.KVStore[i] = storeVal
+				return NO_ERR
+			}
+		}
+
+		n.KVStore = append(n.KVStore, storeVal)
+
+		return NO_ERR
+	}
+}
+
+// Put a key-value pair only if the key does not exist yet and was not
+// removed while it was migrating to this node
+//
+// Arguments:
+// 		key: key for the pair
+//		value: value for the pair
+//		version: pointer to the version of the pair
+// Returns:
+//		Error code, NO_ERR if the key already existed or was removed
+func (n *Node) PutIfAbsent(key []byte, value []byte, version *int32) (uint32) {
 	if len(key) > maxKeyLengthBytes {
 		return INVALID_KEY_ERR
 	} else if len(value) > maxValLengthBytes {
@@ -67,22 +99,24 @@ func Put(key []byte, value []byte, version *int32) (uint32) {
 			storeVal.version = *version
 		}
 
-		mutex.Lock()
-		defer mutex.Unlock()
-		for i, value := range KVStore {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		if n.tombstones[string(key)] {
+			return NO_ERR
+		}
+		for _, value := range n.KVStore {
 			if bytes.Equal(key, value.key) {
-				KVStore[i] = storeVal
 				return NO_ERR
 			}
 		}
 
-		KVStore = append(KVStore, storeVal)
+		n.KVStore = append(n.KVStore, storeVal)
 
 		return NO_ERR
 	}
 }
 
-func PutReplicate(key []byte, value []byte, version *int32, flag int) (uint32) {
+func (n *Node) PutReplicate(key []byte, value []byte, version *int32, flag int) (uint32) {
 	if len(key) > maxKeyLengthBytes {
 		return INVALID_KEY_ERR
 	} else if len(value) > maxValLengthBytes {
@@ -97,34 +131,59 @@ func PutReplicate(key []byte, value []byte, version *int32, flag int) (uint32) {
 			storeVal.version = *version
 		}
 
-		mutex.Lock()
-		defer mutex.Unlock()
-		for i, value := range repKVStore[flag] {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		for i, value := range n.repKVStore[flag] {
 			if bytes.Equal(key, value.key) {
-				repKVStore[flag][i] = storeVal
+				n.repKVStore[flag][i] = storeVal
 				return NO_ERR
 			}
 		}
 
-		repKVStore[flag] = append(repKVStore[flag], storeVal)
+		n.repKVStore[flag] = append(n.repKVStore[flag], storeVal)
 
 		return NO_ERR
 	}
 }
 
-// Remove a key-value pair 
+// Set the flags of a key, 0 removes them
+func (n *Node) setFlags(key []byte, flags uint32) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if flags == 0 {
+		delete(n.flags, string(key))
+	} else {
+		n.flags[string(key)] = flags
+	}
+}
+
+// Get the flags of a key, 0 if it has none
+func (n *Node) getFlags(key []byte) uint32 {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.flags[string(key)]
+}
+
+// Remove a key-value pair, its time to live and its flags
 //
 // Arguments:
 // 		key: key to remove
 // Returns:
 //		NO_ERR if key exists, otherwise KEY_DNE_ERR
-func Remove(key []byte) (uint32) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	for i, value := range KVStore {
+func (n *Node) Remove(key []byte) (uint32) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.clearExpiry(key)
+	delete(n.flags, string(key))
+	return n.removeLocked(key)
+}
+
+// Remove a key-value pair, with the mutex already held
+func (n *Node) removeLocked(key []byte) (uint32) {
+	for i, value := range n.KVStore {
 		if bytes.Equal(key, value.key) {
-			KVStore[i] = KVStore[len(KVStore) - 1]
-			KVStore = KVStore[:len(KVStore) -1]
+			n.KVStore[i] = n.KVStore[len(n.KVStore) - 1]
+			n.KVStore = n.KVStore[:len(n.KVStore) -1]
 			return NO_ERR
 		}
 	}
@@ -132,13 +191,62 @@ func Remove(key []byte) (uint32) {
 	return KEY_DNE_ERR
 }
 
-func RemoveReplicate(key []byte, flag int) (uint32) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	for i, value := range repKVStore[flag] {
+// Move a key-value pair from the KVStore to a replica store,
+// keeping whatever value it has at that moment
+//
+// Arguments:
+// 		key: key to move
+//		flag: index of the replica store
+// Returns:
+//		True if the key existed
+func (n *Node) moveToReplica(key []byte, flag int) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for i, value := range n.KVStore {
+		if bytes.Equal(key, value.key) {
+			n.KVStore[i] = n.KVStore[len(n.KVStore) - 1]
+			n.KVStore = n.KVStore[:len(n.KVStore) -1]
+
+			for j, repValue := range n.repKVStore[flag] {
+				if bytes.Equal(key, repValue.key) {
+					n.repKVStore[flag][j] = value
+					return true
+				}
+			}
+			n.repKVStore[flag] = append(n.repKVStore[flag], value)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the value and version of a key in a replica store
+//
+// Arguments:
+//		key: key to get the value and version for
+//		flag: index of the replica store
+// Returns:
+//		Same as Get
+func (n *Node) GetReplicate(key []byte, flag int) ([]byte, int32, uint32) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, value := range n.repKVStore[flag] {
+		if bytes.Equal(key, value.key) {
+			return value.value, value.version, NO_ERR
+		}
+	}
+
+	return nil, 0, KEY_DNE_ERR
+}
+
+func (n *Node) RemoveReplicate(key []byte, flag int) (uint32) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for i, value := range n.repKVStore[flag] {
 		if bytes.Equal(key, value.key) {
-			repKVStore[flag][i] = repKVStore[flag][len(repKVStore[flag]) - 1]
-			repKVStore[flag] = repKVStore[flag][:len(repKVStore[flag]) -1]
+			n.repKVStore[flag][i] = n.repKVStore[flag][len(n.repKVStore[flag]) - 1]
+			n.repKVStore[flag] = n.repKVStore[flag][:len(n.repKVStore[flag]) -1]
 			return NO_ERR
 		}
 	}
@@ -146,20 +254,26 @@ func RemoveReplicate(key []byte, flag int) (uint32) {
 	return KEY_DNE_ERR
 }
 
-func WipeoutReplicate(flag int){
-	mutex.Lock()
-	defer mutex.Unlock()
-	repKVStore[flag] = []StoreVal{}
+func (n *Node) WipeoutReplicate(flag int){
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.repKVStore[flag] = []StoreVal{}
 }
 
-// Removes all the key-value pairs in the system
+// Removes all the key-value pairs in the system, their times to live,
+// flags and write times
 //
 // Returns:
 //		NO_ERR
-func RemoveAll() (uint32) {
-	mutex.Lock()
-	KVStore = []StoreVal{}
-	mutex.Unlock()
+func (n *Node) RemoveAll() (uint32) {
+	n.mutex.Lock()
+	n.KVStore = []StoreVal{}
+	n.flags = make(map[string]uint32)
+	n.writeTimes = make(map[string]int64)
+	n.expiriesMutex.Lock()
+	n.expiries = make(map[string]time.Time)
+	n.expiriesMutex.Unlock()
+	n.mutex.Unlock()
 
 	return NO_ERR
 }
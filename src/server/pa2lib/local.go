@@ -0,0 +1,98 @@
+package pa2lib
+
+import (
+	"context"
+	"errors"
+	"net"
+	pb "pa2/pb/protobuf"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Time to wait for the response to a request made inside the node when
+// the caller sets no deadline
+const localTimeout = 5 * time.Second
+
+// Returned when a request made inside the node got no response in time
+var errNoLocalResponse = errors.New("no response from the cluster")
+
+// Identifier of the last local stream created, updated atomically
+var lastLocalStreamID uint64
+
+// A stream collecting the responses to the requests the gateways (gRPC,
+// HTTP...) make inside the node. Its ID tells apart the calls made on
+// the same connection.
+type localStream struct {
+	id        uint64
+	responses chan []byte
+}
+
+func newLocalStream(size int) *localStream {
+	return &localStream{id: atomic.AddUint64(&lastLocalStreamID, 1), responses: make(chan []byte, size)}
+}
+
+// Queue a response, dropping it if the caller fell behind
+func (s *localStream) write(msg []byte) error {
+	select {
+	case s.responses <- msg:
+		return nil
+	default:
+		return errors.New("caller not reading its responses")
+	}
+}
+
+// Wait for the next response of the stream
+func (s *localStream) next(ctx context.Context) (*pb.KVResponse, error) {
+	timer := time.NewTimer(localTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-s.responses:
+		return unmarshalKVResponse(msg)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, errNoLocalResponse
+	}
+}
+
+// Get the payload of a response message
+func unmarshalKVResponse(msg []byte) (*pb.KVResponse, error) {
+	msgID, respPayBytes, _ := unmarshalMsg(msg)
+	if msgID == nil {
+		return nil, errors.New("corrupted response")
+	}
+	respPay := &pb.KVResponse{}
+	if err := proto.Unmarshal(respPayBytes, respPay); err != nil {
+		return nil, err
+	}
+	return respPay, nil
+}
+
+// Start handling a request made inside the node like any other request,
+// its responses going to the given stream
+//
+// Arguments:
+//		clientAddr: address of the client the request is made for
+//		stream: stream the responses are written to
+//		reqPay: request payload
+func (n *Node) startLocalRequest(clientAddr *net.UDPAddr, stream *localStream, reqPay *pb.KVRequest) {
+	msgID := generateLocalMsgID(clientAddr.IP.To4(), clientAddr.Port)
+	go n.handleKVRequest(clientAddr, stream, msgID, reqPay)
+}
+
+// Make a request inside the node and wait for its response. Keys owned
+// by other nodes are forwarded to them like for any client.
+//
+// Arguments:
+//		ctx: context of the caller
+//		clientAddr: address of the client the request is made for
+//		reqPay: request payload
+// Returns:
+//		The response payload, or an error if it didn't come
+func (n *Node) callLocal(ctx context.Context, clientAddr *net.UDPAddr, reqPay *pb.KVRequest) (*pb.KVResponse, error) {
+	stream := newLocalStream(1)
+	n.startLocalRequest(clientAddr, stream, reqPay)
+	return stream.next(ctx)
+}
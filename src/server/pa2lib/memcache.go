@@ -0,0 +1,432 @@
+package pa2lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	pb "pa2/pb/protobuf"
+	"strconv"
+	"time"
+)
+
+// Largest data block accepted by a storage command. Larger blocks close
+// the connection, smaller ones still fail if over maxValLengthBytes.
+const maxMemcacheBytes = 1 << 20
+
+// Longest command line accepted
+const maxMemcacheLine = 2048
+
+// Expiration times above this many seconds are unix timestamps, like in
+// memcached
+const memcacheRelativeMax = 30 * 24 * 60 * 60
+
+// Version reported by the version command
+const memcacheVersion = "1.6.0"
+
+// Get the reply to an error code of a memcached command
+func memcacheErrorOf(respPay *pb.KVResponse) string {
+	switch respPay.ErrCode {
+	case INVALID_KEY_ERR:
+		return fmt.Sprintf("CLIENT_ERROR invalid key, keys are at most %d bytes", maxKeyLengthBytes)
+	case INVALID_VAL_ERR:
+		return "SERVER_ERROR object too large for cache"
+	case NO_SPC_ERR:
+		return "SERVER_ERROR out of memory storing object"
+	case SYS_OVERLOAD_ERR:
+		return fmt.Sprintf("SERVER_ERROR %s, retry in %d ms", errCodeMessage(SYS_OVERLOAD_ERR), respPay.OverloadWaitTime)
+	case NO_QUORUM_ERR:
+		return fmt.Sprintf("SERVER_ERROR %s, node is in %s mode", errCodeMessage(NO_QUORUM_ERR), respPay.ClusterMode)
+	}
+	return "SERVER_ERROR " + errCodeMessage(respPay.ErrCode)
+}
+
+// Get the time to live of a memcached expiration time: seconds from now,
+// or a unix timestamp past 30 days
+//
+// Returns:
+//		The time to live in milliseconds, 0 for none, and true if the
+//		expiration time is already past
+func memcacheTTL(exptime int64) (uint64, bool) {
+	if exptime == 0 {
+		return 0, false
+	}
+	if exptime > memcacheRelativeMax {
+		exptime -= time.Now().Unix()
+	}
+	if exptime <= 0 {
+		return 0, true
+	}
+	return uint64(exptime) * 1000, false
+}
+
+// A connection of a memcached client
+type memcacheClient struct {
+	node *Node
+	addr *net.UDPAddr
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (c *memcacheClient) reply(line string) {
+	c.w.WriteString(line)
+	c.w.WriteString("\r\n")
+}
+
+// Make a request for the client
+//
+// Arguments:
+//		reqPay: request payload
+//		noreply: true to not write the error reply
+//		allowed: error codes returned to the caller instead of replied
+// Returns:
+//		The response payload, nil if the request failed
+func (c *memcacheClient) call(reqPay *pb.KVRequest, noreply bool, allowed ...uint32) *pb.KVResponse {
+	respPay, err := c.node.callLocal(context.Background(), c.addr, reqPay)
+	if err != nil {
+		if !noreply {
+			c.reply("SERVER_ERROR " + err.Error())
+		}
+		return nil
+	}
+	if respPay.ErrCode == NO_ERR {
+		return respPay
+	}
+	for _, errCode := range allowed {
+		if respPay.ErrCode == errCode {
+			return respPay
+		}
+	}
+	if !noreply {
+		c.reply(memcacheErrorOf(respPay))
+	}
+	return nil
+}
+
+// Check for the noreply argument ending a command
+//
+// Arguments:
+//		args: arguments of the command
+//		n: number of arguments before noreply
+// Returns:
+//		Whether noreply was given, false if the arguments are malformed
+func parseNoreply(args [][]byte, n int) (bool, bool) {
+	if len(args) == n {
+		return false, true
+	}
+	if len(args) == n+1 && string(args[n]) == "noreply" {
+		return true, true
+	}
+	return false, false
+}
+
+// Read the data block of a storage command
+//
+// Returns:
+//		The data, nil if the block was malformed, and an error if the
+//		connection must be closed
+func (c *memcacheClient) readData(size int64) ([]byte, error) {
+	if size < 0 || size > maxMemcacheBytes {
+		return nil, fmt.Errorf("data block of %d bytes", size)
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		return nil, nil
+	}
+	return data[:size], nil
+}
+
+// Handle set, add, replace and cas:
+// <cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+//
+// The flags are kept with the value on the node owning the key, like its
+// time to live, and are lost if the key moves to another node.
+func (c *memcacheClient) store(name string, args [][]byte) error {
+	n := 4
+	if name == "cas" {
+		n = 5
+	}
+	noreply, ok := parseNoreply(args, n)
+	var exptime, size int64
+	var flags, cas uint64
+	var err error
+	if ok {
+		flags, err = strconv.ParseUint(string(args[1]), 10, 32)
+		ok = err == nil
+	}
+	if ok {
+		exptime, err = strconv.ParseInt(string(args[2]), 10, 64)
+		ok = err == nil
+	}
+	if ok {
+		size, err = strconv.ParseInt(string(args[3]), 10, 64)
+		ok = err == nil
+	}
+	if ok && name == "cas" {
+		cas, err = strconv.ParseUint(string(args[4]), 10, 64)
+		ok = err == nil && cas <= math.MaxUint32
+	}
+	if !ok {
+		c.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	data, err := c.readData(size)
+	if err != nil {
+		c.reply("SERVER_ERROR object too large for cache")
+		return err
+	}
+	if data == nil {
+		c.reply("CLIENT_ERROR bad data chunk")
+		return nil
+	}
+
+	ttlMs, expired := memcacheTTL(exptime)
+	reqPay := &pb.KVRequest{Command: PUT_IF, Key: args[0], Value: data, TtlMs: ttlMs, Flags: uint32(flags)}
+	switch name {
+	case "add":
+		reqPay.Condition = COND_ABSENT
+	case "replace":
+		reqPay.Condition = COND_PRESENT
+	case "cas":
+		reqPay.Condition = COND_VERSION
+		reqPay.Version = int32(uint32(cas))
+	}
+	respPay := c.call(reqPay, noreply, KEY_DNE_ERR, CONDITION_FAILED_ERR)
+	if respPay != nil && respPay.ErrCode == NO_ERR && expired {
+		// Stored and expired at once, like memcached does
+		c.call(&pb.KVRequest{Command: REMOVE, Key: args[0]}, true)
+	}
+	if respPay == nil || noreply {
+		return nil
+	}
+	switch {
+	case respPay.ErrCode == NO_ERR:
+		c.reply("STORED")
+	case name == "cas" && respPay.ErrCode == KEY_DNE_ERR:
+		c.reply("NOT_FOUND")
+	case name == "cas":
+		c.reply("EXISTS")
+	default:
+		c.reply("NOT_STORED")
+	}
+	return nil
+}
+
+// Handle get and gets: a VALUE line per key found, then END. gets also
+// gives the version of each key as its cas unique.
+func (c *memcacheClient) get(name string, keys [][]byte) {
+	if len(keys) == 0 {
+		c.reply("ERROR")
+		return
+	}
+	for _, key := range keys {
+		respPay := c.call(&pb.KVRequest{Command: GET, Key: key}, false, KEY_DNE_ERR)
+		if respPay == nil {
+			// The error reply ends the response
+			return
+		}
+		if respPay.ErrCode == KEY_DNE_ERR {
+			continue
+		}
+		if name == "gets" {
+			c.reply(fmt.Sprintf("VALUE %s %d %d %d", key, respPay.Flags, len(respPay.Value), uint32(respPay.Version)))
+		} else {
+			c.reply(fmt.Sprintf("VALUE %s %d %d", key, respPay.Flags, len(respPay.Value)))
+		}
+		c.w.Write(respPay.Value)
+		c.reply("")
+	}
+	c.reply("END")
+}
+
+// Run a command line of a memcached client and write its reply
+//
+// Arguments:
+//		line: command line, without its CRLF
+// Returns:
+//		An error if the connection must be closed
+func (c *memcacheClient) handle(line []byte) error {
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		c.reply("ERROR")
+		return nil
+	}
+	name := string(fields[0])
+	args := fields[1:]
+
+	switch name {
+	case "get", "gets":
+		c.get(name, args)
+
+	case "set", "add", "replace", "cas":
+		if len(args) < 4 {
+			c.reply("ERROR")
+			return nil
+		}
+		return c.store(name, args)
+
+	case "delete":
+		// Memcached still accepts a time of 0 before noreply
+		if len(args) >= 2 && string(args[1]) == "0" {
+			args = append(args[:1:1], args[2:]...)
+		}
+		noreply, ok := parseNoreply(args, 1)
+		if !ok {
+			c.reply("CLIENT_ERROR bad command line format. Usage: delete <key> [noreply]")
+			return nil
+		}
+		respPay := c.call(&pb.KVRequest{Command: REMOVE, Key: args[0]}, noreply, KEY_DNE_ERR)
+		if respPay == nil || noreply {
+			return nil
+		}
+		if respPay.ErrCode == NO_ERR {
+			c.reply("DELETED")
+		} else {
+			c.reply("NOT_FOUND")
+		}
+
+	case "incr", "decr":
+		noreply, ok := parseNoreply(args, 2)
+		if !ok {
+			c.reply("ERROR")
+			return nil
+		}
+		delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || delta < 0 {
+			c.reply("CLIENT_ERROR invalid numeric delta argument")
+			return nil
+		}
+		if name == "decr" {
+			delta = -delta
+		}
+		reqPay := &pb.KVRequest{Command: INCR, Key: args[0], Delta: delta, Condition: COND_PRESENT, UnsignedCounter: true}
+		respPay := c.call(reqPay, noreply, KEY_DNE_ERR, INVALID_VAL_ERR)
+		if respPay == nil || noreply {
+			return nil
+		}
+		switch respPay.ErrCode {
+		case NO_ERR:
+			c.reply(string(respPay.Value))
+		case KEY_DNE_ERR:
+			c.reply("NOT_FOUND")
+		default:
+			c.reply("CLIENT_ERROR cannot increment or decrement non-numeric value")
+		}
+
+	case "touch":
+		noreply, ok := parseNoreply(args, 2)
+		var exptime int64
+		var err error
+		if ok {
+			exptime, err = strconv.ParseInt(string(args[1]), 10, 64)
+			ok = err == nil
+		}
+		if !ok {
+			c.reply("CLIENT_ERROR bad command line format")
+			return nil
+		}
+		reqPay := &pb.KVRequest{Command: EXPIRE, Key: args[0]}
+		if ttlMs, expired := memcacheTTL(exptime); expired {
+			reqPay = &pb.KVRequest{Command: REMOVE, Key: args[0]}
+		} else {
+			reqPay.TtlMs = ttlMs
+		}
+		respPay := c.call(reqPay, noreply, KEY_DNE_ERR)
+		if respPay == nil || noreply {
+			return nil
+		}
+		if respPay.ErrCode == NO_ERR {
+			c.reply("TOUCHED")
+		} else {
+			c.reply("NOT_FOUND")
+		}
+
+	case "version":
+		c.reply("VERSION " + memcacheVersion)
+
+	case "verbosity":
+		c.reply("OK")
+
+	case "quit":
+		return io.EOF
+
+	default:
+		c.reply("ERROR")
+	}
+	return nil
+}
+
+// Run the commands of a memcached client until it disconnects. Commands
+// are run one at a time so pipelined commands are answered in order.
+func (n *Node) serveMemcache(conn net.Conn) {
+	n.track(conn)
+	defer n.untrack(conn)
+	defer conn.Close()
+	remote := conn.RemoteAddr().(*net.TCPAddr)
+	c := &memcacheClient{
+		node: n,
+		addr: &net.UDPAddr{IP: remote.IP, Port: remote.Port},
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull || len(line) > maxMemcacheLine {
+			c.reply("CLIENT_ERROR line too long")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		// The keys of the line end up in the store, they can't point into
+		// the buffer of the reader
+		line = append([]byte{}, bytes.TrimRight(line, "\r\n")...)
+		err = c.handle(line)
+		// Flush once the pipelined commands already received are answered
+		if c.r.Buffered() == 0 || err != nil {
+			if c.w.Flush() != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Starts the listener of the memcached text protocol
+//
+// Arguments:
+//		port: port number to listen on
+func (n *Node) MemcacheHandler(port int) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Println("Error setting up the memcached listener:", err)
+		return
+	}
+	n.track(listener)
+
+	log.Println("Memcached listener on port", port)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if n.isStopped() {
+				return
+			}
+			log.Println("Error accepting a memcached connection:", err)
+			continue
+		}
+		n.goRun(func() { n.serveMemcache(conn) })
+	}
+}
@@ -0,0 +1,115 @@
+package pa2lib
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run memcached command lines, each followed by its data, on a node
+// and get the replies
+func runMemcache(n *Node, input string) (string, error) {
+	var out bytes.Buffer
+	c := &memcacheClient{
+		node: n,
+		addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1},
+		r:    bufio.NewReader(strings.NewReader(input)),
+		w:    bufio.NewWriter(&out),
+	}
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if err != nil {
+			break
+		}
+		if err := c.handle(bytes.TrimRight(append([]byte{}, line...), "\r\n")); err != nil {
+			c.w.Flush()
+			return out.String(), err
+		}
+	}
+	c.w.Flush()
+	return out.String(), nil
+}
+
+func TestMemcacheHandle(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		// Whether the connection must be closed after the input
+		closed bool
+	}{
+		{"set and get", "set k 5 0 1\r\nv\r\nget k\r\n", "STORED\r\nVALUE k 5 1\r\nv\r\nEND\r\n", false},
+		{"get of a missing key", "get k\r\n", "END\r\n", false},
+		{"get without a key", "get\r\n", "ERROR\r\n", false},
+		{"gets gives the version", "set k 0 0 1\r\nv\r\ngets k\r\n", "STORED\r\nVALUE k 0 1 1\r\nv\r\nEND\r\n", false},
+		{"cas with the version", "set k 0 0 1\r\nv\r\ncas k 0 0 1 1\r\nw\r\nget k\r\n", "STORED\r\nSTORED\r\nVALUE k 0 1\r\nw\r\nEND\r\n", false},
+		{"set noreply", "set k 0 0 1 noreply\r\nv\r\nget k\r\n", "VALUE k 0 1\r\nv\r\nEND\r\n", false},
+		{"add of an existing key", "set k 0 0 1\r\nv\r\nadd k 0 0 1\r\nw\r\n", "STORED\r\nNOT_STORED\r\n", false},
+		{"replace of a missing key", "replace k 0 0 1\r\nv\r\n", "NOT_STORED\r\n", false},
+		{"cas of a missing key", "cas k 0 0 1 1\r\nv\r\n", "NOT_FOUND\r\n", false},
+		{"cas with an old version", "set k 0 0 1\r\nv\r\ncas k 0 0 1 7\r\nw\r\n", "STORED\r\nEXISTS\r\n", false},
+		{"set with a past exptime", "set k 0 -1 1\r\nv\r\nget k\r\n", "STORED\r\nEND\r\n", false},
+		{"set with bad flags", "set k x 0 1\r\n", "CLIENT_ERROR bad command line format\r\n", false},
+		{"set with too few arguments", "set k 0 0\r\n", "ERROR\r\n", false},
+		{"set with a bad noreply", "set k 0 0 1 please\r\n", "CLIENT_ERROR bad command line format\r\n", false},
+		// The rest of the block is read as the next command
+		{"data block without CRLF", "set k 0 0 1\r\nvxx\r\n", "CLIENT_ERROR bad data chunk\r\nERROR\r\n", false},
+		{"data block too large", "set k 0 0 2000000\r\n", "SERVER_ERROR object too large for cache\r\n", true},
+		{"delete", "set k 0 0 1\r\nv\r\ndelete k\r\ndelete k\r\n", "STORED\r\nDELETED\r\nNOT_FOUND\r\n", false},
+		{"delete with a time of 0", "set k 0 0 1\r\nv\r\ndelete k 0 noreply\r\nget k\r\n", "STORED\r\nEND\r\n", false},
+		{"delete without a key", "delete\r\n", "CLIENT_ERROR bad command line format. Usage: delete <key> [noreply]\r\n", false},
+		{"incr and decr", "set k 0 0 2\r\n10\r\nincr k 5\r\ndecr k 20\r\n", "STORED\r\n15\r\n0\r\n", false},
+		{"incr of a missing key", "incr k 1\r\n", "NOT_FOUND\r\n", false},
+		{"incr of a string", "set k 0 0 1\r\nv\r\nincr k 1\r\n", "STORED\r\nCLIENT_ERROR cannot increment or decrement non-numeric value\r\n", false},
+		{"incr by a negative delta", "incr k -1\r\n", "CLIENT_ERROR invalid numeric delta argument\r\n", false},
+		{"touch", "set k 0 0 1\r\nv\r\ntouch k 100\r\ntouch j 100\r\n", "STORED\r\nTOUCHED\r\nNOT_FOUND\r\n", false},
+		{"touch with a bad exptime", "touch k x\r\n", "CLIENT_ERROR bad command line format\r\n", false},
+		{"version", "version\r\n", "VERSION " + memcacheVersion + "\r\n", false},
+		{"empty line", "\r\n", "ERROR\r\n", false},
+		{"unknown command", "flush_all\r\n", "ERROR\r\n", false},
+		{"quit", "quit\r\nversion\r\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := runMemcache(newTestNode(), tt.input)
+			if (err != nil) != tt.closed {
+				t.Fatalf("got error %v, want the connection closed %v", err, tt.closed)
+			}
+			if out != tt.want {
+				t.Fatalf("got %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemcacheTTL(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name        string
+		exptime     int64
+		wantTTLMs   uint64
+		wantExpired bool
+	}{
+		{"none", 0, 0, false},
+		{"relative", 10, 10000, false},
+		{"negative", -1, 0, true},
+		{"last relative second", memcacheRelativeMax, memcacheRelativeMax * 1000, false},
+		{"past timestamp", now - 10, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttlMs, expired := memcacheTTL(tt.exptime)
+			if ttlMs != tt.wantTTLMs || expired != tt.wantExpired {
+				t.Fatalf("got %d ms expired %v, want %d ms expired %v", ttlMs, expired, tt.wantTTLMs, tt.wantExpired)
+			}
+		})
+	}
+
+	// A timestamp in the future is made relative
+	if ttlMs, expired := memcacheTTL(now + 100); expired || ttlMs < 99000 || ttlMs > 100000 {
+		t.Fatalf("got %d ms expired %v for a timestamp 100 s away", ttlMs, expired)
+	}
+}
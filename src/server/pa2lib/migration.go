@@ -0,0 +1,259 @@
+package pa2lib
+
+import (
+	"log"
+	"net"
+	pb "pa2/pb/protobuf"
+	"time"
+)
+
+// A range this node owns but whose keys are still being moved
+// over from the previous owner
+type migrationRange struct {
+	start uint32
+	end   uint32
+	from  NodeVal
+}
+
+// Build a NodeVal from an "ip:port" address, using the node list
+// entry when there is one
+func (n *Node) nodeFromAddr(addr string) NodeVal {
+	if node, ok := n.lookupNode(addr); ok {
+		return node
+	}
+	ip, port, _ := net.SplitHostPort(addr)
+	return NodeVal{ipAdr: ip, port: port, isOn: true}
+}
+
+// Tell the target of a range that its keys start or stopped moving
+// from this node
+//
+// Arguments:
+//		cmd: MIGRATE_BEGIN or MIGRATE_DONE
+//		rng: range being moved
+func (n *Node) notifyMigration(cmd uint32, rng rebalanceRange) {
+	reqPay := &pb.KVRequest{
+		Command:    cmd,
+		Addr:       []byte(n.localIP + ":" + n.localPort),
+		RangeStart: rng.start,
+		RangeEnd:   rng.end,
+	}
+	_, err := n.sendRequestAndWait(rng.target, reqPay, migrateTimeout, migrateAttempts)
+	if err != nil {
+		log.Println("Failed to notify", rng.target.ipAdr+":"+rng.target.port, "of migration:", err)
+	}
+}
+
+// Record that a range is being moved to this node
+func (n *Node) beginIncomingMigration(start uint32, end uint32, from NodeVal) uint32 {
+	n.migrationMutex.Lock()
+	defer n.migrationMutex.Unlock()
+	for _, m := range n.incomingMigrations {
+		if m.start == start && m.end == end && sameNode(m.from, from) {
+			return NO_ERR
+		}
+	}
+	n.incomingMigrations = append(n.incomingMigrations, migrationRange{start: start, end: end, from: from})
+	log.Printf("Migration of (%v, %v] from %v:%v started\n", start, end, from.ipAdr, from.port)
+	return NO_ERR
+}
+
+// Forget a range once all of its keys were moved to this node, along
+// with the keys removed while it was migrating
+func (n *Node) endIncomingMigration(start uint32, end uint32, from NodeVal) uint32 {
+	n.migrationMutex.Lock()
+	for i, m := range n.incomingMigrations {
+		if m.start == start && m.end == end && sameNode(m.from, from) {
+			n.incomingMigrations = append(n.incomingMigrations[:i], n.incomingMigrations[i+1:]...)
+			log.Printf("Migration of (%v, %v] from %v:%v done\n", start, end, from.ipAdr, from.port)
+			break
+		}
+	}
+	n.migrationMutex.Unlock()
+
+	n.mutex.Lock()
+	for key := range n.tombstones {
+		if inHashRange(hashKeyfromKey([]byte(key)), start, end) {
+			delete(n.tombstones, key)
+		}
+	}
+	n.mutex.Unlock()
+	return NO_ERR
+}
+
+// Forget the ranges being moved from a node that died or left, which
+// will never send MIGRATE_DONE
+func (n *Node) dropIncomingMigrations(event MembershipEvent) {
+	if event.Type != NODE_DEAD && event.Type != NODE_LEFT {
+		return
+	}
+	var dropped []migrationRange
+	n.migrationMutex.Lock()
+	for _, m := range n.incomingMigrations {
+		if sameNode(m.from, event.Node) {
+			dropped = append(dropped, m)
+		}
+	}
+	n.migrationMutex.Unlock()
+
+	for _, m := range dropped {
+		n.endIncomingMigration(m.start, m.end, m.from)
+	}
+}
+
+// Find the node that owned a key before an unfinished migration
+//
+// Arguments:
+//		key: key to look up
+// Returns:
+//		The previous owner, and true if the key is still migrating
+func (n *Node) previousOwner(key []byte) (NodeVal, bool) {
+	hash := hashKeyfromKey(key)
+	n.migrationMutex.Lock()
+	defer n.migrationMutex.Unlock()
+	for _, m := range n.incomingMigrations {
+		if inHashRange(hash, m.start, m.end) {
+			return m.from, true
+		}
+	}
+	return NodeVal{}, false
+}
+
+// Send a request about a single key to another node
+func (n *Node) sendKeyRequest(node NodeVal, cmd uint32, key []byte, value []byte, version int32) (*pb.KVResponse, error) {
+	reqPay := &pb.KVRequest{
+		Command: cmd,
+		Key:     key,
+		Value:   value,
+		Version: version,
+	}
+	return n.sendRequestAndWait(node, reqPay, migrateTimeout, migrateAttempts)
+}
+
+// Get a key this node owns. If the key was not moved here yet, it is
+// read from the previous owner instead.
+//
+// Arguments:
+//		key: key to get the value and version for
+// Returns:
+//		Same as Get
+func (n *Node) getOwned(key []byte) ([]byte, int32, uint32) {
+	value, version, errCode := n.Get(key)
+	if errCode != KEY_DNE_ERR {
+		return value, version, errCode
+	}
+
+	node, migrating := n.previousOwner(key)
+	if !migrating {
+		return value, version, errCode
+	}
+
+	respPay, err := n.sendKeyRequest(node, MIGRATE_GET, key, nil, 0)
+	if err != nil {
+		log.Println("Failed to read migrating key from", node.ipAdr+":"+node.port, err)
+		return nil, 0, KV_INTERNAL_ERR
+	}
+	return respPay.Value, respPay.Version, respPay.ErrCode
+}
+
+// Put a key this node owns and send it to the son and grandson. While
+// the key is migrating, the write also goes to the previous owner so
+// neither copy gets stale.
+//
+// Arguments:
+//		key, value, version: same as Put
+// Returns:
+//		Error code
+func (n *Node) putOwned(key []byte, value []byte, version *int32) uint32 {
+	errCode := n.Put(key, value, version)
+	if errCode != NO_ERR {
+		return errCode
+	}
+	n.noteWrite(key, time.Now().UnixNano())
+	var v int32
+	if version != nil {
+		v = *version
+	}
+	n.normalReplicate(PUT, key, value, v, n.localNode())
+
+	if node, migrating := n.previousOwner(key); migrating {
+		if _, err := n.sendKeyRequest(node, MIGRATE_DUAL_PUT, key, value, v); err != nil {
+			log.Println("Failed to write migrating key to", node.ipAdr+":"+node.port, err)
+		}
+	}
+
+	// While decommissioning, the son and its replicas get the write too,
+	// after any copy of the key being handed off
+	if son, leaving := n.handoffTarget(); leaving {
+		n.rebalancer.handoffMutex.Lock()
+		defer n.rebalancer.handoffMutex.Unlock()
+		if _, err := n.sendKeyRequest(son, MIGRATE_DUAL_PUT, key, value, v); err != nil {
+			log.Println("Failed to hand off write to", son.ipAdr+":"+son.port, err)
+		}
+		n.normalReplicate(PUT, key, value, v, son)
+	}
+	return errCode
+}
+
+// Remove a key this node owns, and from the son and grandson. While
+// the key is migrating, it is removed from the previous owner first,
+// and remembered so a copy the previous owner was already sending is
+// not stored. If the previous owner can't be reached, nothing is
+// removed.
+//
+// Arguments:
+//		key: key to remove
+// Returns:
+//		NO_ERR if the key existed on either node, otherwise KEY_DNE_ERR
+func (n *Node) removeOwned(key []byte) uint32 {
+	return n.removeOwnedWith(key, n.Remove)
+}
+
+// Remove a key this node owns like removeOwned, with the function
+// removing the local copy
+func (n *Node) removeOwnedWith(key []byte, remove func(key []byte) uint32) uint32 {
+	prevErrCode := uint32(KEY_DNE_ERR)
+	if node, migrating := n.previousOwner(key); migrating {
+		n.mutex.Lock()
+		tombstoned := n.tombstones[string(key)]
+		n.tombstones[string(key)] = true
+		n.mutex.Unlock()
+
+		respPay, err := n.sendKeyRequest(node, MIGRATE_DUAL_REMOVE, key, nil, 0)
+		if err != nil {
+			log.Println("Failed to remove migrating key from", node.ipAdr+":"+node.port, err)
+			if !tombstoned {
+				n.mutex.Lock()
+				delete(n.tombstones, string(key))
+				n.mutex.Unlock()
+			}
+			return KV_INTERNAL_ERR
+		}
+		prevErrCode = respPay.ErrCode
+	}
+
+	// While decommissioning, the son and its replicas drop it too. The
+	// local copy goes at the same time, so a handoff can't send it after.
+	if son, leaving := n.handoffTarget(); leaving {
+		n.rebalancer.handoffMutex.Lock()
+		defer n.rebalancer.handoffMutex.Unlock()
+		if _, err := n.sendKeyRequest(son, MIGRATE_DUAL_REMOVE, key, nil, 0); err != nil {
+			log.Println("Failed to hand off remove to", son.ipAdr+":"+son.port, err)
+		}
+		n.normalReplicate(REMOVE, key, nil, 0, son)
+	}
+
+	errCode := remove(key)
+	n.noteWrite(key, time.Now().UnixNano())
+	n.normalReplicate(REMOVE, key, nil, 0, n.localNode())
+	if errCode == KEY_DNE_ERR && prevErrCode == NO_ERR {
+		return NO_ERR
+	}
+	return errCode
+}
+
+// Read the range and previous owner of a migration command
+func (n *Node) migrationRangeFromRequest(reqPay *pb.KVRequest) (uint32, uint32, NodeVal) {
+	return reqPay.RangeStart, reqPay.RangeEnd, n.nodeFromAddr(string(reqPay.Addr))
+}
+
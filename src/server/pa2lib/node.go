@@ -0,0 +1,361 @@
+package pa2lib
+
+import (
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Node holds the state of one server of the cluster, so several nodes
+// can run in the same process
+type Node struct {
+	// Settings of the node
+	config Config
+
+	// Port the node listens on for UDP and TCP, and the IP address other
+	// nodes reach it on
+	localPort string
+	localIP   string
+	// Nodes of the server list, contacted to join the cluster
+	serverList []string
+
+	// UDP connection of the node, so that it does not need to be passed
+	// to every function
+	conn *net.UDPConn
+
+	// Counter of how many clients are currently being handled, should
+	// be updated atomically
+	numClients uint64
+
+	// Closed once the node is stopped
+	stopped  chan struct{}
+	stopOnce sync.Once
+	// Listeners and connections closed when the node is stopped
+	closers      map[io.Closer]bool
+	closersMutex sync.Mutex
+	// Background loops and connections being served, Stop waits for them
+	// to end
+	running sync.WaitGroup
+
+	// In-memory key-value store data structure, and the copies of the
+	// keys of the father and grandfather
+	KVStore    []StoreVal
+	repKVStore [2][]StoreVal
+	// Mutex for locking the KVStore object
+	mutex *sync.Mutex
+
+	// Time at which the keys owned by this node with a time to live
+	// expire, by key. Expiries stay on the node that set them, a key
+	// moved to another node loses its time to live.
+	expiries      map[string]time.Time
+	expiriesMutex *sync.Mutex
+
+	// Flags memcached clients store with the keys owned by this node, by
+	// key. Like expiries, they stay on the node that set them. Locked by
+	// mutex.
+	flags map[string]uint32
+
+	// Serializes the read-modify-write commands of this node (INCR,
+	// PUT_IF), so no update is lost between the read and the write
+	condMutex *sync.Mutex
+
+	// In-memory response cache
+	Cache []CacheVal
+	// Mutex for locking the Cache map
+	cacheMutex *sync.Mutex
+
+	// Known nodes, by address, and the nodes of the server list
+	nodeList      map[string]*NodeVal
+	startNodeList map[string]NodeVal
+	// Mutex for locking the nodeList, gossip updates it while
+	// requests are being handled
+	nodeListMutex *sync.RWMutex
+
+	// hash ring
+	consistent *Consistent
+
+	// Membership updates waiting to be piggybacked on outgoing messages
+	pendingUpdates      map[string]*pendingUpdate
+	pendingUpdatesMutex *sync.Mutex
+	// Nodes probed in the current round, and when the suspected nodes
+	// were first seen as suspect
+	probeTargets []NodeVal
+	suspectSince map[string]time.Time
+	// Detector used by the failure detector loop
+	detector FailureDetector
+
+	// Handlers of the membership events, and the number of events seen
+	// since the node started, per type
+	membershipSubscribers      []membershipSubscriber
+	membershipSubscribersMutex *sync.RWMutex
+	nextSubscriberID           int
+	membershipEventCounts      map[string]uint64
+	membershipEventCountsMutex *sync.Mutex
+
+	// Active watches, by client address or gateway stream, see watchKey
+	watches      map[string]*membershipWatch
+	watchesMutex *sync.Mutex
+
+	// Last mode the node was in, to log the changes
+	currentMode      string
+	currentModeMutex *sync.Mutex
+
+	// Ranges moved away from this node, and the ranges that are being
+	// migrated to it
+	rebalancer         *Rebalancer
+	incomingMigrations []migrationRange
+	migrationMutex     *sync.Mutex
+	// Keys removed while their range was migrating to this node, so a
+	// copy of them moved over afterwards is dropped. Locked by mutex.
+	tombstones map[string]bool
+
+	// Forwards waiting for their ack, by message ID. A client retrying
+	// a request that is still being forwarded doesn't start a second
+	// forward.
+	pendingForwards      map[string]chan bool
+	pendingForwardsMutex *sync.Mutex
+
+	// Writes taken in with a sloppy quorum, to replay to the owners of
+	// their keys, by key
+	hints      map[string]hint
+	hintsMutex *sync.Mutex
+	nextHintID uint64
+	// Time of the last write of each key this node owns, in Unix
+	// nanoseconds, so a hint older than it is not replayed over it. Kept
+	// for removed keys too, and forgotten after maxHintAge. Locked by
+	// mutex.
+	writeTimes map[string]int64
+
+	// Open connections to other nodes, by address
+	nodeConns      map[string]*nodeConn
+	nodeConnsMutex *sync.Mutex
+}
+
+// Create a node. Nothing is listened on until it is started.
+//
+// Arguments:
+//		port: port number to listen on for UDP and TCP
+//		serverList: addresses ("ip:port") of the initial nodes
+//		config: settings of the node
+// Returns:
+//		The node
+func NewNode(port int, serverList []string, config Config) *Node {
+	n := &Node{
+		config:                     config,
+		localPort:                  strconv.Itoa(port),
+		localIP:                    config.AdvertiseIP,
+		serverList:                 serverList,
+		stopped:                    make(chan struct{}),
+		closers:                    make(map[io.Closer]bool),
+		KVStore:                    []StoreVal{},
+		mutex:                      &sync.Mutex{},
+		expiries:                   make(map[string]time.Time),
+		expiriesMutex:              &sync.Mutex{},
+		flags:                      make(map[string]uint32),
+		condMutex:                  &sync.Mutex{},
+		Cache:                      []CacheVal{},
+		cacheMutex:                 &sync.Mutex{},
+		nodeList:                   map[string]*NodeVal{},
+		startNodeList:              map[string]NodeVal{},
+		nodeListMutex:              &sync.RWMutex{},
+		consistent:                 newConsistent(),
+		pendingUpdates:             make(map[string]*pendingUpdate),
+		pendingUpdatesMutex:        &sync.Mutex{},
+		suspectSince:               make(map[string]time.Time),
+		detector:                   newFailureDetector(config),
+		membershipSubscribersMutex: &sync.RWMutex{},
+		membershipEventCounts:      make(map[string]uint64),
+		membershipEventCountsMutex: &sync.Mutex{},
+		watches:                    make(map[string]*membershipWatch),
+		watchesMutex:               &sync.Mutex{},
+		currentMode:                MODE_NORMAL,
+		currentModeMutex:           &sync.Mutex{},
+		migrationMutex:             &sync.Mutex{},
+		tombstones:                 make(map[string]bool),
+		pendingForwards:            make(map[string]chan bool),
+		pendingForwardsMutex:       &sync.Mutex{},
+		hints:                      make(map[string]hint),
+		hintsMutex:                 &sync.Mutex{},
+		writeTimes:                 make(map[string]int64),
+		nodeConns:                  make(map[string]*nodeConn),
+		nodeConnsMutex:             &sync.Mutex{},
+	}
+	n.rebalancer = &Rebalancer{node: n, wake: make(chan bool, 1)}
+	return n
+}
+
+// Start the node: listen for requests, join the cluster through the
+// seeds and keep the membership up to date in the background
+//
+// Returns:
+//		An error if the node can't listen on its port
+func (n *Node) Start() error {
+	log.Println("Server start")
+
+	if n.localIP == "" {
+		conn1, err := net.Dial("udp", "8.8.8.8:80")
+		if err != nil {
+			return err
+		}
+		n.localIP = conn1.LocalAddr().(*net.UDPAddr).IP.String()
+		conn1.Close()
+	}
+
+	port, _ := strconv.Atoi(n.localPort)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+	n.conn = conn
+	n.track(conn)
+
+	// Start the cache TTL manager
+	n.goRun(n.CacheTTLManager)
+
+	n.subscribeMembershipHandlers()
+
+	// generate hash ring for the current node, the others are added as
+	// they are found alive
+	n.initStartNodeList(n.serverList)
+	n.addLocalNode()
+	nodeList := n.getNodeList()
+	n.consistent.generateHashRing(nodeList)
+
+	n.goRun(n.KVReqHandler)
+	n.goRun(func() { n.TCPReqHandler(port) })
+	if n.config.GRPCPort != 0 {
+		n.goRun(func() { n.GRPCHandler(int(n.config.GRPCPort)) })
+	}
+	if n.config.HTTPPort != 0 {
+		n.goRun(func() { n.HTTPHandler(int(n.config.HTTPPort)) })
+	}
+	if n.config.RedisPort != 0 {
+		n.goRun(func() { n.RESPHandler(int(n.config.RedisPort)) })
+	}
+	if n.config.MemcachePort != 0 {
+		n.goRun(func() { n.MemcacheHandler(int(n.config.MemcachePort)) })
+	}
+	n.goRun(n.RebalanceWorker)
+	n.goRun(n.ExpiryLoop)
+	n.goRun(n.HintLoop)
+
+	// Announce ourselves once the server can answer, then keep the
+	// membership up to date
+	n.joinCluster()
+	n.goRun(n.GossipLoop)
+	n.goRun(n.FailureDetectorLoop)
+	return nil
+}
+
+// Stop the node like a crash: its listeners and connections are closed
+// and its background loops end, without telling the other nodes. Returns
+// once they ended. Safe to call more than once.
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		log.Println("Server shutdown", n.Addr())
+		close(n.stopped)
+
+		n.closersMutex.Lock()
+		closers := n.closers
+		n.closers = make(map[io.Closer]bool)
+		n.closersMutex.Unlock()
+		for c := range closers {
+			c.Close()
+		}
+
+		n.nodeConnsMutex.Lock()
+		for addr, c := range n.nodeConns {
+			c.conn.Close()
+			delete(n.nodeConns, addr)
+		}
+		n.nodeConnsMutex.Unlock()
+	})
+	n.running.Wait()
+}
+
+// Run a background loop or the serving of a connection in its own
+// goroutine, which Stop waits for
+func (n *Node) goRun(f func()) {
+	n.running.Add(1)
+	go func() {
+		defer n.running.Done()
+		f()
+	}()
+}
+
+// Get a channel closed once the node is stopped
+func (n *Node) Done() <-chan struct{} {
+	return n.stopped
+}
+
+// Check whether the node is stopped
+func (n *Node) isStopped() bool {
+	select {
+	case <-n.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close a listener or connection when the node stops, or right away if
+// it is already stopped
+func (n *Node) track(c io.Closer) {
+	n.closersMutex.Lock()
+	if !n.isStopped() {
+		n.closers[c] = true
+		n.closersMutex.Unlock()
+		return
+	}
+	n.closersMutex.Unlock()
+	c.Close()
+}
+
+// Forget a connection closed before the node stops
+func (n *Node) untrack(c io.Closer) {
+	n.closersMutex.Lock()
+	delete(n.closers, c)
+	n.closersMutex.Unlock()
+}
+
+// Wait for a duration, or until the node is stopped
+//
+// Returns:
+//		False if the node was stopped
+func (n *Node) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-n.stopped:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Get the address ("ip:port") other nodes reach this node on
+func (n *Node) Addr() string {
+	return n.localIP + ":" + n.localPort
+}
+
+// Get the membership state of every node this node knows about
+//
+// Returns:
+//		The states (MEMBERSHIP_MEMBER, MEMBERSHIP_SUSPECT, MEMBERSHIP_DEAD
+//		or MEMBERSHIP_LEFT), by address
+func (n *Node) Members() map[string]string {
+	members := make(map[string]string)
+	for addr, node := range n.getNodeList() {
+		members[addr] = node.membership
+	}
+	return members
+}
+
+// Get the epoch of the hash ring of this node. Nodes with the same ring
+// have the same epoch.
+func (n *Node) RingEpoch() uint64 {
+	return n.ringEpoch()
+}
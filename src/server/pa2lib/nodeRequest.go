@@ -0,0 +1,110 @@
+package pa2lib
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	pb "pa2/pb/protobuf"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Generate a message ID for a request sent by this node
+func (n *Node) newNodeMsgID() []byte {
+	port, _ := strconv.Atoi(n.localPort)
+	return generateLocalMsgID(net.ParseIP(n.localIP).To4(), port)
+}
+
+// Wrap a request payload into a message with a checksum
+func marshalRequestMsg(msgID []byte, reqPay *pb.KVRequest) ([]byte, error) {
+	reqPayBytes, err := proto.Marshal(reqPay)
+	if err != nil {
+		return nil, err
+	}
+
+	reqMsg := pb.Msg{
+		MessageID: msgID,
+		Payload:   reqPayBytes,
+		CheckSum:  getChecksum(msgID, reqPayBytes),
+	}
+	return proto.Marshal(&reqMsg)
+}
+
+// Send a request to another node and wait for its response. The
+// request is resent with the same message ID and a doubled timeout
+// until it gets an answer or runs out of attempts. It goes over TCP
+// unless the node transport is UDP or the node doesn't listen on TCP.
+//
+// Arguments:
+//		node: node to send the request to
+//		reqPay: request payload
+//		timeout: time to wait for the first response
+//		attempts: number of times the request is sent
+// Returns:
+//		The response payload, or an error if no valid response arrived
+func (n *Node) sendRequestAndWait(node NodeVal, reqPay *pb.KVRequest, timeout time.Duration, attempts int) (*pb.KVResponse, error) {
+	msgID := n.newNodeMsgID()
+	reqMsgBytes, err := marshalRequestMsg(msgID, reqPay)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.config.NodeTransport == TRANSPORT_TCP {
+		respMsgBytes, err := n.sendOverTCP(node.ipAdr+":"+node.port, msgID, reqMsgBytes, timeout, attempts)
+		if err != errNoTCP {
+			if err != nil {
+				return nil, err
+			}
+			_, respPayBytes, _ := unmarshalMsg(respMsgBytes)
+			respPay := &pb.KVResponse{}
+			if err := proto.Unmarshal(respPayBytes, respPay); err != nil {
+				return nil, err
+			}
+			return respPay, nil
+		}
+		// The node only speaks UDP
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", node.ipAdr+":"+node.port)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 65535)
+	for i := 0; i < attempts; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		if _, err = conn.Write(reqMsgBytes); err != nil {
+			log.Println("sendRequestAndWait write error:", err)
+		}
+
+		numBytes, err := conn.Read(buf)
+		if err != nil {
+			timeout *= 2
+			continue
+		}
+
+		respID, respPayBytes, _ := unmarshalMsg(buf[:numBytes])
+		if !bytes.Equal(respID, msgID) {
+			log.Println("sendRequestAndWait received wrong message from", raddr)
+			timeout *= 2
+			continue
+		}
+
+		respPay := &pb.KVResponse{}
+		if err := proto.Unmarshal(respPayBytes, respPay); err != nil {
+			return nil, err
+		}
+		return respPay, nil
+	}
+
+	return nil, fmt.Errorf("no response from %v after %d attempts", raddr, attempts)
+}
@@ -3,22 +3,20 @@ package pa2lib
 import (
 	"bufio"
 	"encoding/binary"
-	"encoding/hex"
-	"errors"
-	"hash/crc32"
+	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"math/rand"
 	"net"
 	"os"
-	"pa2/pb/protobuf"
 	pb "pa2/pb/protobuf"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/google/uuid"
 )
 
 // NodeVal Data type used to store a node
@@ -28,118 +26,423 @@ type NodeVal struct {
 	isOn       bool
 	membership string
 	time       uint64
+	// Bumped by the node itself to refute a suspicion, so that newer
+	// news about a node always wins
+	incarnation uint64
+	// Metadata of the node set in its config, like its zone or role.
+	// Never modified, so copies of a node can share it
+	tags map[string]string
 }
 
-var nodeList = map[string]*NodeVal{}
-var startNodeList = map[string]NodeVal{}
-var localPort = os.Args[1]
+// Values of NodeVal.membership. Alive and suspect nodes are on and in
+// the hash ring, dead and left nodes are off.
+const (
+	MEMBERSHIP_MEMBER  = "0"
+	MEMBERSHIP_SUSPECT = "suspect"
+	MEMBERSHIP_DEAD    = "dead"
+	MEMBERSHIP_LEFT    = "left"
+)
 
-func initNodeList(serverListFile string ) {
+// Read the "ip:port" lines of a server list file
+//
+// Arguments:
+//		serverListFile: path of the file
+// Returns:
+//		The addresses read before any error, and the error
+func readServerList(serverListFile string) ([]string, error) {
 	file, err := os.OpenFile(serverListFile, os.O_RDONLY, 0666)
 	if err != nil {
-		log.Println("Open file error!", err)
-		return
+		return nil, err
 	}
 	defer file.Close()
 
+	var serverList []string
 	buf := bufio.NewReader(file)
 	for {
 		line, err := buf.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				log.Println(line, " file read ok!")
-				break
-			} else {
-				log.Println(line, " read file error!", err)
-				return
-			}
+		if err != nil && err != io.EOF {
+			return serverList, err
 		}
 
 		line = strings.TrimSpace(line)
-		//log.Println(line)
-		s := strings.Split(line, ":")
-		log.Println("Initialize node: " + s[0] + ":" + s[1])
-		IP := s[0]
-		port := s[1]
-		node := NodeVal{ipAdr: IP, port: port, isOn: true, membership: "0", time: 0}
-		nodeList[IP + ":" + port] = &node
-		startNodeList[IP + ":" + port] = node
+		if line != "" {
+			serverList = append(serverList, line)
+		}
+
+		if err == io.EOF {
+			log.Println(serverListFile, " file read ok!")
+			return serverList, nil
+		}
+	}
+}
+
+// Remember the initial nodes as seeds. They only enter the node list
+// and hash ring once the JOIN response or gossip says they are alive,
+// so a listed node that isn't running is never given keys.
+//
+// Arguments:
+//		serverList: addresses ("ip:port") of the nodes
+func (n *Node) initStartNodeList(serverList []string) {
+	n.nodeListMutex.Lock()
+	defer n.nodeListMutex.Unlock()
+
+	for _, addr := range serverList {
+		s := strings.Split(addr, ":")
+		if len(s) == 2 {
+			log.Println("Initialize node: " + s[0] + ":" + s[1])
+			IP := s[0]
+			port := s[1]
+			node := NodeVal{ipAdr: IP, port: port, isOn: true, membership: MEMBERSHIP_MEMBER, time: 0}
+			n.startNodeList[IP + ":" + port] = node
+		}
+	}
+}
+
+// Add this node to its own node list
+func (n *Node) addLocalNode() {
+	n.nodeListMutex.Lock()
+	defer n.nodeListMutex.Unlock()
+	addr := n.localIP + ":" + n.localPort
+	// A restarted node starts with a higher incarnation than it ever
+	// had before, so the cluster takes it back even if it was dead
+	now := uint64(time.Now().UnixNano())
+	n.nodeList[addr] = &NodeVal{ipAdr: n.localIP, port: n.localPort, isOn: true, membership: MEMBERSHIP_MEMBER, time: now, incarnation: now, tags: n.config.Tags}
+}
+
+// Replace the entry of this node, and spread it to the others
+func (n *Node) setLocalNode(node NodeVal) {
+	n.nodeListMutex.Lock()
+	entry := node
+	n.nodeList[n.localIP + ":" + n.localPort] = &entry
+	n.nodeListMutex.Unlock()
+	n.queueUpdate(node)
+}
+
+// Get the entry of a node from the node list
+//
+// Arguments:
+//		addr: "ip:port" of the node
+// Returns:
+//		A copy of the entry, and false if the node is unknown
+func (n *Node) lookupNode(addr string) (NodeVal, bool) {
+	n.nodeListMutex.RLock()
+	defer n.nodeListMutex.RUnlock()
+	if node, ok := n.nodeList[addr]; ok {
+		return *node, true
+	}
+	return NodeVal{}, false
+}
+
+// Get the entry of this node from the node list
+func (n *Node) localNode() NodeVal {
+	if node, ok := n.lookupNode(n.localIP + ":" + n.localPort); ok {
+		return node
+	}
+	return NodeVal{ipAdr: n.localIP, port: n.localPort, isOn: true}
+}
+
+// Order of the membership states for the same incarnation
+func membershipRank(membership string) int {
+	switch membership {
+	case MEMBERSHIP_SUSPECT:
+		return 1
+	case MEMBERSHIP_DEAD:
+		return 2
+	case MEMBERSHIP_LEFT:
+		return 3
+	}
+	return 0
+}
+
+// Check whether an update about a node is newer than what we know.
+// A higher incarnation always wins. For the same incarnation, left
+// beats dead, dead beats suspect and suspect beats alive.
+func overrides(update NodeVal, node NodeVal) bool {
+	if update.incarnation != node.incarnation {
+		return update.incarnation > node.incarnation
+	}
+	return membershipRank(update.membership) > membershipRank(node.membership)
+}
+
+// A node told us that we are suspected or dead. Refute it by bumping
+// our incarnation, the alive entry then overrides the suspicion
+// wherever it spreads.
+func (n *Node) refuteSuspicion(update NodeVal) {
+	self := n.localNode()
+	if update.membership != MEMBERSHIP_SUSPECT && update.membership != MEMBERSHIP_DEAD {
+		return
+	}
+	if update.incarnation < self.incarnation || self.membership == MEMBERSHIP_LEFT {
+		return
+	}
+
+	self.incarnation = update.incarnation + 1
+	self.isOn = true
+	self.membership = MEMBERSHIP_MEMBER
+	self.time = uint64(time.Now().UnixNano())
+	log.Println("Refuting suspicion, incarnation is now", self.incarnation)
+	n.setLocalNode(self)
+}
+
+// Apply a membership update received from another node or produced by
+// the failure detector. If the state of the node changed, a membership
+// event is published, which updates the hash ring and replication.
+//
+// Arguments:
+//		update: new state of the node
+//		replicate: false if the node is learned while joining, so only
+//		the node list and hash ring are updated
+// Returns:
+//		True if the update was newer than what we knew
+func (n *Node) applyNodeUpdate(update NodeVal, replicate bool) bool {
+	addr := update.ipAdr + ":" + update.port
+	if update.ipAdr == n.localIP && update.port == n.localPort {
+		n.refuteSuspicion(update)
+		return false
+	}
+
+	n.nodeListMutex.Lock()
+	node, known := n.nodeList[addr]
+	if known && !overrides(update, *node) {
+		n.nodeListMutex.Unlock()
+		return false
+	}
+	previous := ""
+	if known {
+		previous = node.membership
+		// Tags only change when a node restarts, and updates made by
+		// other nodes than the node itself may not carry them
+		if update.tags == nil {
+			update.tags = node.tags
+		}
 	}
+	entry := update
+	n.nodeList[addr] = &entry
+	n.nodeListMutex.Unlock()
+
+	n.queueUpdate(update)
+	log.Printf("Node %v is now %q, incarnation %v\n", addr, update.membership, update.incarnation)
+
+	// The subscribers talk to other nodes, so they run without holding
+	// the lock
+	if eventType, ok := membershipEventType(previous, update.membership); ok {
+		n.publishMembership(MembershipEvent{Type: eventType, Node: update, Previous: previous, Initial: !replicate, Time: time.Now()})
+	}
+	return true
 }
 
-func GetMemberShipList() (map[string][]byte, int32, uint32) {
+// Mark a node as having left the cluster on purpose and take it out of
+// the hash ring. Unlike a dead node its data was already handed over,
+// so nothing is re-replicated.
+//
+// Arguments:
+//		node: the node that left, with the incarnation it left with
+func (n *Node) markNodeLeft(node NodeVal) {
+	node.isOn = false
+	node.membership = MEMBERSHIP_LEFT
+	node.time = uint64(time.Now().UnixNano())
+	if node.ipAdr == n.localIP && node.port == n.localPort {
+		previous := n.localNode().membership
+		n.setLocalNode(node)
+		n.publishMembership(MembershipEvent{Type: NODE_LEFT, Node: node, Previous: previous, Time: time.Now()})
+		return
+	}
+	n.applyNodeUpdate(node, true)
+}
+
+// Mark a node as alive, adding it to the node list and hash ring if
+// it wasn't in them
+//
+// Arguments:
+//		node: the node that is alive, with its incarnation
+// Returns:
+//		True if the update was newer than what we knew
+func (n *Node) markNodeAlive(node NodeVal) bool {
+	node.isOn = true
+	node.membership = MEMBERSHIP_MEMBER
+	if node.time == 0 {
+		node.time = uint64(time.Now().UnixNano())
+	}
+	return n.applyNodeUpdate(node, true)
+}
+
+// Convert a node to its protobuf form
+func nodeToPb(node NodeVal) *pb.NodeVal {
+	return &pb.NodeVal{IpAdr: node.ipAdr,
+		Port: node.port,
+		IsOn: node.isOn,
+		Membership: node.membership,
+		Time: node.time,
+		Incarnation: node.incarnation,
+		Tags: node.tags}
+}
+
+// Convert a node from its protobuf form
+func nodeFromPb(nodePb *pb.NodeVal) NodeVal {
+	return NodeVal{ipAdr: nodePb.IpAdr, port: nodePb.Port, isOn: nodePb.IsOn, membership: nodePb.Membership, time: nodePb.Time, incarnation: nodePb.Incarnation, tags: nodePb.Tags}
+}
+
+// Marshal a node for a membership list, with our suspicion level of it
+func (n *Node) membershipEntry(addr string, node NodeVal) []byte {
+	nodePb := nodeToPb(node)
+	if !sameNode(node, NodeVal{ipAdr: n.localIP, port: n.localPort}) {
+		nodePb.Suspicion = n.detector.suspicion(addr)
+	}
+	entry, _ := proto.Marshal(nodePb)
+	return entry
+}
+
+func (n *Node) GetMemberShipList() (map[string][]byte, int32, uint32) {
 	log.Println("send membership list")
 	var returnMap = map[string][]byte{}
-	for addr, node := range nodeList {
-		nodeValPro := pb.NodeVal{IpAdr: node.ipAdr,
-											Port: node.port,
-											IsOn: node.isOn,
-											Membership: node.membership,
-											Time: node.time}
-		returnMap[addr], _ = proto.Marshal(&nodeValPro)
-	}
-	mutex.Lock()
-	defer mutex.Unlock()
-	return returnMap, 0, KEY_DNE_ERR
+	n.nodeListMutex.RLock()
+	defer n.nodeListMutex.RUnlock()
+	for addr, node := range n.nodeList {
+		returnMap[addr] = n.membershipEntry(addr, *node)
+	}
+	return returnMap, 0, NO_ERR
 }
 
-func requestNodeList(ipAdr string, port string) {
-	log.Println("request ndoe list form", ipAdr, port)
-	// Resolve the address to an address of UDP end point
-	raddr, err := net.ResolveUDPAddr("udp", ipAdr+":"+port)
-	//log.Printf("%v:%v\n", raddr.IP, raddr.Port)
-	if err != nil {
-		log.Println("Fail to resolve address", err)
+// Compact form of a node list entry, with only what is needed to tell
+// which of two entries is newer
+func digestEntry(node NodeVal) *pb.NodeVal {
+	return &pb.NodeVal{IpAdr: node.ipAdr,
+		Port: node.port,
+		Membership: node.membership,
+		Incarnation: node.incarnation}
+}
+
+// Get the digest of our node list
+//
+// Returns:
+//		The digest entries, marshalled the same way as a membership list,
+//		and a hash of them that is equal on nodes that agree on the
+//		membership
+func (n *Node) getDigest() (map[string][]byte, uint64) {
+	n.nodeListMutex.RLock()
+	defer n.nodeListMutex.RUnlock()
+
+	addrs := make([]string, 0, len(n.nodeList))
+	for addr := range n.nodeList {
+		addrs = append(addrs, addr)
 	}
+	sort.Strings(addrs)
+
+	digest := make(map[string][]byte)
+	hash := fnv.New64a()
+	for _, addr := range addrs {
+		node := n.nodeList[addr]
+		fmt.Fprintf(hash, "%s %d %s\n", addr, node.incarnation, node.membership)
+		digest[addr], _ = proto.Marshal(digestEntry(*node))
+	}
+	return digest, hash.Sum64()
+}
 
-	// Connect laddr with raddr
-	conn, err := net.DialUDP("udp", nil, raddr)
+// Do a push-pull gossip round with a node. The hash of our digest is
+// sent first, and nothing else is exchanged if the node agrees with us.
+// Otherwise the node answers with its digest, and we push the entries
+// that are newer on our side and pull the ones that are newer on its
+// side.
+//
+// Arguments:
+//		target: node to gossip with
+func (n *Node) gossipWith(target NodeVal) {
+	addr := target.ipAdr + ":" + target.port
+	_, hash := n.getDigest()
+	reqPay := &pb.KVRequest{
+		Command: GOSSIP_DIGEST,
+		Digest:  hash,
+	}
+	respPay, err := n.sendRequestAndWait(target, reqPay, gossipTimeout, gossipAttempts)
 	if err != nil {
-		//log.Printf("Dial fialed: %v:%v\n", raddr.IP, raddr.Port)
-		log.Println(err)
+		// a missed gossip reply alone doesn't make the target dead,
+		// the failure detector probes it and decides
+		log.Printf("Gossip with %v failed: %v\n", addr, err)
+		return
+	}
+	n.detector.heartbeat(addr)
+	if len(respPay.NodeList) == 0 {
+		return
 	}
 
-	// Defer closing the underlying file discriptor associated with the socket
-	defer conn.Close()
-
-	// Use UUIDs as messageID and convert String to byte array
-	uuid := uuid.NewString()
-	msgID := []byte(strings.ReplaceAll(uuid, "-", ""))
-
-	// Get a request message
-	reqMessage, err := getGossipRequestMessage(msgID)
-	// Send request and get respond
-	newNodeList, err := sendReqAndGetRes(reqMessage, msgID, conn)
+	theirs := nodeListParseFromByteArray(respPay.NodeList)
+	push := make(map[string][]byte)
+	var wanted []string
+	n.nodeListMutex.RLock()
+	for nodeAddr, node := range n.nodeList {
+		if theirNode, ok := theirs[nodeAddr]; !ok || overrides(*node, theirNode) {
+			push[nodeAddr], _ = proto.Marshal(nodeToPb(*node))
+		}
+	}
+	for nodeAddr, theirNode := range theirs {
+		if node, ok := n.nodeList[nodeAddr]; !ok || overrides(theirNode, *node) {
+			wanted = append(wanted, nodeAddr)
+		}
+	}
+	n.nodeListMutex.RUnlock()
+	if len(push) == 0 && len(wanted) == 0 {
+		return
+	}
 
-	if err == nil {
-		// the gossip succeed, now merge node lists
-		log.Println("get node list from", ipAdr, port)
-		err = mergeNodeLists(newNodeList)
-		log.Printf("Gossip succeed, now merge two node lists.\n")
-	} else {
-		// if the gossip fail, it mean the target node is dead, so update the list
-		log.Println(nodeList[ipAdr + ":" + port].isOn)
-		err = turnOffNodeFromList(ipAdr, port)
-		currentPort, _ := strconv.Atoi(os.Args[1])
-		log.Printf("%v, Gossip failed, now remove %v:%v from current node list.\n", currentPort, ipAdr, port)
-		//log.Println(nodeList[ipAdr + ":" + port].isOn)
+	log.Printf("Gossip with %v: pushing %v entries, pulling %v\n", addr, len(push), len(wanted))
+	reqPay = &pb.KVRequest{
+		Command:  GOSSIP_SYNC,
+		NodeList: push,
+		Wanted:   wanted,
 	}
+	respPay, err = n.sendRequestAndWait(target, reqPay, gossipTimeout, gossipAttempts)
 	if err != nil {
-		log.Println(err)
+		log.Printf("Gossip with %v failed: %v\n", addr, err)
+		return
 	}
+	n.mergeNodeLists(nodeListParseFromByteArray(respPay.NodeList))
 }
 
-func doGossip() {
-	currentPort, _ := strconv.Atoi(os.Args[1])
-	log.Printf("Port # %v, Start gossiping", currentPort)
+// Handle the digest hash sent by a node starting a gossip round
+//
+// Returns:
+//		Nothing if the hash matches ours, our digest otherwise
+func (n *Node) handleGossipDigest(digestHash uint64) map[string][]byte {
+	digest, hash := n.getDigest()
+	if hash == digestHash {
+		return nil
+	}
+	return digest
+}
+
+// Handle the second message of a gossip round: merge the entries the
+// node pushed, and send back the ones it wants
+//
+// Arguments:
+//		reqPay: request with the pushed entries and the wanted addresses
+// Returns:
+//		The wanted entries
+func (n *Node) handleGossipSync(reqPay *pb.KVRequest) map[string][]byte {
+	n.mergeNodeLists(nodeListParseFromByteArray(reqPay.NodeList))
+
+	entries := make(map[string][]byte)
+	n.nodeListMutex.RLock()
+	defer n.nodeListMutex.RUnlock()
+	for _, addr := range reqPay.Wanted {
+		if node, ok := n.nodeList[addr]; ok {
+			entries[addr], _ = proto.Marshal(nodeToPb(*node))
+		}
+	}
+	return entries
+}
+
+func (n *Node) doGossip() {
+	log.Printf("Port # %v, Start gossiping", n.localPort)
 	// Randomly generate a list of listeners
 	var numListeners int
-	var activeNodeList = map[string]NodeVal{}
-	for key, node := range nodeList {
-		if node.isOn == true && (node.ipAdr != localIP || node.port != localPort) {
-			activeNodeList[key] = *node
+	var activeNodeList = []NodeVal{}
+	n.nodeListMutex.RLock()
+	for _, node := range n.nodeList {
+		if node.isOn == true && (node.ipAdr != n.localIP || node.port != n.localPort) {
+			activeNodeList = append(activeNodeList, *node)
 		}
 	}
+	n.nodeListMutex.RUnlock()
 
 	if len(activeNodeList) > 4 {
 		if len(activeNodeList)/5 < 4 {
@@ -154,65 +457,20 @@ func doGossip() {
 	log.Printf("The length of active node list is: %v\n", len(activeNodeList))
 	//log.Println( "node list:", nodeList)
 
-
-	var list = rand.Perm(len(activeNodeList))
-
-	var listenerList = map[string]NodeVal{}
-	i := 0
-	for key, val := range activeNodeList {
-		if i >= numListeners { break }
-		for _, j := range list {
-			if i == j {
-				listenerList[key] = val
-			}
-			i++
-		}
+	var listenerList = []NodeVal{}
+	for _, i := range rand.Perm(len(activeNodeList))[:numListeners] {
+		listenerList = append(listenerList, activeNodeList[i])
 	}
 
 	// request nodeList from listeners
 	for _, listener := range listenerList {
-		if listener.ipAdr == localIP && listener.port == localPort {
+		if listener.ipAdr == n.localIP && listener.port == n.localPort {
 			continue
 		}
-		requestNodeList(listener.ipAdr, listener.port)
+		n.gossipWith(listener)
 	}
 }
 
-func getGossipRequestMessage(messageID []byte) ([]byte, error) {
-	reqPayload := getGossipRequestPayload()
-	checksum := crc32.ChecksumIEEE(append(messageID, reqPayload...))
-	request := &protobuf.Msg{
-		MessageID: messageID,
-		Payload:   reqPayload,
-		CheckSum:  uint64(checksum),
-	}
-	reqMessage, err := proto.Marshal(request)
-	if err != nil {
-		log.Println(err)
-	}
-	return reqMessage, err
-}
-
-func getGossipRequestPayload() []byte {
-	requestPayload := &protobuf.KVRequest{
-		Command: GET_MEMBERSHIP_LIST,
-	}
-	reqPayload, err := proto.Marshal(requestPayload)
-	if err != nil {
-		log.Println(err)
-	}
-	return reqPayload
-}
-
-func getResponseMessage(reply []byte) ([]byte, []byte, uint64) {
-	replyMsg := &protobuf.Msg{}
-	err := proto.Unmarshal(reply, replyMsg)
-	if err != nil {
-		log.Println(err)
-	}
-	return replyMsg.GetMessageID(), replyMsg.GetPayload(), replyMsg.GetCheckSum()
-}
-
 func nodeListParseFromByteArray(nodeListPayload map[string][]byte) map[string]NodeVal {
 	var newNodeList = map[string]NodeVal{}
 	for addr, nodeByte := range nodeListPayload {
@@ -221,85 +479,19 @@ func nodeListParseFromByteArray(nodeListPayload map[string][]byte) map[string]No
 		if err != nil {
 			log.Println("nodeListParseFromByteArray error")
 		}
-		newNodeList[addr] = NodeVal{ipAdr: nodePb.IpAdr, port: nodePb.Port, isOn: nodePb.IsOn, membership: nodePb.Membership, time: nodePb.Time}
+		newNodeList[addr] = nodeFromPb(&nodePb)
 	}
 	return newNodeList
 }
 
-func sendReqAndGetRes(reqMessage []byte, msgID []byte, conn *net.UDPConn) (map[string]NodeVal, error) {
-	// Initialize timeout and times of retry
-	timeout := 100 // 100ms
-	attempts := 0
-
-	for attempts < 1 {
-		err := conn.SetReadDeadline(time.Now().Add(time.Millisecond * time.Duration(timeout)))
-		if err != nil {
-			log.Println(err)
-		}
-
-		// Send request message to the server
-		buf := reqMessage
-		_, err = conn.Write(buf)
-		if err != nil {
-			log.Println(err)
-		}
-
-		// Receive response from the server
-		buf = make([]byte, 65535) // clean the buffer
-		len, _, err := conn.ReadFrom(buf)
-		replyMsg := buf[0:len]
-		if err != nil {
-			// fmt.Println(err)
-			log.Println("retry: sendReqAndGetRes readFrom error!")
-			timeout = timeout * 2
-			attempts++
-		} else {
-			// check whether the checksum is corrrect or not
-			messageID, msgPayload, checksum := getResponseMessage(replyMsg)
-			expectedChecksum := uint64(crc32.ChecksumIEEE(append(messageID, msgPayload...)))
-			var resPayload = &protobuf.KVResponse{}
-			if checksum == expectedChecksum &&
-				hex.EncodeToString(messageID) == hex.EncodeToString(msgID) {
-				err := proto.Unmarshal(msgPayload, resPayload)
-				if err != nil {
-					log.Println(err)
-				}
-				return nodeListParseFromByteArray(resPayload.NodeList), nil
-				//return secretCode, nil
-			} else {
-				log.Println("retry: received wrong message")
-				// fmt.Println("Corrupt Data")
-				timeout = timeout * 2
-				attempts++
-			}
-		}
-	}
-	err := errors.New("All the retries fail")
-	log.Println(err)
-	return nil, err
-}
-
-// TODO: consider the situation where removed nodes can be readded
-func mergeNodeLists(newNodeList map[string]NodeVal) error {
+// Merge a node list received through gossip into ours. Nodes we
+// didn't know about are added, and for known nodes the newer state
+// wins.
+func (n *Node) mergeNodeLists(newNodeList map[string]NodeVal) {
 	log.Println("Start merging two node lists.")
-	for addr, _ := range nodeList {
-		if  _, v := newNodeList[addr]; v{
-		} else {
-			return errors.New("!")
-		}
-		if nodeList[addr].isOn != newNodeList[addr].isOn {
-			if nodeList[addr].time < newNodeList[addr].time {
-				if nodeList[addr].isOn == true && newNodeList[addr].isOn == false {
-					ip := nodeList[addr].ipAdr
-					port := nodeList[addr].port
-					foundDeadNode(ip, port)
-				}
-				*nodeList[addr] = newNodeList[addr]
-				log.Println("change node list:", addr)
-			}
-		}
+	for _, newNode := range newNodeList {
+		n.applyNodeUpdate(newNode, true)
 	}
-	return nil
 }
 
 func nodeExists(nodeList []NodeVal, node NodeVal) bool {
@@ -311,171 +503,38 @@ func nodeExists(nodeList []NodeVal, node NodeVal) bool {
 	return false
 }
 
-// Remove a target node from the nodeList
-// it also returns the new nodeList
-func turnOffNodeFromList(ipAdr string, port string) error {
-	addr := ipAdr + ":" + port
-	if val, ok := nodeList[addr]; ok {
-		val.isOn = false
-		val.time = uint64(time.Now().UnixNano())
-	} else {
-		log.Println("Error: can't turn off " + addr + ":" + port + "from node list, because it doesn't exist!")
-		return errors.New("turnOffNodeFromList error")
-	}
-	return nil
+// Check whether two NodeVals have the same address
+func sameNode(a NodeVal, b NodeVal) bool {
+	return a.ipAdr == b.ipAdr && a.port == b.port
 }
 
-func turnOnNodeFromList(msgId []byte) error {
+func (n *Node) turnOnNodeFromList(msgId []byte) error {
 	ip := net.IPv4(msgId[0],msgId[1],msgId[2],msgId[3]).String()
 	port := binary.LittleEndian.Uint16(msgId[4:6])
-	addr := ip + ":" + strconv.Itoa(int(port))
 	sentTime := binary.LittleEndian.Uint64(msgId[8:])
-	if val, ok := nodeList[addr]; ok {
-		val.isOn = true
-		val.time = sentTime
-	} else {
-		log.Println("Error: can't turn on " + addr + "from node list, because it doesn't exist!")
-		return errors.New("turnOnNodeFromList error")
-	}
+	// The sender's clock when it said hello is newer than any
+	// incarnation it had before restarting
+	node := NodeVal{ipAdr: ip, port: strconv.Itoa(int(port)), time: sentTime, incarnation: sentTime}
+	n.markNodeAlive(node)
 	return nil
 }
 
 
-func getNodeList() map[string]*NodeVal {
-	return nodeList
-}
-
-func checkAlive(ipAdr string, port string) bool {
-	log.Printf("Check is alive: %v:%v\n", ipAdr, port)
-	raddr, err := net.ResolveUDPAddr("udp", ipAdr+":"+port)
-	//log.Printf("%v:%v\n", raddr.IP, raddr.Port)
-	if err != nil {
-		log.Println("Fail to resolve address", err)
-		return false
+// Returns a copy of the node list
+func (n *Node) getNodeList() map[string]*NodeVal {
+	n.nodeListMutex.RLock()
+	defer n.nodeListMutex.RUnlock()
+	copied := make(map[string]*NodeVal, len(n.nodeList))
+	for addr, node := range n.nodeList {
+		entry := *node
+		copied[addr] = &entry
 	}
-
-	// Connect laddr with raddr
-	conn, err := net.DialUDP("udp", nil, raddr)
-	if err != nil {
-		//log.Printf("Dial fialed: %v:%v\n", raddr.IP, raddr.Port)
-		log.Println(err)
-		return false
-	}
-
-	// Defer closing the underlying file discriptor associated with the socket
-	defer conn.Close()
-
-	// Use UUIDs as messageID and convert String to byte array
-	uuid := uuid.NewString()
-	msgID := []byte(strings.ReplaceAll(uuid, "-", ""))
-
-	// Send isAlive message
-	reqMessage, err := getIsAliveRequestMessage(msgID)
-	// Send request and get respond
-	isAlive, err := getIsAliveRes(reqMessage, msgID, conn)
-
-	if err != nil {
-		log.Printf("%v:%v is dead.", ipAdr, port)
-		log.Println(err)
-		return false
-	}
-	return isAlive
+	return copied
 }
 
-func getIsAliveRequestMessage(messageID []byte) ([]byte, error) {
-	requestPayload := &protobuf.KVRequest{
-		Command: IS_ALIVE,
-	}
-	reqPayload, err := proto.Marshal(requestPayload)
-	if err != nil {
-		log.Println(err)
-	}
-	checksum := crc32.ChecksumIEEE(append(messageID, reqPayload...))
-	request := &protobuf.Msg{
-		MessageID: messageID,
-		Payload:   reqPayload,
-		CheckSum:  uint64(checksum),
-	}
-	reqMessage, err := proto.Marshal(request)
-	if err != nil {
-		log.Println(err)
-	}
-	return reqMessage, err
-}
-
-func getIsAliveRes(reqMessage []byte, msgID []byte, conn *net.UDPConn) (bool, error) {
-	// Initialize timeout and times of retry
-	timeout := 100 // 100ms
-	attempts := 0
-
-	for attempts < 1 {
-		err := conn.SetReadDeadline(time.Now().Add(time.Millisecond * time.Duration(timeout)))
-		if err != nil {
-			log.Println(err)
-		}
-
-		// Send request message to the server
-		buf := reqMessage
-		_, err = conn.Write(buf)
-		if err != nil {
-			log.Println(err)
-		}
-
-		// Receive response from the server
-		buf = make([]byte, 65535) // clean the buffer
-		len, _, err := conn.ReadFrom(buf)
-		replyMsg := buf[0:len]
-		if err != nil {
-			log.Println("retry: getIsAliveRes readFrom error!")
-			timeout = timeout * 2
-			attempts++
-		} else {
-			// check whether the checksum is corrrect or not
-			messageID, msgPayload, checksum := getResponseMessage(replyMsg)
-			expectedChecksum := uint64(crc32.ChecksumIEEE(append(messageID, msgPayload...)))
-			var resPayload = &protobuf.KVResponse{}
-			if checksum == expectedChecksum &&
-				hex.EncodeToString(messageID) == hex.EncodeToString(msgID) {
-				err := proto.Unmarshal(msgPayload, resPayload)
-				if err != nil {
-					log.Println(err)
-				}
-				return true, nil
-			} else {
-				log.Println("retry: received wrong message")
-				// fmt.Println("Corrupt Data")
-				timeout = timeout * 2
-				attempts++
-			}
-		}
-	}
-	err := errors.New("Tried to check isAlive, but all the retries failed")
-	log.Println(err)
-	return false, err
-}
-
-func receiveHello(addr *net.UDPAddr, mesId []byte) {
+func (n *Node) receiveHello(addr *net.UDPAddr, mesId []byte) {
 	log.Println("Receive hello from: "+addr.IP.String()+":", addr.Port)
-	//modify nodelist
-	turnOnNodeFromList(mesId)
-
-	//update hashring
-	consistent.addNodetoHashring(mesId)
-
-	//replicate
-	port := strconv.Itoa(addr.Port)
-	welcomeNewNode(*nodeList[addr.IP.String()+":"+port])
+	//modify nodelist, update hashring and replicate
+	n.turnOnNodeFromList(mesId)
 }
 
-func foundDeadNode(ip string, port string) {
-
-	//modify nodelist
-	// turnOffNodeFromList(ip, port)
-
-	//replicate
-	addr := ip + ":" + port
-	nodeDieReplicate(*nodeList[addr])
-
-	//update hashring
-	consistent.removeNodefromHashring(ip, port)
-}
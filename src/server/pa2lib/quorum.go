@@ -0,0 +1,103 @@
+package pa2lib
+
+import (
+	"log"
+	"net"
+)
+
+// Values of the minority_mode config key: what a node that can't see a
+// majority of the cluster still serves
+const (
+	MINORITY_READ_ONLY  = "read-only"
+	MINORITY_REJECT_ALL = "reject-all"
+	MINORITY_ALLOW      = "allow"
+)
+
+// Mode reported while a node sees a majority of the cluster
+const MODE_NORMAL = "normal"
+
+// Check whether this node sees a majority of the configured cluster
+// size. Suspected nodes don't count, since they may be on the other side
+// of a partition. Always true when the cluster size isn't configured.
+func (n *Node) hasQuorum() bool {
+	if n.config.ClusterSize == 0 {
+		return true
+	}
+	alive, _, _, _ := n.countMembers()
+	return alive > n.config.ClusterSize/2
+}
+
+// Get the mode this node is in
+//
+// Returns:
+//		MODE_NORMAL if it sees a majority of the cluster, the configured
+//		minority mode otherwise
+func (n *Node) clusterMode() string {
+	if n.hasQuorum() || n.config.MinorityMode == MINORITY_ALLOW {
+		return MODE_NORMAL
+	}
+	return n.config.MinorityMode
+}
+
+func isWriteCommand(cmd uint32) bool {
+	switch cmd {
+	case PUT, REMOVE, WIPEOUT, EXPIRE, INCR, PUT_IF, PUT_FORWARD, REMOVE_FORWARD, EXPIRE_FORWARD, INCR_FORWARD, PUT_IF_FORWARD,
+		PUT_FALLBACK, REMOVE_FALLBACK, HINT_PUT, HINT_REMOVE:
+		return true
+	}
+	return false
+}
+
+func isReadCommand(cmd uint32) bool {
+	return cmd == GET || cmd == GET_FORWARD || cmd == GET_FALLBACK
+}
+
+// Check whether a command is refused because this node is on the
+// minority side of a partition
+//
+// Arguments:
+//		cmd: command of the request
+// Returns:
+//		The mode of the node, and true if the command is refused
+func (n *Node) fencedCommand(cmd uint32) (string, bool) {
+	if !isWriteCommand(cmd) && !isReadCommand(cmd) {
+		return "", false
+	}
+
+	mode := n.clusterMode()
+	switch mode {
+	case MINORITY_READ_ONLY:
+		return mode, isWriteCommand(cmd)
+	case MINORITY_REJECT_ALL:
+		return mode, true
+	}
+	return mode, false
+}
+
+// Get the address to answer a client command to, which a forwarded
+// command carries
+func clientAddrOf(cmd uint32, clientAddr *net.UDPAddr, addr []byte) *net.UDPAddr {
+	if isForwardedCommand(cmd) {
+		forwardedAddr, err := net.ResolveUDPAddr("udp", string(addr))
+		if err == nil {
+			return forwardedAddr
+		}
+	}
+	return clientAddr
+}
+
+// Log when the node loses or gets back a majority
+func (n *Node) logModeChange(event MembershipEvent) {
+	mode := n.clusterMode()
+	n.currentModeMutex.Lock()
+	defer n.currentModeMutex.Unlock()
+	if mode == n.currentMode {
+		return
+	}
+	if mode == MODE_NORMAL {
+		log.Println("Majority of the cluster visible again, back to normal mode")
+	} else {
+		log.Printf("Only a minority of the %v nodes visible, switching to %v mode\n", n.config.ClusterSize, mode)
+	}
+	n.currentMode = mode
+}
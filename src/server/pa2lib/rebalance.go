@@ -0,0 +1,303 @@
+package pa2lib
+
+import (
+	"log"
+	pb "pa2/pb/protobuf"
+	"sync"
+	"time"
+)
+
+// States the rebalancer can be in
+const (
+	REBALANCE_IDLE    = "idle"
+	REBALANCE_RUNNING = "running"
+	REBALANCE_PAUSED  = "paused"
+)
+
+// Time to wait for a migrated key to be acknowledged by its new owner
+const migrateTimeout = 100 * time.Millisecond
+const migrateAttempts = 3
+
+// A hash range (start, end] whose keys have to be moved to target
+type rebalanceRange struct {
+	start  uint32
+	end    uint32
+	target NodeVal
+}
+
+// Background job moving keys to the nodes that now own them
+type Rebalancer struct {
+	node            *Node
+	pending         []rebalanceRange
+	running         bool
+	paused          bool
+	canceled        bool
+	decommissioning bool
+	keysMoved       uint64
+	bytesMoved      uint64
+	wake            chan bool
+	// Held while a key is handed off during a decommission, and by the
+	// writes copied to the son meanwhile
+	handoffMutex sync.Mutex
+	sync.Mutex
+}
+
+// Token bucket limiting how fast something may happen. A rate of
+// zero means unlimited.
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// Blocks until n tokens are available and takes them. Requests bigger
+// than the bucket are allowed but put the bucket in debt.
+func (r *rateLimiter) wait(n float64) {
+	if r.rate <= 0 {
+		return
+	}
+
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+
+	r.tokens -= n
+	if r.tokens < 0 {
+		time.Sleep(time.Duration(-r.tokens / r.rate * float64(time.Second)))
+	}
+}
+
+// Check whether a hash lies in the ring range (start, end]
+func inHashRange(hash uint32, start uint32, end uint32) bool {
+	if start < end {
+		return start < hash && hash <= end
+	}
+	// The range wraps around the top of the ring, or covers the
+	// whole ring when start == end
+	return hash > start || hash <= end
+}
+
+// Queue a range of keys to be moved to another node. The target is
+// told first, so it reads from this node until the range is done.
+//
+// Arguments:
+//		start: hash the range starts after
+//		end: last hash of the range
+//		target: node that owns the range now
+func (n *Node) scheduleRebalance(start uint32, end uint32, target NodeVal) {
+	rng := rebalanceRange{start: start, end: end, target: target}
+	n.notifyMigration(MIGRATE_BEGIN, rng)
+
+	n.rebalancer.Lock()
+	n.rebalancer.pending = append(n.rebalancer.pending, rng)
+	n.rebalancer.Unlock()
+	log.Printf("Scheduled rebalance of (%v, %v] to %v:%v\n", start, end, target.ipAdr, target.port)
+
+	select {
+	case n.rebalancer.wake <- true:
+	default:
+	}
+}
+
+// Takes the next range to move, or returns false if there is none
+func (r *Rebalancer) next() (rebalanceRange, bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.canceled = false
+	if len(r.pending) == 0 {
+		r.running = false
+		return rebalanceRange{}, false
+	}
+	rng := r.pending[0]
+	r.pending = r.pending[1:]
+	r.running = true
+	return rng, true
+}
+
+// Check whether the range being moved was canceled
+func (r *Rebalancer) isCanceled() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.canceled
+}
+
+// Waits while the rebalancer is paused
+//
+// Returns:
+//		False if the current range was canceled, true otherwise
+func (r *Rebalancer) checkpoint() bool {
+	for {
+		r.Lock()
+		paused, canceled := r.paused, r.canceled
+		r.Unlock()
+		if canceled {
+			return false
+		}
+		if !paused {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Moves every key of a range to its target. Keys that no longer belong
+// to the target (for example because the ring changed again) are left
+// alone.
+//
+// Returns:
+//		False if a key could not be moved and the range should be retried
+func (r *Rebalancer) moveRange(rng rebalanceRange, ops *rateLimiter, bandwidth *rateLimiter) bool {
+	var KVPairs []StoreVal
+	r.node.mutex.Lock()
+	for _, KVPair := range r.node.KVStore {
+		if inHashRange(hashKeyfromKey(KVPair.key), rng.start, rng.end) {
+			KVPairs = append(KVPairs, KVPair)
+		}
+	}
+	r.node.mutex.Unlock()
+
+	for _, KVPair := range KVPairs {
+		if !r.checkpoint() {
+			log.Println("Rebalance of range canceled")
+			return true
+		}
+
+		owner, isMine := r.node.checkNode(KVPair.key)
+		if isMine || !sameNode(owner, rng.target) {
+			continue
+		}
+
+		// Send the current value, the key may have changed or been
+		// removed since the range was listed
+		value, version, errCode := r.node.Get(KVPair.key)
+		if errCode != NO_ERR {
+			continue
+		}
+		KVPair.value, KVPair.version = value, version
+
+		size := len(KVPair.key) + len(KVPair.value) + 4
+		ops.wait(1)
+		bandwidth.wait(float64(size))
+
+		reqPay := &pb.KVRequest{
+			Command: MIGRATE_PUT,
+			Key:     KVPair.key,
+			Value:   KVPair.value,
+			Version: KVPair.version,
+		}
+		respPay, err := r.node.sendRequestAndWait(rng.target, reqPay, migrateTimeout, migrateAttempts)
+		if err != nil || respPay.ErrCode != NO_ERR {
+			log.Println("Failed to migrate key to", rng.target.ipAdr+":"+rng.target.port, err)
+			return false
+		}
+
+		// Keep the key as a replica, the new owner is now our father.
+		// Writes made since the key was read also went to the new
+		// owner, which keeps its own copy, and a key removed meanwhile
+		// is remembered there so our copy was not stored.
+		r.node.moveToReplica(KVPair.key, 0)
+
+		r.Lock()
+		r.keysMoved++
+		r.bytesMoved += uint64(size)
+		r.Unlock()
+	}
+	return true
+}
+
+// Loops forever moving the queued ranges to their new owners, limited
+// by the configured bandwidth and ops/sec. Should be called as a
+// goroutine so it can run in the background
+func (n *Node) RebalanceWorker() {
+	ops := newRateLimiter(float64(n.config.RebalanceOpsPerSec))
+	bandwidth := newRateLimiter(float64(n.config.RebalanceBytesPerSec))
+
+	for {
+		rng, ok := n.rebalancer.next()
+		if !ok {
+			select {
+			case <-n.rebalancer.wake:
+			case <-n.stopped:
+				return
+			}
+			continue
+		}
+
+		if !n.rebalancer.moveRange(rng, ops, bandwidth) {
+			// Put the range back and give the target some time
+			n.rebalancer.Lock()
+			n.rebalancer.pending = append(n.rebalancer.pending, rng)
+			n.rebalancer.Unlock()
+			if !n.sleep(time.Second) {
+				return
+			}
+		} else {
+			// A canceled range is done too, the keys not moved yet stay
+			// here and the target stops reading them from this node
+			n.notifyMigration(MIGRATE_DONE, rng)
+		}
+	}
+}
+
+// Returns the progress of the rebalancer
+func (n *Node) GetRebalanceStatus() *pb.RebalanceStatus {
+	n.rebalancer.Lock()
+	defer n.rebalancer.Unlock()
+
+	status := &pb.RebalanceStatus{
+		State:            REBALANCE_IDLE,
+		RangesPending:    uint32(len(n.rebalancer.pending)),
+		KeysMoved:        n.rebalancer.keysMoved,
+		BytesMoved:       n.rebalancer.bytesMoved,
+		BytesPerSecLimit: n.config.RebalanceBytesPerSec,
+		OpsPerSecLimit:   n.config.RebalanceOpsPerSec,
+	}
+	if n.rebalancer.running {
+		status.RangesPending++
+		status.State = REBALANCE_RUNNING
+	}
+	if n.rebalancer.decommissioning {
+		status.State = REBALANCE_DECOMMISSIONING
+	}
+	if n.rebalancer.paused {
+		status.State = REBALANCE_PAUSED
+	}
+	return status
+}
+
+// Pauses or resumes the rebalancer
+func (n *Node) SetRebalancePaused(paused bool) uint32 {
+	n.rebalancer.Lock()
+	n.rebalancer.paused = paused
+	n.rebalancer.Unlock()
+	log.Println("Rebalance paused:", paused)
+	return NO_ERR
+}
+
+// Drops every pending range and stops the one being moved, as well as
+// a running decommission. Keys that were not moved yet stay on this node,
+// and the targets are told the dropped ranges are done so they stop
+// reading from it.
+func (n *Node) CancelRebalance() uint32 {
+	n.rebalancer.Lock()
+	dropped := n.rebalancer.pending
+	n.rebalancer.pending = nil
+	n.rebalancer.canceled = n.rebalancer.running
+	n.rebalancer.decommissioning = false
+	n.rebalancer.Unlock()
+	log.Println("Rebalance canceled")
+
+	go func() {
+		for _, rng := range dropped {
+			n.notifyMigration(MIGRATE_DONE, rng)
+		}
+	}()
+	return NO_ERR
+}
@@ -0,0 +1,35 @@
+package pa2lib
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInHashRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		hash       uint32
+		start, end uint32
+		want       bool
+	}{
+		{"inside", 15, 10, 20, true},
+		{"start is excluded", 10, 10, 20, false},
+		{"end is included", 20, 10, 20, true},
+		{"before", 5, 10, 20, false},
+		{"after", 25, 10, 20, false},
+		{"wrapping, above start", math.MaxUint32, 20, 10, true},
+		{"wrapping, below end", 0, 20, 10, true},
+		{"wrapping, end is included", 10, 20, 10, true},
+		{"wrapping, start is excluded", 20, 20, 10, false},
+		{"wrapping, between end and start", 15, 20, 10, false},
+		{"whole ring", 7, 10, 10, true},
+		{"whole ring at its start", 10, 10, 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inHashRange(tt.hash, tt.start, tt.end); got != tt.want {
+				t.Fatalf("inHashRange(%d, %d, %d) = %v, want %v", tt.hash, tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
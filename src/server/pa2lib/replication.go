@@ -10,10 +10,10 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
-func sendNormalReplicateRequest(cmd uint32, key []byte, value []byte, version int32, addr *net.UDPAddr){
+func (n *Node) sendNormalReplicateRequest(cmd uint32, key []byte, value []byte, version int32, addr *net.UDPAddr){
 	//skip if receiver is myself
-	port, _ := strconv.Atoi(localPort)
-	if addr.IP.String() == localIP && addr.Port == port{
+	port, _ := strconv.Atoi(n.localPort)
+	if addr.IP.String() == n.localIP && addr.Port == port{
 		return
 	}
 
@@ -29,7 +29,7 @@ func sendNormalReplicateRequest(cmd uint32, key []byte, value []byte, version in
 		fmt.Println("Failed to encode req message:", err)
 	}
 
-	MID := generateUniqueMsgID(addr.IP, addr.Port)
+	MID := generateLocalMsgID(addr.IP, addr.Port)
 	msg := pb.Msg{
 		MessageID: MID,
 		Payload: marshaledRequest,
@@ -38,17 +38,16 @@ func sendNormalReplicateRequest(cmd uint32, key []byte, value []byte, version in
 
 	marshaledReqMsg, err := proto.Marshal(&msg)
 
-	_, err = conn.WriteToUDP(marshaledReqMsg, addr)
-	log.Println("sendNormalReplicateRequest", addr.IP, addr.Port)
+	err = n.sendOneWay(addr.String(), marshaledReqMsg)
 	if err != nil {
 		fmt.Println("could not write to the correct node", err)
 	}
 }
 
 //This function should be called whenever the node's KV store has been changed
-func normalReplicate(cmd uint32, key []byte, value []byte, version int32, node NodeVal){
+func (n *Node) normalReplicate(cmd uint32, key []byte, value []byte, version int32, node NodeVal){
 	//to son
-	son := consistent.getNextNode(node)
+	son := n.consistent.getNextNode(node)
 	port, _ := strconv.Atoi(son.port)
 
 	sonAddr := net.UDPAddr{
@@ -56,10 +55,10 @@ func normalReplicate(cmd uint32, key []byte, value []byte, version int32, node N
 		IP:   net.ParseIP(son.ipAdr),
 	}
 
-	sendNormalReplicateRequest(cmd + 0x25, key, value, version, &sonAddr)
+	n.sendNormalReplicateRequest(cmd + 0x25, key, value, version, &sonAddr)
 
 	//to grandson
-	grandson := consistent.getNextNode(son)
+	grandson := n.consistent.getNextNode(son)
 	port, _ = strconv.Atoi(grandson.port)
 
 	grandsonAddr := net.UDPAddr{
@@ -67,182 +66,100 @@ func normalReplicate(cmd uint32, key []byte, value []byte, version int32, node N
 		IP:   net.ParseIP(grandson.ipAdr),
 	}
 
-	sendNormalReplicateRequest(cmd + 0x26, key, value, version, &grandsonAddr)
+	n.sendNormalReplicateRequest(cmd + 0x26, key, value, version, &grandsonAddr)
 }
 
-func notifyLowerNodeDie(cmd uint32, addr *net.UDPAddr, dstAddr *net.UDPAddr){
-	req := pb.KVRequest{
-		Command: cmd,
-		Addr: []byte(dstAddr.String()),
-	}
-
-	marshaledRequest, err := proto.Marshal(&req)
-	if err != nil {
-		fmt.Println("Failed to encode req message:", err)
-	}
-
-	MID := generateUniqueMsgID(addr.IP, addr.Port)
-	msg := pb.Msg{
-		MessageID: MID,
-		Payload: marshaledRequest,
-		CheckSum: getChecksum(MID, marshaledRequest),
-	}
-
-	marshaledReqMsg, err := proto.Marshal(&msg)
-
-	_, err = conn.WriteToUDP(marshaledReqMsg, addr)
-	log.Println("notifyLowerNodeDie", addr.IP, addr.Port)
-	if err != nil {
-		fmt.Println("could not write to the correct node", err)
+//This function should be called after the dead node left the hashring.
+//The son of the dead node owns its keys now and promotes its replicas of
+//them. The son and the two nodes before the dead one got a new son or
+//grandson, or more keys, and copy their keys to their son and grandson.
+func (n *Node) nodeDieReplicate(node NodeVal){
+	if promoted := n.promoteReplicas(); promoted > 0 {
+		log.Println("Took over", promoted, "keys of dead node", node.ipAdr+":"+node.port)
 	}
-}
-
-func nodeDieReplicate(node NodeVal){
-	father := consistent.getLastNode(node)
-	grandfather := consistent.getLastNode(father)
-	son := consistent.getNextNode(node)
-	grandson := consistent.getNextNode(son)
+	n.pruneReplicas()
 
-	port, _ := strconv.Atoi(grandfather.port)
-
-	grandfatherAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(grandfather.ipAdr),
-	}
-
-	port, _ = strconv.Atoi(father.port)
-
-	fatherAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(father.ipAdr),
-	}
-
-	port, _ = strconv.Atoi(son.port)
-
-	sonAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(son.ipAdr),
-	}
-
-	port, _ = strconv.Atoi(grandson.port)
-
-	grandsonAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(grandson.ipAdr),
-	}
-
-	//to grandfather
-	notifyLowerNodeDie(GRANDSON_DIED, &grandfatherAddr, &sonAddr)
-
-	//to father
-	notifyLowerNodeDie(SON_DIED, &fatherAddr, &grandsonAddr)
-}
-
-//set repreq to node at addr with KVPairs
-func sendNodeDieReplicateRequest(cmd uint32, KVPairs []StoreVal, addr *net.UDPAddr){
-	//skip if receiver is myself
-	port, _ := strconv.Atoi(localPort)
-	if addr.IP.String() == localIP && addr.Port == port{
+	father, son := n.consistent.getNeighbors(hashKey(node.ipAdr, node.port))
+	grandfather := n.consistent.getLastNode(father)
+	self := n.localNode()
+	if !sameNode(self, son) && !sameNode(self, father) && !sameNode(self, grandfather) {
 		return
 	}
+	n.replicateStoreTo(n.successor(1), HANDOFF_REPLICATE_SON)
+	n.replicateStoreTo(n.successor(2), HANDOFF_REPLICATE_GRANDSON)
+}
 
-	 notification := pb.RepRequest{
-		Command: cmd,
-	 }
-
-	for _, kv := range KVPairs{
-		newKVPair := pb.RepRequest_KVPair{
-			Key: kv.key,
-			Value: kv.value,
-			Version: kv.version,
+// Move the replicas of the keys this node owns into the KVStore. A key
+// already in the KVStore was written since, and keeps its value.
+//
+// Returns:
+//		The number of keys moved
+func (n *Node) promoteReplicas() int {
+	promoted := 0
+	for flag := 0; flag < len(n.repKVStore); flag++ {
+		n.mutex.Lock()
+		replicas := append([]StoreVal{}, n.repKVStore[flag]...)
+		n.mutex.Unlock()
+
+		for _, KVPair := range replicas {
+			if _, owned := n.checkNode(KVPair.key); !owned {
+				continue
+			}
+			version := KVPair.version
+			if n.PutIfAbsent(KVPair.key, KVPair.value, &version) == NO_ERR {
+				promoted++
+			}
+			n.RemoveReplicate(KVPair.key, flag)
 		}
-		notification.Kvs = append(notification.Kvs, &newKVPair)
-	}
-
-	marshaledNotification, err := proto.Marshal(&notification)
-	if err != nil {
-		fmt.Println("Failed to encode req message:", err)
-	}
-
-	_, err = conn.WriteToUDP(marshaledNotification, addr)
-	log.Println("sendNodeDieReplicateRequest", addr.IP, addr.Port)
-	if err != nil {
-		fmt.Println("could not write to the correct node", err)
 	}
+	return promoted
 }
 
-func onFatherDie(KVPairs []*pb.RepRequest_KVPair){
-	//append repKVStore[0] to KVStore
-	for _, KVPair := range repKVStore[0]{
-		KVStore = append(KVStore, KVPair)
-	}
-
-	//move repKVStore[1] to repKVStore[0]
-	repKVStore[0] = nil
-	for _, KVPair := range repKVStore[1]{
-		repKVStore[0] = append(repKVStore[0], KVPair)
-	}
-
-	//Copy KVPairs from the message to repKVStore[1]
-	for _, KVPair := range KVPairs{
-		storeVal := StoreVal{key: KVPair.Key, value: KVPair.Value, version: KVPair.Version}
-		repKVStore[1] = append(repKVStore[1], storeVal)
-	}
-
-	son := consistent.getNextNode(*nodeList[localIP+":"+localPort])
-
-	port, _ := strconv.Atoi(son.port)
-
-	sonAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(son.ipAdr),
+// Drop the replicas of keys whose owner no longer has this node as its
+// son, for store 0, or as its grandson, for store 1
+func (n *Node) pruneReplicas() {
+	self := n.localNode()
+	for flag := 0; flag < len(n.repKVStore); flag++ {
+		n.mutex.Lock()
+		keys := storeKeys(n.repKVStore[flag])
+		n.mutex.Unlock()
+
+		for _, key := range keys {
+			holder := n.consistent.getNode(key)
+			for i := 0; i <= flag; i++ {
+				holder = n.consistent.getNextNode(holder)
+			}
+			if !sameNode(holder, self) {
+				n.RemoveReplicate(key, flag)
+			}
+		}
 	}
-
-	sendNodeDieReplicateRequest(GRANDFATHER_DIED_2, KVStore, &sonAddr)
 }
 
-//store kvs in req into repKVStore[1]
-func ReplicateFromGrandFather(KVPairs []*pb.RepRequest_KVPair){
-	//Copy KVPairs from the message to repKVStore[1]
-	for _, KVPair := range KVPairs{
-		storeVal := StoreVal{key: KVPair.Key, value: KVPair.Value, version: KVPair.Version}
-		repKVStore[1] = append(repKVStore[1], storeVal)
+// Copy every key this node owns to a node, with the value it has when it
+// is sent. Stops at the first key the node doesn't acknowledge.
+//
+// Arguments:
+//		node: node to copy the keys to
+//		cmd: HANDOFF_REPLICATE_SON or HANDOFF_REPLICATE_GRANDSON
+func (n *Node) replicateStoreTo(node NodeVal, cmd uint32) {
+	if sameNode(node, n.localNode()) {
+		return
 	}
-}
+	n.mutex.Lock()
+	keys := storeKeys(n.KVStore)
+	n.mutex.Unlock()
 
-////store kvs in req into repKVStore[0]
-func ReplicateFromFather(KVPairs []*pb.RepRequest_KVPair){
-	//Copy KVPairs from the message to repKVStore[0]
-	for _, KVPair := range KVPairs{
-		storeVal := StoreVal{key: KVPair.Key, value: KVPair.Value, version: KVPair.Version}
-		repKVStore[0] = append(repKVStore[0], storeVal)
+	for _, key := range keys {
+		value, version, errCode := n.Get(key)
+		if errCode != NO_ERR {
+			// Removed since
+			continue
+		}
+		reqPay := &pb.KVRequest{Command: cmd, Key: key, Value: value, Version: version}
+		if _, err := n.sendRequestAndWait(node, reqPay, migrateTimeout, migrateAttempts); err != nil {
+			log.Println("Failed to replicate keys to", node.ipAdr+":"+node.port, err)
+			return
+		}
 	}
 }
-
-//copy KVPairs in request to KVStore
-func ReplicateFromSon(KVPairs []*pb.RepRequest_KVPair){
-	for _, KVPair := range KVPairs{
-		storeVal := StoreVal{key: KVPair.Key, value: KVPair.Value, version: KVPair.Version}
-		KVStore = append(KVStore, storeVal)
-	}
-
-	son := consistent.getNextNode(*nodeList[localIP+":"+localPort])
-
-	port, _ := strconv.Atoi(son.port)
-
-	sonAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(son.ipAdr),
-	}
-
-	sendNodeDieReplicateRequest(I_AM_YOUR_FATHER, KVStore, &sonAddr)
-
-	grandson := consistent.getNextNode(son)
-	port, _ = strconv.Atoi(grandson.port)
-
-	grandsonAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(grandson.ipAdr),
-	}
-	sendNodeDieReplicateRequest(I_AM_YOUR_GRANDFATHER, KVStore, &grandsonAddr)
-}
\ No newline at end of file
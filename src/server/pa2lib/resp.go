@@ -0,0 +1,437 @@
+package pa2lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	pb "pa2/pb/protobuf"
+	"strconv"
+	"strings"
+)
+
+// Largest bulk string and number of arguments accepted in a RESP command
+const maxRESPBulkBytes = 1 << 20
+const maxRESPArgs = 1 << 16
+
+// Version reported by INFO to the clients checking for features
+const respRedisVersion = "7.0.0"
+
+var errRESPProtocol = errors.New("Protocol error")
+
+// Read a line of RESP, without its CRLF
+func readRESPLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// Read a command: an array of bulk strings as sent by the clients, or an
+// inline command as typed in telnet
+//
+// Returns:
+//		The name and arguments of the command, empty for an empty line
+func readRESPCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxRESPArgs {
+		return nil, errRESPProtocol
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		header, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) == 0 || header[0] != '$' {
+			return nil, errRESPProtocol
+		}
+		size, err := strconv.Atoi(string(header[1:]))
+		if err != nil || size < 0 || size > maxRESPBulkBytes {
+			return nil, errRESPProtocol
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, errRESPProtocol
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+func writeRESPSimple(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func writeRESPError(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "-%s\r\n", s)
+}
+
+func writeRESPInt(w *bufio.Writer, n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+// Write a bulk string, the null bulk string for nil
+func writeRESPBulk(w *bufio.Writer, b []byte) {
+	if b == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(w, "$%d\r\n", len(b))
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func writeRESPArray(w *bufio.Writer, n int) {
+	fmt.Fprintf(w, "*%d\r\n", n)
+}
+
+// Get the RESP error reply of an error code, with the prefixes Redis
+// uses for the same conditions
+func respErrorOf(respPay *pb.KVResponse) string {
+	switch respPay.ErrCode {
+	case INVALID_KEY_ERR:
+		return fmt.Sprintf("ERR invalid key, keys are at most %d bytes", maxKeyLengthBytes)
+	case INVALID_VAL_ERR:
+		return fmt.Sprintf("ERR invalid value, values are at most %d bytes", maxValLengthBytes)
+	case NO_SPC_ERR:
+		return "OOM " + errCodeMessage(NO_SPC_ERR)
+	case SYS_OVERLOAD_ERR:
+		return fmt.Sprintf("BUSY %s, retry in %d ms", errCodeMessage(SYS_OVERLOAD_ERR), respPay.OverloadWaitTime)
+	case NO_QUORUM_ERR:
+		return fmt.Sprintf("CLUSTERDOWN %s, node is in %s mode", errCodeMessage(NO_QUORUM_ERR), respPay.ClusterMode)
+	}
+	return "ERR " + errCodeMessage(respPay.ErrCode)
+}
+
+// A connection of a Redis client
+type respClient struct {
+	node *Node
+	addr *net.UDPAddr
+	w    *bufio.Writer
+}
+
+// Make a request for the client
+//
+// Arguments:
+//		reqPay: request payload
+//		allowed: error codes returned to the caller instead of replied
+// Returns:
+//		The response payload, nil if an error reply was written
+func (c *respClient) call(reqPay *pb.KVRequest, allowed ...uint32) *pb.KVResponse {
+	respPay, err := c.node.callLocal(context.Background(), c.addr, reqPay)
+	if err != nil {
+		writeRESPError(c.w, "ERR "+err.Error())
+		return nil
+	}
+	if respPay.ErrCode == NO_ERR {
+		return respPay
+	}
+	for _, errCode := range allowed {
+		if respPay.ErrCode == errCode {
+			return respPay
+		}
+	}
+	writeRESPError(c.w, respErrorOf(respPay))
+	return nil
+}
+
+// Parse the time to live options of a SET
+//
+// Returns:
+//		The time to live in milliseconds, 0 for none, and false if the
+//		options are invalid
+func parseSetOptions(args [][]byte) (uint64, bool) {
+	var ttlMs uint64
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if (option != "EX" && option != "PX") || i+1 == len(args) || ttlMs != 0 {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		ttlMs = uint64(n)
+		if option == "EX" {
+			ttlMs *= 1000
+		}
+		i++
+	}
+	return ttlMs, true
+}
+
+// Get the INFO reply: a few fields about the node and the cluster
+func (n *Node) respInfo() string {
+	alive, suspect, dead, _ := n.countMembers()
+	n.mutex.Lock()
+	keys := len(n.KVStore)
+	n.mutex.Unlock()
+	n.expiriesMutex.Lock()
+	expiring := len(n.expiries)
+	n.expiriesMutex.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Server\r\nredis_version:%s\r\nprocess_id:%d\r\ntcp_port:%d\r\n", respRedisVersion, os.Getpid(), n.config.RedisPort)
+	fmt.Fprintf(&b, "\r\n# Cluster\r\ncluster_mode:%s\r\nring_size:%d\r\n", n.clusterMode(), len(n.consistent.getRingNodes()))
+	fmt.Fprintf(&b, "members_alive:%d\r\nmembers_suspect:%d\r\nmembers_dead:%d\r\n", alive, suspect, dead)
+	fmt.Fprintf(&b, "\r\n# Keyspace\r\ndb0:keys=%d,expires=%d\r\n", keys, expiring)
+	return b.String()
+}
+
+// Run a command of a Redis client and write its reply
+//
+// Arguments:
+//		args: name and arguments of the command
+// Returns:
+//		True if the client asked to close the connection
+func (c *respClient) handle(args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	args = args[1:]
+	wrongArgs := func() {
+		writeRESPError(c.w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+
+	switch name {
+	case "ping":
+		switch len(args) {
+		case 0:
+			writeRESPSimple(c.w, "PONG")
+		case 1:
+			writeRESPBulk(c.w, args[0])
+		default:
+			wrongArgs()
+		}
+
+	case "get":
+		if len(args) != 1 {
+			wrongArgs()
+			break
+		}
+		if respPay := c.call(&pb.KVRequest{Command: GET, Key: args[0]}, KEY_DNE_ERR); respPay != nil {
+			if respPay.ErrCode == KEY_DNE_ERR {
+				writeRESPBulk(c.w, nil)
+			} else {
+				writeRESPBulk(c.w, append([]byte{}, respPay.Value...))
+			}
+		}
+
+	case "set":
+		if len(args) < 2 {
+			wrongArgs()
+			break
+		}
+		ttlMs, ok := parseSetOptions(args[2:])
+		if !ok {
+			writeRESPError(c.w, "ERR syntax error")
+			break
+		}
+		if c.call(&pb.KVRequest{Command: PUT, Key: args[0], Value: args[1], TtlMs: ttlMs}) != nil {
+			writeRESPSimple(c.w, "OK")
+		}
+
+	case "del", "exists":
+		if len(args) == 0 {
+			wrongArgs()
+			break
+		}
+		cmd := uint32(REMOVE)
+		if name == "exists" {
+			cmd = GET
+		}
+		var count int64
+		for _, key := range args {
+			respPay := c.call(&pb.KVRequest{Command: cmd, Key: key}, KEY_DNE_ERR)
+			if respPay == nil {
+				return false
+			}
+			if respPay.ErrCode == NO_ERR {
+				count++
+			}
+		}
+		writeRESPInt(c.w, count)
+
+	case "incr":
+		if len(args) != 1 {
+			wrongArgs()
+			break
+		}
+		respPay := c.call(&pb.KVRequest{Command: INCR, Key: args[0], Delta: 1}, INVALID_VAL_ERR)
+		if respPay == nil {
+			break
+		}
+		n, err := strconv.ParseInt(string(respPay.Value), 10, 64)
+		if respPay.ErrCode == INVALID_VAL_ERR || err != nil {
+			writeRESPError(c.w, "ERR value is not an integer or out of range")
+			break
+		}
+		writeRESPInt(c.w, n)
+
+	case "expire":
+		if len(args) != 2 {
+			wrongArgs()
+			break
+		}
+		seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			writeRESPError(c.w, "ERR value is not an integer or out of range")
+			break
+		}
+		// Like Redis, a time to live that already ran out removes the key
+		reqPay := &pb.KVRequest{Command: EXPIRE, Key: args[0], TtlMs: uint64(seconds) * 1000}
+		if seconds <= 0 {
+			reqPay = &pb.KVRequest{Command: REMOVE, Key: args[0]}
+		}
+		if respPay := c.call(reqPay, KEY_DNE_ERR); respPay != nil {
+			if respPay.ErrCode == NO_ERR {
+				writeRESPInt(c.w, 1)
+			} else {
+				writeRESPInt(c.w, 0)
+			}
+		}
+
+	case "mget":
+		if len(args) == 0 {
+			wrongArgs()
+			break
+		}
+		values := make([][]byte, len(args))
+		for i, key := range args {
+			respPay := c.call(&pb.KVRequest{Command: GET, Key: key}, KEY_DNE_ERR)
+			if respPay == nil {
+				return false
+			}
+			if respPay.ErrCode == NO_ERR {
+				values[i] = append([]byte{}, respPay.Value...)
+			}
+		}
+		writeRESPArray(c.w, len(values))
+		for _, value := range values {
+			writeRESPBulk(c.w, value)
+		}
+
+	case "mset":
+		// Not atomic, each pair is a separate PUT
+		if len(args) == 0 || len(args)%2 != 0 {
+			wrongArgs()
+			break
+		}
+		for i := 0; i < len(args); i += 2 {
+			if c.call(&pb.KVRequest{Command: PUT, Key: args[i], Value: args[i+1]}) == nil {
+				return false
+			}
+		}
+		writeRESPSimple(c.w, "OK")
+
+	case "info":
+		writeRESPBulk(c.w, []byte(c.node.respInfo()))
+
+	case "command":
+		// Asked by redis-cli on start, an empty list makes it skip the hints
+		writeRESPArray(c.w, 0)
+
+	case "select":
+		if len(args) != 1 {
+			wrongArgs()
+		} else if string(args[0]) != "0" {
+			writeRESPError(c.w, "ERR DB index is out of range")
+		} else {
+			writeRESPSimple(c.w, "OK")
+		}
+
+	case "client":
+		// Client names and library info are accepted and ignored
+		writeRESPSimple(c.w, "OK")
+
+	case "quit":
+		writeRESPSimple(c.w, "OK")
+		return true
+
+	default:
+		writeRESPError(c.w, fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+	return false
+}
+
+// Run the commands of a Redis client until it disconnects. Commands are
+// run one at a time so pipelined commands are answered in order.
+func (n *Node) serveRESP(conn net.Conn) {
+	n.track(conn)
+	defer n.untrack(conn)
+	defer conn.Close()
+	remote := conn.RemoteAddr().(*net.TCPAddr)
+	r := bufio.NewReader(conn)
+	c := &respClient{
+		node: n,
+		addr: &net.UDPAddr{IP: remote.IP, Port: remote.Port},
+		w:    bufio.NewWriter(conn),
+	}
+
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			if err == errRESPProtocol {
+				writeRESPError(c.w, "ERR "+err.Error())
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := c.handle(args)
+		// Flush once the pipelined commands already received are answered
+		if quit || r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// Starts the listener of the Redis protocol
+//
+// Arguments:
+//		port: port number to listen on
+func (n *Node) RESPHandler(port int) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Println("Error setting up the Redis listener:", err)
+		return
+	}
+	n.track(listener)
+
+	log.Println("Redis listener on port", port)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if n.isStopped() {
+				return
+			}
+			log.Println("Error accepting a Redis connection:", err)
+			continue
+		}
+		n.goRun(func() { n.serveRESP(conn) })
+	}
+}
@@ -0,0 +1,68 @@
+package pa2lib
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadRESPCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   error
+	}{
+		{"array", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []string{"GET", "k"}, nil},
+		{"empty bulk", "*2\r\n$3\r\nSET\r\n$0\r\n\r\n", []string{"SET", ""}, nil},
+		{"empty array", "*0\r\n", []string{}, nil},
+		{"inline", "SET k v\r\n", []string{"SET", "k", "v"}, nil},
+		{"empty line", "\r\n", []string{}, nil},
+		{"negative array length", "*-1\r\n", nil, errRESPProtocol},
+		{"too many arguments", "*65537\r\n", nil, errRESPProtocol},
+		{"bad array length", "*x\r\n", nil, errRESPProtocol},
+		{"not a bulk string", "*1\r\n:1\r\n", nil, errRESPProtocol},
+		{"negative bulk length", "*1\r\n$-1\r\n", nil, errRESPProtocol},
+		{"bulk too large", "*1\r\n$1048577\r\n", nil, errRESPProtocol},
+		{"bulk without CRLF", "*1\r\n$3\r\nGETxx", nil, errRESPProtocol},
+		{"bulk longer than its length", "*1\r\n$1\r\nGET\r\n", nil, errRESPProtocol},
+		{"truncated bulk", "*1\r\n$3\r\nGE", nil, io.ErrUnexpectedEOF},
+		{"truncated array", "*2\r\n$3\r\nGET\r\n", nil, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := readRESPCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			got := []string{}
+			for _, arg := range args {
+				got = append(got, string(arg))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRESPCommandSequence(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*1\r\n$4\r\nPING\r\nECHO hi\r\n"))
+	for _, want := range [][]string{{"PING"}, {"ECHO", "hi"}} {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(args) != len(want) || string(args[0]) != want[0] {
+			t.Fatalf("got %q, want %q", args, want)
+		}
+	}
+	if _, err := readRESPCommand(r); err != io.EOF {
+		t.Fatalf("got error %v at the end, want EOF", err)
+	}
+}
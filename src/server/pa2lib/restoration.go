@@ -1,30 +1,25 @@
 package pa2lib
 
-import (
-	"net"
-	"strconv"
-)
+func (n *Node) getRelationWith(node NodeVal) int {
+	curNode := n.localNode()
 
-func getRelationWith(node NodeVal) int {
-	curNode := *nodeList[localIP+":"+localPort]
-
-	curNodeSon := consistent.getNextNode(curNode)
-	if curNodeSon == node {
+	curNodeSon := n.consistent.getNextNode(curNode)
+	if sameNode(curNodeSon, node) {
 		return 1
 	}
 
-	curNodeGrandSon := consistent.getNextNode(curNodeSon)
-	if curNodeGrandSon == node {
+	curNodeGrandSon := n.consistent.getNextNode(curNodeSon)
+	if sameNode(curNodeGrandSon, node) {
 		return 2
 	}
 
-	curNodeFather := consistent.getLastNode(curNode)
-	if curNodeFather == node {
+	curNodeFather := n.consistent.getLastNode(curNode)
+	if sameNode(curNodeFather, node) {
 		return -1
 	}
 
-	curNodeGrandFather := consistent.getLastNode(curNodeFather)
-	if curNodeGrandFather == node {
+	curNodeGrandFather := n.consistent.getLastNode(curNodeFather)
+	if sameNode(curNodeGrandFather, node) {
 		return -2
 	}
 
@@ -33,59 +28,41 @@ func getRelationWith(node NodeVal) int {
 
 //this function should be called after a node receives hello
 //this function should be called after hashring is recalculated!
-func welcomeNewNode(node NodeVal){
-	port, _ := strconv.Atoi(node.port)
-
-	nodeAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(node.ipAdr),
-	}
-
-	switch getRelationWith(node) {
+func (n *Node) welcomeNewNode(node NodeVal){
+	relation := n.getRelationWith(node)
+	switch relation {
 		case -2:
 			onGrandFatherResurrect()
 		case -1:
-			onFatherResurrect(node)
+			n.onFatherResurrect(node)
 		case 1:
-			onSonResurrect(&nodeAddr)
+			n.onSonResurrect(node)
 		case 2:
-			onGrandSonResurrect(&nodeAddr)
+			n.onGrandSonResurrect(node)
+	}
+
+	// In a ring of two or three nodes the new node can also be our
+	// father, its range still has to be handed over
+	if relation != -1 && sameNode(n.consistent.getLastNode(n.localNode()), node) {
+		n.onFatherResurrect(node)
 	}
 }
 
-func onSonResurrect(addr *net.UDPAddr){
-	sendNodeDieReplicateRequest(I_AM_YOUR_FATHER, KVStore, addr)
+func (n *Node) onSonResurrect(son NodeVal){
+	n.replicateStoreTo(son, HANDOFF_REPLICATE_SON)
 }
 
-func onGrandSonResurrect(addr *net.UDPAddr){
-	sendNodeDieReplicateRequest(I_AM_YOUR_GRANDFATHER, KVStore, addr)
+func (n *Node) onGrandSonResurrect(grandson NodeVal){
+	n.replicateStoreTo(grandson, HANDOFF_REPLICATE_GRANDSON)
 }
 
 func onGrandFatherResurrect(){
 	//do nothing
 }
 
-func onFatherResurrect(father NodeVal){
-	var KVPairs []StoreVal
-	for i := 0 ; i < len(KVStore); {
-		KVPair := KVStore[i]
-		_, isMineKV := checkNode(KVPair.key)
-		//out of range
-		//should belong to father
-		if !isMineKV{
-			KVPairs = append(KVPairs, KVPair)
-			KVStore = append(KVStore[:i], KVStore[i+1:]...)
-		}
-	}
-
-	port, _ := strconv.Atoi(father.port)
-
-	fatherAddr := net.UDPAddr{
-		Port: port,
-		IP:   net.ParseIP(father.ipAdr),
-	}
-
-	sendNodeDieReplicateRequest(I_AM_YOUR_SON, KVPairs, &fatherAddr)
+// The father took over the range between the grandfather and itself,
+// hand it over in the background so clients are not slowed down
+func (n *Node) onFatherResurrect(father NodeVal){
+	grandfather := n.consistent.getLastNode(father)
+	n.scheduleRebalance(hashKey(grandfather.ipAdr, grandfather.port), hashKey(father.ipAdr, father.port), father)
 }
-
-
@@ -10,8 +10,20 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
-// send the request to the correct node
-func sendRequestToCorrectNode(node NodeVal, reqPay pb.KVRequest, msgID []byte) {
+// Values of the forward_mode config key and of the forwardMode field of
+// a request: how a request for a key owned by another node is answered
+const (
+	// The owner answers the client itself, from its own address
+	FORWARD_DIRECT = "direct"
+	// The client gets MOVED_ERR with the address of the owner
+	FORWARD_REDIRECT = "redirect"
+	// The contacted node waits for the answer of the owner and relays it
+	FORWARD_PROXY = "proxy"
+)
+
+// Turn a client command into the command forwarding it to the node
+// owning its key
+func setForwardCommand(reqPay *pb.KVRequest) {
 	switch reqPay.Command {
 	case GET:
 		reqPay.Command = GET_FORWARD
@@ -22,13 +34,38 @@ func sendRequestToCorrectNode(node NodeVal, reqPay pb.KVRequest, msgID []byte) {
 	case REMOVE:
 		reqPay.Command = REMOVE_FORWARD
 		break
+	case EXPIRE:
+		reqPay.Command = EXPIRE_FORWARD
+		break
+	case INCR:
+		reqPay.Command = INCR_FORWARD
+		break
+	case PUT_IF:
+		reqPay.Command = PUT_IF_FORWARD
+		break
 	case HELLO:
 		reqPay.Command = HELLO
 		break
 	default:
 		log.Println("Unknown command!")
 	}
+}
+
+// send the request to the correct node, over the node transport
+func (n *Node) sendRequestToCorrectNode(node NodeVal, reqPay *pb.KVRequest, msgID []byte) {
+	setForwardCommand(reqPay)
+	reqMsgBytes, err := marshalRequestMsg(msgID, reqPay)
+	if err != nil {
+		log.Println("Failed to encode request:", err)
+		return
+	}
+	if err := n.sendOneWay(node.ipAdr+":"+node.port, reqMsgBytes); err != nil {
+		fmt.Println("could not write to the correct node", err)
+	}
+}
 
+// Send a request to a node over UDP as is, without waiting for an answer
+func (n *Node) sendToNode(node NodeVal, reqPay *pb.KVRequest, msgID []byte) {
 	newPort, err := strconv.Atoi(node.port)
 	if err != nil {
 		log.Fatal(err)
@@ -40,7 +77,7 @@ func sendRequestToCorrectNode(node NodeVal, reqPay pb.KVRequest, msgID []byte) {
 		IP:   net.ParseIP(node.ipAdr),
 	}
 
-	marshaledReqPay, err := proto.Marshal(&reqPay)
+	marshaledReqPay, err := proto.Marshal(reqPay)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -55,10 +92,76 @@ func sendRequestToCorrectNode(node NodeVal, reqPay pb.KVRequest, msgID []byte) {
 	marshaledReqMsg, err := proto.Marshal(&reqMsg)
 
 	// send request to correct node
-	_, err = conn.WriteToUDP(marshaledReqMsg, &addr)
+	_, err = n.conn.WriteToUDP(marshaledReqMsg, &addr)
 	log.Println("sendRequestToCorrectNode", addr.IP, addr.Port)
 	if err != nil {
 		fmt.Println("could not write to the correct node", err)
 	}
 }
 
+
+// Get the forward mode of a request: the one it asks for, otherwise the
+// one of the node. The requests of the gateways are always proxied, they
+// wait for the answer on their stream.
+func (n *Node) forwardModeOf(reqPay *pb.KVRequest, stream responseStream) string {
+	if validForwardMode(reqPay.ForwardMode) {
+		return reqPay.ForwardMode
+	}
+	if _, local := stream.(*localStream); local {
+		return FORWARD_PROXY
+	}
+	return n.config.ForwardMode
+}
+
+func validForwardMode(mode string) bool {
+	return mode == FORWARD_DIRECT || mode == FORWARD_REDIRECT || mode == FORWARD_PROXY
+}
+
+// Tell the client which node owns the key of its request, and the epoch
+// of the ring it was found in, so it can send the request there
+func (n *Node) redirectRequest(node NodeVal, clientAddr *net.UDPAddr, stream responseStream, msgID []byte) {
+	respPay := &pb.KVResponse{
+		ErrCode:   MOVED_ERR,
+		Owner:     node.ipAdr + ":" + node.port,
+		RingEpoch: n.ringEpoch(),
+	}
+	n.sendResponse(clientAddr, stream, msgID, respPay)
+}
+
+// Forward a client request to the node owning its key, according to
+// its forward mode. Over TCP, where the owner can't answer the client
+// itself, the direct mode proxies the request. So does a node sending
+// to other nodes over TCP, which falls back to a UDP forward for a node
+// that only speaks UDP.
+//
+// Arguments:
+//		node: node owning the key
+//		reqPay: request payload
+//		msgID: message ID of the client request
+//		clientAddr: address of the client
+//		stream: connection of the client, nil over UDP
+func (n *Node) forwardRequest(node NodeVal, reqPay *pb.KVRequest, msgID []byte, clientAddr *net.UDPAddr, stream responseStream) {
+	switch n.forwardModeOf(reqPay, stream) {
+	case FORWARD_REDIRECT:
+		n.redirectRequest(node, clientAddr, stream, msgID)
+	case FORWARD_PROXY:
+		n.proxyRequestToCorrectNode(node, reqPay, msgID, clientAddr, stream)
+	default:
+		if stream != nil || n.config.NodeTransport == TRANSPORT_TCP {
+			n.proxyRequestToCorrectNode(node, reqPay, msgID, clientAddr, stream)
+		} else {
+			n.forwardOverUDP(node, reqPay, msgID, clientAddr)
+		}
+	}
+}
+
+// Get the address to answer a forwarded request to. A request forwarded
+// over UDP carries the address of the client, one forwarded over TCP is
+// answered on its connection.
+func forwardedClientAddr(clientAddr *net.UDPAddr, stream responseStream, addr []byte) *net.UDPAddr {
+	if stream != nil {
+		return clientAddr
+	}
+	forwardedAddr, _ := net.ResolveUDPAddr("udp", string(addr))
+	return forwardedAddr
+}
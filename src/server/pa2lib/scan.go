@@ -0,0 +1,158 @@
+package pa2lib
+
+import (
+	"bytes"
+	"fmt"
+	pb "pa2/pb/protobuf"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Time to wait for a node to list its keys
+const scanTimeout = 1 * time.Second
+const scanAttempts = 2
+
+// Most bytes of keys and values in a SCAN response, so it fits in a
+// datagram. A single larger pair is still sent alone.
+const maxScanPageBytes = 8192
+
+// Bytes counted for each pair on top of its key and value
+const scanEntryOverhead = 16
+
+// Get the key-value pairs of the KVStore in key order, without the
+// replicas and the keys whose time to live is over
+//
+// Arguments:
+//		startAfter: only keys greater than this one, nil for every key
+//		prefix: only keys starting with it
+//		limit: maximum number of pairs, 0 for every pair
+//		maxBytes: most bytes of pairs returned, 0 for no limit
+// Returns:
+//		The pairs found, and true if more were left out for maxBytes
+func (n *Node) scanLocal(startAfter []byte, prefix []byte, limit uint32, maxBytes int) ([]*pb.KVEntry, bool) {
+	var entries []*pb.KVEntry
+	n.mutex.Lock()
+	for _, value := range n.KVStore {
+		if bytes.Compare(value.key, startAfter) > 0 && bytes.HasPrefix(value.key, prefix) {
+			entries = append(entries, &pb.KVEntry{Key: value.key, Value: value.value, Version: value.version})
+		}
+	}
+	n.mutex.Unlock()
+
+	now := time.Now()
+	live := entries[:0]
+	for _, entry := range entries {
+		if expiry, ok := n.getExpiry(entry.Key); ok && now.After(expiry) {
+			continue
+		}
+		live = append(live, entry)
+	}
+	entries = sortEntries(live, limit)
+
+	if maxBytes <= 0 {
+		return entries, false
+	}
+	size := 0
+	for i, entry := range entries {
+		size += len(entry.Key) + len(entry.Value) + scanEntryOverhead
+		if size > maxBytes && i > 0 {
+			return entries[:i], true
+		}
+	}
+	return entries, false
+}
+
+// Sort pairs by key, drop the duplicated keys and keep the first limit
+// ones. A key moving between two nodes may be listed by both.
+func sortEntries(entries []*pb.KVEntry, limit uint32) []*pb.KVEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+	unique := entries[:0]
+	for _, entry := range entries {
+		if len(unique) > 0 && bytes.Equal(unique[len(unique)-1].Key, entry.Key) {
+			continue
+		}
+		unique = append(unique, entry)
+	}
+	if limit > 0 && uint32(len(unique)) > limit {
+		unique = unique[:limit]
+	}
+	return unique
+}
+
+// Get the pairs of another node, a page at a time
+//
+// Arguments:
+//		node: node to scan
+//		startAfter, prefix, limit: same as scanCluster
+// Returns:
+//		The pairs found, or an error if the node didn't answer
+func (n *Node) scanNode(node NodeVal, startAfter []byte, prefix []byte, limit uint32) ([]*pb.KVEntry, error) {
+	var entries []*pb.KVEntry
+	for {
+		reqPay := &pb.KVRequest{
+			Command: SCAN,
+			Key:     startAfter,
+			Value:   prefix,
+			Limit:   limit,
+		}
+		if limit > 0 {
+			reqPay.Limit = limit - uint32(len(entries))
+		}
+		respPay, err := n.sendRequestAndWait(node, reqPay, scanTimeout, scanAttempts)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, respPay.Entries...)
+		if !respPay.More || len(respPay.Entries) == 0 || (limit > 0 && uint32(len(entries)) >= limit) {
+			return entries, nil
+		}
+		startAfter = respPay.Entries[len(respPay.Entries)-1].Key
+	}
+}
+
+// Get the key-value pairs of the whole cluster in key order. Every node
+// of the ring is asked for its own pairs with SCAN requests, each
+// answered with a page small enough for a datagram.
+//
+// Arguments:
+//		startAfter: only keys greater than this one, nil for every key
+//		prefix: only keys starting with it
+//		limit: maximum number of pairs, 0 for every pair
+// Returns:
+//		The pairs found, or an error if a node didn't answer
+func (n *Node) scanCluster(startAfter []byte, prefix []byte, limit uint32) ([]*pb.KVEntry, error) {
+	var wg sync.WaitGroup
+	var entriesMutex sync.Mutex
+	var entries []*pb.KVEntry
+	var scanErr error
+	for _, node := range n.consistent.getRingNodes() {
+		if sameNode(node, n.localNode()) {
+			local, _ := n.scanLocal(startAfter, prefix, limit, 0)
+			entriesMutex.Lock()
+			entries = append(entries, local...)
+			entriesMutex.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(node NodeVal) {
+			defer wg.Done()
+			nodeEntries, err := n.scanNode(node, startAfter, prefix, limit)
+
+			entriesMutex.Lock()
+			defer entriesMutex.Unlock()
+			if err != nil {
+				scanErr = fmt.Errorf("could not scan %v:%v: %v", node.ipAdr, node.port, err)
+				return
+			}
+			entries = append(entries, nodeEntries...)
+		}(node)
+	}
+	wg.Wait()
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return sortEntries(entries, limit), nil
+}
@@ -0,0 +1,55 @@
+package pa2lib
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScanLocal(t *testing.T) {
+	n := newTestNode()
+	version := int32(1)
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 20; i++ {
+		n.putOwned([]byte(fmt.Sprintf("key-%02d", i)), value, &version)
+	}
+	n.putOwned([]byte("key-expired"), value, &version)
+	n.setExpiry([]byte("key-expired"), -time.Second)
+
+	tests := []struct {
+		name       string
+		startAfter string
+		limit      uint32
+		maxBytes   int
+		wantFirst  string
+		wantLen    int
+		wantMore   bool
+	}{
+		{"every key", "", 0, 0, "key-00", 20, false},
+		{"byte budget", "", 0, 8192, "key-00", 8, true},
+		{"after a key", "key-15", 0, 8192, "key-16", 4, false},
+		{"limit under the budget", "", 3, 8192, "key-00", 3, false},
+		{"budget smaller than a pair", "", 0, 10, "key-00", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var startAfter []byte
+			if tt.startAfter != "" {
+				startAfter = []byte(tt.startAfter)
+			}
+			entries, more := n.scanLocal(startAfter, nil, tt.limit, tt.maxBytes)
+			if len(entries) != tt.wantLen || more != tt.wantMore {
+				t.Fatalf("got %d pairs more %v, want %d more %v", len(entries), more, tt.wantLen, tt.wantMore)
+			}
+			if string(entries[0].Key) != tt.wantFirst {
+				t.Fatalf("got first key %q, want %q", entries[0].Key, tt.wantFirst)
+			}
+			for _, entry := range entries {
+				if string(entry.Key) == "key-expired" {
+					t.Fatal("got an expired key")
+				}
+			}
+		})
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"net"
 	"os"
 	pb "pa2/pb/protobuf"
-	"strconv"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -14,16 +13,54 @@ import (
 
 // List of errors that can be returned to client
 const (
-	NO_ERR           = 0x00
-	KEY_DNE_ERR      = 0x01
-	NO_SPC_ERR       = 0x02
-	SYS_OVERLOAD_ERR = 0x03
-	KV_INTERNAL_ERR  = 0x04
-	UNKNOWN_CMD_ERR  = 0x05
-	INVALID_KEY_ERR  = 0x06
-	INVALID_VAL_ERR  = 0x07
+	NO_ERR               = 0x00
+	KEY_DNE_ERR          = 0x01
+	NO_SPC_ERR           = 0x02
+	SYS_OVERLOAD_ERR     = 0x03
+	KV_INTERNAL_ERR      = 0x04
+	UNKNOWN_CMD_ERR      = 0x05
+	INVALID_KEY_ERR      = 0x06
+	INVALID_VAL_ERR      = 0x07
+	NO_QUORUM_ERR        = 0x08
+	CONDITION_FAILED_ERR = 0x09
+	MOVED_ERR            = 0x0a
+	UNREACHABLE_ERR      = 0x0b
+	TOO_MANY_HOPS_ERR    = 0x0c
 )
 
+// Describe an error code for the gateways
+func errCodeMessage(errCode uint32) string {
+	switch errCode {
+	case NO_ERR:
+		return "no error"
+	case KEY_DNE_ERR:
+		return "key does not exist"
+	case NO_SPC_ERR:
+		return "no space left"
+	case SYS_OVERLOAD_ERR:
+		return "overloaded"
+	case KV_INTERNAL_ERR:
+		return "internal error"
+	case UNKNOWN_CMD_ERR:
+		return "unknown command"
+	case INVALID_KEY_ERR:
+		return "invalid key"
+	case INVALID_VAL_ERR:
+		return "invalid value"
+	case NO_QUORUM_ERR:
+		return "no quorum"
+	case CONDITION_FAILED_ERR:
+		return "condition not met"
+	case MOVED_ERR:
+		return "key owned by another node"
+	case UNREACHABLE_ERR:
+		return "no node holding the key answered"
+	case TOO_MANY_HOPS_ERR:
+		return "request forwarded too many times"
+	}
+	return fmt.Sprintf("error code %d", errCode)
+}
+
 // List of commands that can be sent to the server
 const (
 	PUT                       = 0x01
@@ -55,29 +92,51 @@ const (
 	I_AM_YOUR_SON = 0x37
 	I_AM_YOUR_GRANDSON = 0x38
 	HELLO = 0x40
+	MIGRATE_PUT = 0x41
+	MIGRATE_BEGIN = 0x42
+	MIGRATE_DONE = 0x43
+	MIGRATE_GET = 0x44
+	MIGRATE_DUAL_PUT = 0x45
+	MIGRATE_DUAL_REMOVE = 0x46
+	JOIN = 0x47
+	HANDOFF_REPLICATE_SON = 0x48
+	HANDOFF_REPLICATE_GRANDSON = 0x49
+	LEAVE = 0x4a
+	PING = 0x4b
+	PING_REQ = 0x4c
+	GOSSIP_DIGEST = 0x4d
+	GOSSIP_SYNC = 0x4e
+
+	REBALANCE_STATUS = 0x50
+	REBALANCE_PAUSE = 0x51
+	REBALANCE_RESUME = 0x52
+	REBALANCE_CANCEL = 0x53
+	DECOMMISSION = 0x54
+	WATCH_MEMBERSHIP = 0x55
+	MEMBERSHIP_QUERY = 0x56
+	CLUSTER_HEALTH = 0x57
+	SCAN = 0x58
+	EXPIRE = 0x59
+	EXPIRE_FORWARD = 0x5a
+	INCR = 0x5b
+	INCR_FORWARD = 0x5c
+	PUT_IF = 0x5d
+	PUT_IF_FORWARD = 0x5e
+	GET_FALLBACK = 0x5f
+	PUT_FALLBACK = 0x60
+	REMOVE_FALLBACK = 0x61
+	FORWARD_ACK = 0x62
+	GET_RING = 0x63
+	HINT_PUT = 0x64
+	HINT_REMOVE = 0x65
 )
 
 // Constant to use for the server overload condition
 var overloadWaitTimeMs = int32(5000)
 
-// Global variable to store the UDP connection object so
-// that it does not need to be passed to every function
-var conn *net.UDPConn
-
-// Global counter of how many clients are currently being handled,
-// should be updated atomically
-var numClients uint64
-
-// Channel to signal to the server to quit
-var shutdown = make(chan bool)
-
-// hash ring
-var consistent = newConsistent()
-
 // time interval of gossip
 var gossipTime = time.Now()
 var gossipIntvl = time.Since(gossipTime)
-var localIP string
 
 func unmarshalMsg(msg []byte)([]byte, []byte, uint64){
 
@@ -100,11 +159,12 @@ func unmarshalMsg(msg []byte)([]byte, []byte, uint64){
 //
 // Arguments:
 //		clientAddr: address to return to the response to
+//		stream: connection to return the response on, nil over UDP
 //		msgID: ID of the request
 //		respPay: payload to return in the response
-func sendResponse(clientAddr *net.UDPAddr, msgID []byte, respPay pb.KVResponse) {
+func (n *Node) sendResponse(clientAddr *net.UDPAddr, stream responseStream, msgID []byte, respPay *pb.KVResponse) {
 	// Marshal the payload
-	respPayBytes, err := proto.Marshal(&respPay)
+	respPayBytes, err := proto.Marshal(respPay)
 	if err != nil {
 		return
 	}
@@ -125,68 +185,51 @@ func sendResponse(clientAddr *net.UDPAddr, msgID []byte, respPay pb.KVResponse)
 		return
 	}
 
-	// Cache the response if it's not a server overload
-	if respPay.ErrCode != SYS_OVERLOAD_ERR {
+	// Cache the response if it's not a server overload, or a node that
+	// didn't answer, which a retry may reach
+	if respPay.ErrCode != SYS_OVERLOAD_ERR && respPay.ErrCode != UNREACHABLE_ERR {
 		// If there is no space to add to the cache, send a server
 		// overload response instead
-		if !CacheResponse(msgID, respMsgBytes) {
+		if !n.CacheResponse(msgID, respMsgBytes) {
 			fmt.Println("save into cache")
 			respPay.ErrCode = SYS_OVERLOAD_ERR
-			sendResponse(clientAddr, msgID, respPay)
+			n.sendResponse(clientAddr, stream, msgID, respPay)
 		}
 	}
 
 	// Send the message back to the client
-	_, _ = conn.WriteToUDP(respMsgBytes, clientAddr)
+	n.writeToClient(clientAddr, stream, respMsgBytes)
 	//log.Println("sendResponse", clientAddr.IP, clientAddr.Port)
 }
 
-func sayHelloToEveryone(nodeList map[string]*NodeVal) {
+func (n *Node) sayHelloToEveryone(nodeList map[string]*NodeVal) {
 	for _, node := range nodeList {
-		if node.ipAdr == localIP && node.port == localPort {
+		if node.ipAdr == n.localIP && node.port == n.localPort {
 			log.Println("It's myself, skip send node")
 			continue
 		}
-		reqPay := pb.KVRequest{ Command: HELLO }
+		reqPay := &pb.KVRequest{ Command: HELLO }
 		log.Println("Now say hello to" + node.ipAdr + ":"+node.port)
-		port, _ := strconv.Atoi(localPort)
-		msgID := generateUniqueMsgID([]byte(localIP), port)
-		sendRequestToCorrectNode(*node, reqPay, msgID)
+		n.sendRequestToCorrectNode(*node, reqPay, n.newNodeMsgID())
 	}
 }
 
-// Starts the UDP server. Waits for a message to be received
-// and spawns a goroutine to handle the client's request
+// Starts a node and blocks until it stops
 //
 // Arguments:
+//		serverListFile: file listing the "ip:port" of the initial nodes
 //		port: port number to listen on
-func StartServer(serverListFile string, port int) {
-	// Start the cache TTL manager
-	go CacheTTLManager()
-
-	log.Println("Server start")
-
-	conn1, err := net.Dial("udp", "8.8.8.8:80")
+//		config: settings of the node
+func StartServer(serverListFile string, port int, config Config) {
+	serverList, err := readServerList(serverListFile)
 	if err != nil {
-		log.Println("Error: could not get local IP address:", err)
-		os.Exit(2)
+		log.Println("Open file error!", err)
 	}
-	localAddr := conn1.LocalAddr().(*net.UDPAddr)
-	localIP = localAddr.IP.String()
-	conn1.Close()
-
-	// generate hash ring for the current node
-	initNodeList(serverListFile)
-	nodeList := getNodeList()
-	consistent.generateHashRing(nodeList)
-
-	//defer conn.Close()
 
-	//sayHelloToEveryone(nodeList)
-
-	//go doGossip()
-	go KVReqHandler(port)
-	//go RepRequestHandler()
-
-	for{}
+	node := NewNode(port, serverList, config)
+	if err := node.Start(); err != nil {
+		log.Println("Error: could not start the server:", err)
+		os.Exit(2)
+	}
+	<-node.Done()
 }
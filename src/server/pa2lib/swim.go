@@ -0,0 +1,291 @@
+package pa2lib
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	pb "pa2/pb/protobuf"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Maximum number of membership updates carried by a single probe message
+const maxPiggybackUpdates = 8
+
+// A membership update waiting to be spread, and how many messages
+// already carried it
+type pendingUpdate struct {
+	node NodeVal
+	sent int
+}
+
+// Queue a membership update so the next probe messages carry it
+func (n *Node) queueUpdate(node NodeVal) {
+	n.pendingUpdatesMutex.Lock()
+	n.pendingUpdates[node.ipAdr+":"+node.port] = &pendingUpdate{node: node}
+	n.pendingUpdatesMutex.Unlock()
+}
+
+// Number of messages an update is carried by before being dropped. It
+// grows with the log of the cluster size, which is enough for the
+// update to reach every node with high probability.
+func (n *Node) retransmitLimit() int {
+	n.nodeListMutex.RLock()
+	members := len(n.nodeList)
+	n.nodeListMutex.RUnlock()
+	return 3 * int(math.Ceil(math.Log2(float64(members+1))))
+}
+
+// Take the updates to piggyback on an outgoing message, preferring the
+// ones sent the fewest times
+//
+// Returns:
+//		The updates, marshalled the same way as a membership list
+func (n *Node) takeUpdates() map[string][]byte {
+	limit := n.retransmitLimit()
+
+	n.pendingUpdatesMutex.Lock()
+	defer n.pendingUpdatesMutex.Unlock()
+
+	addrs := make([]string, 0, len(n.pendingUpdates))
+	for addr := range n.pendingUpdates {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return n.pendingUpdates[addrs[i]].sent < n.pendingUpdates[addrs[j]].sent
+	})
+	if len(addrs) > maxPiggybackUpdates {
+		addrs = addrs[:maxPiggybackUpdates]
+	}
+
+	updates := make(map[string][]byte)
+	for _, addr := range addrs {
+		update := n.pendingUpdates[addr]
+		updates[addr], _ = proto.Marshal(nodeToPb(update.node))
+		update.sent++
+		if update.sent >= limit {
+			delete(n.pendingUpdates, addr)
+		}
+	}
+	return updates
+}
+
+// Apply the updates piggybacked on a received message
+func (n *Node) applyPiggybackedUpdates(updates map[string][]byte) {
+	if len(updates) == 0 {
+		return
+	}
+	for _, node := range nodeListParseFromByteArray(updates) {
+		n.applyNodeUpdate(node, true)
+	}
+}
+
+// Get the next node to probe. Every member is probed once per round,
+// in a random order that changes every round.
+//
+// Returns:
+//		The node, and false if there is no other member
+func (n *Node) nextProbeTarget() (NodeVal, bool) {
+	for {
+		if len(n.probeTargets) == 0 {
+			for _, node := range n.getNodeList() {
+				if node.isOn && !sameNode(*node, n.localNode()) {
+					n.probeTargets = append(n.probeTargets, *node)
+				}
+			}
+			if len(n.probeTargets) == 0 {
+				return NodeVal{}, false
+			}
+			rand.Shuffle(len(n.probeTargets), func(i, j int) {
+				n.probeTargets[i], n.probeTargets[j] = n.probeTargets[j], n.probeTargets[i]
+			})
+		}
+
+		target := n.probeTargets[0]
+		n.probeTargets = n.probeTargets[1:]
+		// Skip nodes that died or left since the round started
+		if node, ok := n.lookupNode(target.ipAdr + ":" + target.port); ok && node.isOn {
+			return node, true
+		}
+	}
+}
+
+// Ping a node directly, exchanging piggybacked updates with it
+//
+// Returns:
+//		True if the node acknowledged the ping in time
+func (n *Node) ping(target NodeVal, timeout time.Duration) bool {
+	reqPay := &pb.KVRequest{
+		Command:  PING,
+		NodeList: n.takeUpdates(),
+	}
+	respPay, err := n.sendRequestAndWait(target, reqPay, timeout, 1)
+	if err != nil {
+		return false
+	}
+	n.detector.heartbeat(target.ipAdr + ":" + target.port)
+	n.applyPiggybackedUpdates(respPay.NodeList)
+	return true
+}
+
+// Ask k random members to ping a node on our behalf, in case only the
+// path between this node and the target is broken
+//
+// Returns:
+//		True if any of the members got an ack from the target
+func (n *Node) indirectPing(target NodeVal) bool {
+	var helpers []NodeVal
+	for _, node := range n.getNodeList() {
+		if node.isOn && !sameNode(*node, n.localNode()) && !sameNode(*node, target) {
+			helpers = append(helpers, *node)
+		}
+	}
+	rand.Shuffle(len(helpers), func(i, j int) {
+		helpers[i], helpers[j] = helpers[j], helpers[i]
+	})
+	if len(helpers) > int(n.config.IndirectProbes) {
+		helpers = helpers[:n.config.IndirectProbes]
+	}
+
+	// The helper pings the target before answering, so it gets a
+	// longer timeout
+	timeout := 2 * time.Duration(n.config.ProbeTimeoutMs) * time.Millisecond
+	acks := make(chan bool, len(helpers))
+	for _, helper := range helpers {
+		go func(helper NodeVal) {
+			reqPay := &pb.KVRequest{
+				Command:  PING_REQ,
+				Addr:     []byte(target.ipAdr + ":" + target.port),
+				NodeList: n.takeUpdates(),
+			}
+			respPay, err := n.sendRequestAndWait(helper, reqPay, timeout, 1)
+			if err != nil {
+				acks <- false
+				return
+			}
+			n.applyPiggybackedUpdates(respPay.NodeList)
+			acks <- respPay.ErrCode == NO_ERR
+		}(helper)
+	}
+
+	for range helpers {
+		if <-acks {
+			n.detector.heartbeat(target.ipAdr + ":" + target.port)
+			return true
+		}
+	}
+	return false
+}
+
+// Probe a node, first directly then through other members. If nobody
+// reached it, the failure detector decides whether to suspect it.
+func (n *Node) probe(target NodeVal) {
+	timeout := time.Duration(n.config.ProbeTimeoutMs) * time.Millisecond
+	if n.ping(target, timeout) || n.indirectPing(target) {
+		return
+	}
+
+	addr := target.ipAdr + ":" + target.port
+	n.detector.missed(addr)
+	if !n.detector.suspect(addr) {
+		log.Printf("No ack from %v, suspicion level %.2f\n", addr, n.detector.suspicion(addr))
+		return
+	}
+	n.suspectNode(target)
+}
+
+// Mark an alive node as suspect
+func (n *Node) suspectNode(target NodeVal) {
+	if target.membership == MEMBERSHIP_SUSPECT {
+		return
+	}
+
+	log.Printf("Suspecting %v:%v, suspicion level %.2f\n", target.ipAdr, target.port, n.detector.suspicion(target.ipAdr+":"+target.port))
+	target.membership = MEMBERSHIP_SUSPECT
+	target.time = uint64(time.Now().UnixNano())
+	n.applyNodeUpdate(target, true)
+}
+
+// Suspect the alive nodes the failure detector gave up on, and declare
+// dead the nodes that stayed suspected for longer than the suspect
+// timeout without refuting it
+func (n *Node) checkSuspects() {
+	now := time.Now()
+	timeout := time.Duration(n.config.SuspectTimeoutMs) * time.Millisecond
+	suspected := make(map[string]bool)
+
+	for addr, node := range n.getNodeList() {
+		if node.membership == MEMBERSHIP_MEMBER && !sameNode(*node, n.localNode()) && n.detector.suspect(addr) {
+			n.suspectNode(*node)
+			continue
+		}
+		if node.membership != MEMBERSHIP_SUSPECT {
+			continue
+		}
+		suspected[addr] = true
+		since, ok := n.suspectSince[addr]
+		if !ok {
+			n.suspectSince[addr] = now
+			continue
+		}
+		if now.Sub(since) < timeout {
+			continue
+		}
+
+		log.Printf("%v stayed suspected for %v, declaring it dead\n", addr, timeout)
+		dead := *node
+		dead.isOn = false
+		dead.membership = MEMBERSHIP_DEAD
+		dead.time = uint64(now.UnixNano())
+		n.applyNodeUpdate(dead, true)
+	}
+
+	for addr := range n.suspectSince {
+		if !suspected[addr] {
+			delete(n.suspectSince, addr)
+		}
+	}
+}
+
+// Loops forever probing one member every probe interval and expiring
+// suspicions. Should be called as a goroutine so it can run in the
+// background
+func (n *Node) FailureDetectorLoop() {
+	for {
+		start := time.Now()
+		if target, ok := n.nextProbeTarget(); ok {
+			n.probe(target)
+		}
+		n.checkSuspects()
+
+		interval := time.Duration(n.config.ProbeIntervalMs) * time.Millisecond
+		if !n.sleep(interval - time.Since(start)) {
+			return
+		}
+	}
+}
+
+// Handle a PING, acknowledging it with our own piggybacked updates
+//
+// Returns:
+//		The updates to send back, and NO_ERR
+func (n *Node) handlePing(reqPay *pb.KVRequest) (map[string][]byte, uint32) {
+	n.applyPiggybackedUpdates(reqPay.NodeList)
+	return n.takeUpdates(), NO_ERR
+}
+
+// Handle a PING_REQ by pinging the target on behalf of the sender
+//
+// Returns:
+//		The updates to send back, and false if the target didn't ack, in
+//		which case no response is sent
+func (n *Node) handlePingReq(reqPay *pb.KVRequest) (map[string][]byte, bool) {
+	n.applyPiggybackedUpdates(reqPay.NodeList)
+	target := n.nodeFromAddr(string(reqPay.Addr))
+	if !n.ping(target, time.Duration(n.config.ProbeTimeoutMs)*time.Millisecond) {
+		return nil, false
+	}
+	return n.takeUpdates(), true
+}
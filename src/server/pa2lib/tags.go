@@ -0,0 +1,74 @@
+package pa2lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse a tag filter made of comma separated "name=value" pairs
+//
+// Arguments:
+//		filter: the filter, empty to match every node
+// Returns:
+//		The tags a node must have, and an error if the filter is malformed
+func parseTagFilter(filter string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(filter, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		s := strings.SplitN(pair, "=", 2)
+		if len(s) != 2 || strings.TrimSpace(s[0]) == "" {
+			return nil, fmt.Errorf("expected name=value, got %q", pair)
+		}
+		tags[strings.TrimSpace(s[0])] = strings.TrimSpace(s[1])
+	}
+	return tags, nil
+}
+
+// Check whether a node has all the given tags
+func hasTags(node NodeVal, tags map[string]string) bool {
+	for name, value := range tags {
+		if nodeValue, ok := node.tags[name]; !ok || nodeValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the nodes having all the given tags, for example to place data
+// in a given zone
+//
+// Arguments:
+//		tags: tags the nodes must have
+// Returns:
+//		The matching nodes, by address
+func (n *Node) nodesWithTags(tags map[string]string) map[string]NodeVal {
+	nodes := make(map[string]NodeVal)
+	for addr, node := range n.getNodeList() {
+		if hasTags(*node, tags) {
+			nodes[addr] = *node
+		}
+	}
+	return nodes
+}
+
+// Handle a MEMBERSHIP_QUERY: list the nodes matching a tag filter, with
+// their tags, state and our suspicion level of them
+//
+// Arguments:
+//		filter: comma separated "name=value" pairs, empty for every node
+// Returns:
+//		The matching nodes marshalled as a membership list, and an error code
+func (n *Node) handleMembershipQuery(filter string) (map[string][]byte, uint32) {
+	tags, err := parseTagFilter(filter)
+	if err != nil {
+		return nil, INVALID_VAL_ERR
+	}
+
+	entries := make(map[string][]byte)
+	for addr, node := range n.nodesWithTags(tags) {
+		entries[addr] = n.membershipEntry(addr, node)
+	}
+	return entries, NO_ERR
+}
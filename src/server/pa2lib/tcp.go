@@ -0,0 +1,391 @@
+package pa2lib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	pb "pa2/pb/protobuf"
+	"sync"
+	"time"
+)
+
+// Values of the node_transport config key: how requests to other nodes
+// are sent
+const (
+	TRANSPORT_UDP = "udp"
+	TRANSPORT_TCP = "tcp"
+)
+
+// Largest message accepted in a TCP frame
+const maxFrameBytes = 64 << 20
+
+// Time to wait for a response relayed for a TCP client
+const proxyTimeout = 500 * time.Millisecond
+const proxyAttempts = 2
+
+// Returned when a node can't be reached over TCP at all, to fall back
+// to UDP
+var errNoTCP = errors.New("node not reachable over TCP")
+
+// Read a frame: the length of the message as 4 bytes big endian,
+// followed by the message
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > maxFrameBytes {
+		return nil, fmt.Errorf("frame of %d bytes is too large", length)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Write a message as a single frame
+func writeFrame(w io.Writer, msg []byte) error {
+	frame := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	_, err := w.Write(frame)
+	return err
+}
+
+// Where the responses to a client that isn't reached over UDP are
+// written, in the order they are ready
+type responseStream interface {
+	write(msg []byte) error
+}
+
+// A TCP connection requests came on. Requests are handled concurrently,
+// so responses are written in the order they are ready, not the order of
+// the requests, and the client matches them by message ID.
+type tcpStream struct {
+	conn net.Conn
+	sync.Mutex
+}
+
+// Send a message on the stream
+func (s *tcpStream) write(msg []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	return writeFrame(s.conn, msg)
+}
+
+// Send a message to a client, on its TCP connection if it has one
+//
+// Arguments:
+//		clientAddr: address of a UDP client
+//		stream: stream of a client not reached over UDP, nil over UDP
+//		msg: marshalled message
+func (n *Node) writeToClient(clientAddr *net.UDPAddr, stream responseStream, msg []byte) {
+	if stream != nil {
+		if err := stream.write(msg); err != nil {
+			log.Println("Error writing a response:", err)
+		}
+		return
+	}
+	_, _ = n.conn.WriteToUDP(msg, clientAddr)
+}
+
+// Starts the TCP server on the same port as the UDP one, and handles
+// every connection in its own goroutine
+//
+// Arguments:
+//		port: port number to listen on
+func (n *Node) TCPReqHandler(port int) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Println("Error setting up the TCP server:", err)
+		return
+	}
+	n.track(listener)
+
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			if n.isStopped() {
+				return
+			}
+			log.Println("Error accepting a TCP connection:", err)
+			continue
+		}
+		n.goRun(func() { n.serveTCP(c) })
+	}
+}
+
+// Read the requests of a TCP connection until it is closed
+func (n *Node) serveTCP(c net.Conn) {
+	n.track(c)
+	defer n.untrack(c)
+	defer c.Close()
+	stream := &tcpStream{conn: c}
+	remote := c.RemoteAddr().(*net.TCPAddr)
+	clientAddr := &net.UDPAddr{IP: remote.IP, Port: remote.Port}
+
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Closing TCP connection from", remote, err)
+			}
+			return
+		}
+		reqPay, id, res := unmarshalKVRequest(msg)
+		if res == 0 {
+			go n.handleKVRequest(clientAddr, stream, id, reqPay)
+		}
+	}
+}
+
+// A connection to another node, shared by every request sent to it.
+// Requests are pipelined: each one waits for the response carrying its
+// message ID while the others go on.
+type nodeConn struct {
+	node       *Node
+	conn       net.Conn
+	writeMutex sync.Mutex
+	// Responses awaited, by message ID
+	pending      map[string]chan []byte
+	pendingMutex sync.Mutex
+	// Closed once the connection is broken
+	closed chan struct{}
+}
+
+// Get the connection to a node, opening it if there is none
+//
+// Arguments:
+//		addr: address of the node
+//		timeout: time to wait for the connection to open
+// Returns:
+//		The connection, or an error if the node can't be connected to
+func (n *Node) getNodeConn(addr string, timeout time.Duration) (*nodeConn, error) {
+	n.nodeConnsMutex.Lock()
+	if c, ok := n.nodeConns[addr]; ok {
+		n.nodeConnsMutex.Unlock()
+		return c, nil
+	}
+	n.nodeConnsMutex.Unlock()
+
+	tcpConn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &nodeConn{
+		node:    n,
+		conn:    tcpConn,
+		pending: make(map[string]chan []byte),
+		closed:  make(chan struct{}),
+	}
+
+	n.nodeConnsMutex.Lock()
+	if existing, ok := n.nodeConns[addr]; ok {
+		// Another request connected first
+		n.nodeConnsMutex.Unlock()
+		tcpConn.Close()
+		return existing, nil
+	}
+	n.nodeConns[addr] = c
+	n.nodeConnsMutex.Unlock()
+
+	go c.readResponses(addr)
+	return c, nil
+}
+
+// Hand every response of the connection to the request waiting for it,
+// until the connection breaks
+func (c *nodeConn) readResponses(addr string) {
+	r := bufio.NewReader(c.conn)
+	for {
+		msg, err := readFrame(r)
+		if err != nil {
+			break
+		}
+		msgID, _, _ := unmarshalMsg(msg)
+		if msgID == nil {
+			continue
+		}
+
+		c.pendingMutex.Lock()
+		waiting, ok := c.pending[string(msgID)]
+		c.pendingMutex.Unlock()
+		if ok {
+			select {
+			case waiting <- msg:
+			default:
+			}
+		}
+	}
+
+	c.node.nodeConnsMutex.Lock()
+	if c.node.nodeConns[addr] == c {
+		delete(c.node.nodeConns, addr)
+	}
+	c.node.nodeConnsMutex.Unlock()
+	c.conn.Close()
+	close(c.closed)
+}
+
+// Send a message on the connection without waiting for a response
+//
+// Arguments:
+//		msg: marshalled message
+//		timeout: time allowed for the write
+// Returns:
+//		An error if the message could not be written
+func (c *nodeConn) send(msg []byte, timeout time.Duration) error {
+	c.writeMutex.Lock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	err := writeFrame(c.conn, msg)
+	c.writeMutex.Unlock()
+	if err != nil {
+		// A partial frame leaves the stream unusable
+		c.conn.Close()
+	}
+	return err
+}
+
+// Send a request on the connection and wait for its response
+//
+// Arguments:
+//		msgID: message ID of the request
+//		reqMsgBytes: marshalled request message
+//		timeout: time to wait for the response
+// Returns:
+//		The marshalled response message, or an error if it didn't come
+func (c *nodeConn) roundTrip(msgID []byte, reqMsgBytes []byte, timeout time.Duration) ([]byte, error) {
+	waiting := make(chan []byte, 1)
+	c.pendingMutex.Lock()
+	c.pending[string(msgID)] = waiting
+	c.pendingMutex.Unlock()
+	defer func() {
+		c.pendingMutex.Lock()
+		delete(c.pending, string(msgID))
+		c.pendingMutex.Unlock()
+	}()
+
+	if err := c.send(reqMsgBytes, timeout); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-waiting:
+		return msg, nil
+	case <-c.closed:
+		return nil, errors.New("connection closed")
+	case <-timer.C:
+		return nil, errors.New("timeout")
+	}
+}
+
+// Send a request to another node over TCP and wait for its response.
+// The request is sent again with a doubled timeout, on a new connection
+// if the previous one broke, until it gets an answer or runs out of
+// attempts.
+//
+// Arguments:
+//		addr: address of the node
+//		msgID: message ID of the request
+//		reqMsgBytes: marshalled request message
+//		timeout: time to wait for the first response
+//		attempts: number of times the request is sent
+// Returns:
+//		The marshalled response message, or an error. errNoTCP if the
+//		node refused the first connection.
+func (n *Node) sendOverTCP(addr string, msgID []byte, reqMsgBytes []byte, timeout time.Duration, attempts int) ([]byte, error) {
+	var err error
+	for i := 0; i < attempts; i++ {
+		var c *nodeConn
+		if c, err = n.getNodeConn(addr, timeout); err != nil {
+			if i == 0 {
+				return nil, errNoTCP
+			}
+			timeout *= 2
+			continue
+		}
+
+		var msg []byte
+		if msg, err = c.roundTrip(msgID, reqMsgBytes, timeout); err == nil {
+			return msg, nil
+		}
+		timeout *= 2
+	}
+	return nil, fmt.Errorf("no response from %v after %d attempts: %v", addr, attempts, err)
+}
+
+// Send a message to another node without waiting for an answer. Like
+// sendRequestAndWait, it goes over TCP unless the node transport is UDP
+// or the node can't be reached over TCP.
+//
+// Arguments:
+//		addr: address of the node
+//		msg: marshalled message
+// Returns:
+//		An error if the message could not be sent
+func (n *Node) sendOneWay(addr string, msg []byte) error {
+	if n.config.NodeTransport == TRANSPORT_TCP {
+		c, err := n.getNodeConn(addr, proxyTimeout)
+		if err == nil {
+			if err = c.send(msg, proxyTimeout); err == nil {
+				return nil
+			}
+		}
+		// Fall back to UDP
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDP(msg, udpAddr)
+	return err
+}
+
+// Forward a client request to the node owning its key over TCP, and
+// relay the response. A client on TCP waits on its own connection, and
+// one on UDP gets the response from the address it contacted. Like over
+// UDP, the replicas of the owner are tried when it doesn't answer.
+//
+// Arguments:
+//		node: node owning the key
+//		reqPay: request payload
+//		msgID: message ID of the client request
+//		clientAddr: address of the client
+//		stream: connection of the client, nil over UDP
+func (n *Node) proxyRequestToCorrectNode(node NodeVal, reqPay *pb.KVRequest, msgID []byte, clientAddr *net.UDPAddr, stream responseStream) {
+	for i, target := range n.forwardTargets(node, reqPay.Command) {
+		fwdPay := n.forwardedRequest(reqPay, i > 0)
+		if fwdPay == nil {
+			break
+		}
+		reqMsgBytes, err := marshalRequestMsg(msgID, fwdPay)
+		if err != nil {
+			return
+		}
+
+		addr := target.ipAdr + ":" + target.port
+		respMsgBytes, err := n.sendOverTCP(addr, msgID, reqMsgBytes, proxyTimeout, proxyAttempts)
+		if err == errNoTCP && stream == nil {
+			// The node may only speak UDP, the client can be answered
+			// from there
+			n.forwardOverUDP(node, reqPay, msgID, clientAddr)
+			return
+		}
+		if err == nil {
+			n.writeToClient(clientAddr, stream, respMsgBytes)
+			return
+		}
+		log.Println("Could not forward to", addr, err)
+	}
+	n.sendUnreachable(clientAddr, stream, msgID)
+}
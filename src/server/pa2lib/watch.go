@@ -0,0 +1,121 @@
+package pa2lib
+
+import (
+	"fmt"
+	"log"
+	"net"
+	pb "pa2/pb/protobuf"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Time a WATCH_MEMBERSHIP stays active without being renewed
+const watchTTL = 30 * time.Second
+
+// A client receiving the membership events
+type membershipWatch struct {
+	addr         *net.UDPAddr
+	stream       responseStream
+	msgID        []byte
+	expires      time.Time
+	subscription int
+}
+
+// Get the key of the watch of a client: its address, or for a gateway
+// call its stream, since concurrent calls can share one connection
+func watchKey(clientAddr *net.UDPAddr, stream responseStream) string {
+	if local, ok := stream.(*localStream); ok {
+		return fmt.Sprintf("local#%d", local.id)
+	}
+	return clientAddr.String()
+}
+
+// Handle a WATCH_MEMBERSHIP sent by a client. Every membership event is
+// then pushed to the client, as a response carrying the message ID of
+// the request. The watch expires after watchTTL unless the client sends
+// WATCH_MEMBERSHIP again, the events then carry the new message ID.
+//
+// Arguments:
+//		clientAddr: address to push the events to
+//		stream: connection to push the events on, nil over UDP
+//		msgID: message ID of the request
+// Returns:
+//		The current membership list, and an error code
+func (n *Node) handleWatchMembership(clientAddr *net.UDPAddr, stream responseStream, msgID []byte) (map[string][]byte, uint32) {
+	key := watchKey(clientAddr, stream)
+
+	n.watchesMutex.Lock()
+	if watch, ok := n.watches[key]; ok {
+		watch.msgID = msgID
+		watch.stream = stream
+		watch.expires = time.Now().Add(watchTTL)
+	} else {
+		watch := &membershipWatch{addr: clientAddr, stream: stream, msgID: msgID, expires: time.Now().Add(watchTTL)}
+		watch.subscription = n.subscribeMembership(func(event MembershipEvent) {
+			n.pushMembershipEvent(key, event)
+		})
+		n.watches[key] = watch
+		log.Println("Membership watched by", key)
+	}
+	n.watchesMutex.Unlock()
+
+	nodeList, _, _ := n.GetMemberShipList()
+	return nodeList, NO_ERR
+}
+
+// Stop the watch of a client that went away
+//
+// Arguments:
+//		clientAddr, stream: same as handleWatchMembership
+func (n *Node) removeWatch(clientAddr *net.UDPAddr, stream responseStream) {
+	key := watchKey(clientAddr, stream)
+	n.watchesMutex.Lock()
+	watch, ok := n.watches[key]
+	delete(n.watches, key)
+	n.watchesMutex.Unlock()
+	if ok {
+		n.unsubscribeMembership(watch.subscription)
+		log.Println("Membership watch of", key, "ended")
+	}
+}
+
+// Push an event to a watching client, or drop the watch if it expired
+func (n *Node) pushMembershipEvent(key string, event MembershipEvent) {
+	n.watchesMutex.Lock()
+	watch, ok := n.watches[key]
+	if !ok {
+		n.watchesMutex.Unlock()
+		return
+	}
+	if time.Now().After(watch.expires) {
+		delete(n.watches, key)
+		n.watchesMutex.Unlock()
+		n.unsubscribeMembership(watch.subscription)
+		log.Println("Membership watch of", key, "expired")
+		return
+	}
+	addr, stream, msgID := watch.addr, watch.stream, watch.msgID
+	n.watchesMutex.Unlock()
+
+	respPay := &pb.KVResponse{
+		ErrCode: NO_ERR,
+		Event:   membershipEventToPb(event),
+	}
+	respPayBytes, err := proto.Marshal(respPay)
+	if err != nil {
+		return
+	}
+	respMsg := &pb.Msg{
+		MessageID: msgID,
+		Payload:   respPayBytes,
+		CheckSum:  getChecksum(msgID, respPayBytes),
+	}
+	respMsgBytes, err := proto.Marshal(respMsg)
+	if err != nil {
+		return
+	}
+
+	// Not cached, unlike a response, since it answers no request
+	n.writeToClient(addr, stream, respMsgBytes)
+}
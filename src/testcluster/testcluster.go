@@ -0,0 +1,252 @@
+// Package testcluster runs a cluster of nodes on loopback ports inside
+// one process, so tests can send requests to it, kill and restart nodes,
+// and wait for the membership to settle.
+package testcluster
+
+import (
+	"fmt"
+	"net"
+	"pa2/src/client"
+	pa2lib "pa2/src/server/pa2lib"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Time allowed for a cluster to converge by Start
+const convergenceTimeout = 10 * time.Second
+
+// Time between two checks of the convergence
+const convergencePoll = 20 * time.Millisecond
+
+// Returns settings suited to a test cluster: nodes advertise the
+// loopback address and gossip, probe and declare dead much faster than
+// by default, so changes spread in well under a second
+func Config() pa2lib.Config {
+	config := pa2lib.DefaultConfig()
+	config.AdvertiseIP = "127.0.0.1"
+	config.GossipIntervalMs = 100
+	config.ProbeIntervalMs = 100
+	config.ProbeTimeoutMs = 50
+	config.SuspectTimeoutMs = 500
+	return config
+}
+
+// A cluster of nodes running in this process. Nodes keep their port
+// when they are restarted.
+type Cluster struct {
+	config pa2lib.Config
+	ports  []int
+	// Running nodes, nil for a killed one
+	nodes []*pa2lib.Node
+	mutex sync.Mutex
+}
+
+// Start a cluster with the settings of Config and wait for it to
+// converge. The cluster is closed when the test ends.
+//
+// Arguments:
+//		t: test using the cluster
+//		size: number of nodes
+// Returns:
+//		The cluster
+func Start(t testing.TB, size int) *Cluster {
+	t.Helper()
+	c, err := New(size, Config())
+	if err != nil {
+		t.Fatalf("starting a cluster of %d nodes: %v", size, err)
+	}
+	t.Cleanup(c.Close)
+	if err := c.WaitForConvergence(convergenceTimeout); err != nil {
+		t.Fatalf("cluster of %d nodes did not converge: %v", size, err)
+	}
+	return c
+}
+
+// Start a cluster, one node after the other. Each node joins through
+// the ones already running.
+//
+// Arguments:
+//		size: number of nodes
+//		config: settings of every node, AdvertiseIP is set to the loopback
+//		address if empty
+// Returns:
+//		The cluster, or an error if a node could not start
+func New(size int, config pa2lib.Config) (*Cluster, error) {
+	if config.AdvertiseIP == "" {
+		config.AdvertiseIP = "127.0.0.1"
+	}
+	c := &Cluster{config: config}
+	for i := 0; i < size; i++ {
+		port, err := freePort()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.ports = append(c.ports, port)
+		c.nodes = append(c.nodes, nil)
+		if err := c.Restart(i); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Find a port free for both UDP and TCP on the loopback address
+func freePort() (int, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return 0, err
+		}
+		port := udp.LocalAddr().(*net.UDPAddr).Port
+		tcp, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		udp.Close()
+		if err == nil {
+			tcp.Close()
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no port free for both UDP and TCP")
+}
+
+// Get the number of nodes, running or not
+func (c *Cluster) Size() int {
+	return len(c.ports)
+}
+
+// Get the address ("ip:port") of a node
+func (c *Cluster) Addr(i int) string {
+	return fmt.Sprintf("%s:%d", c.config.AdvertiseIP, c.ports[i])
+}
+
+// Get the addresses of the running nodes
+func (c *Cluster) Addrs() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var addrs []string
+	for i, node := range c.nodes {
+		if node != nil {
+			addrs = append(addrs, c.Addr(i))
+		}
+	}
+	return addrs
+}
+
+// Get a node, nil if it is killed
+func (c *Cluster) Node(i int) *pa2lib.Node {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.nodes[i]
+}
+
+// Check whether a node is running
+func (c *Cluster) Running(i int) bool {
+	return c.Node(i) != nil
+}
+
+// Kill a node like a crash: it stops answering without telling the
+// others, and loses its keys
+func (c *Cluster) Kill(i int) {
+	c.mutex.Lock()
+	node := c.nodes[i]
+	c.nodes[i] = nil
+	c.mutex.Unlock()
+	if node != nil {
+		node.Stop()
+	}
+}
+
+// Start a node again on its port with empty state, like a restarted
+// process. It joins the cluster through the running nodes. Nothing is
+// done if the node is running.
+//
+// Returns:
+//		An error if the node could not start
+func (c *Cluster) Restart(i int) error {
+	if c.Running(i) {
+		return nil
+	}
+	serverList := append(c.Addrs(), c.Addr(i))
+	node := pa2lib.NewNode(c.ports[i], serverList, c.config)
+	if err := node.Start(); err != nil {
+		node.Stop()
+		return fmt.Errorf("starting node %s: %v", c.Addr(i), err)
+	}
+	c.mutex.Lock()
+	c.nodes[i] = node
+	c.mutex.Unlock()
+	return nil
+}
+
+// Stop every node
+func (c *Cluster) Close() {
+	for i := range c.ports {
+		c.Kill(i)
+	}
+}
+
+// Check whether the cluster converged: every running node sees every
+// running node as alive and every killed node it knows as dead or left,
+// and all of them have the same hash ring
+//
+// Returns:
+//		Nil once converged, otherwise why not
+func (c *Cluster) converged() error {
+	c.mutex.Lock()
+	nodes := append([]*pa2lib.Node{}, c.nodes...)
+	c.mutex.Unlock()
+
+	var epoch uint64
+	first := ""
+	for i, node := range nodes {
+		if node == nil {
+			continue
+		}
+		members := node.Members()
+		for j, other := range nodes {
+			membership, known := members[c.Addr(j)]
+			switch {
+			case other != nil && membership != pa2lib.MEMBERSHIP_MEMBER:
+				return fmt.Errorf("%s sees %s as %q", c.Addr(i), c.Addr(j), membership)
+			case other == nil && known && membership != pa2lib.MEMBERSHIP_DEAD && membership != pa2lib.MEMBERSHIP_LEFT:
+				return fmt.Errorf("%s sees killed node %s as %q", c.Addr(i), c.Addr(j), membership)
+			}
+		}
+
+		if first == "" {
+			epoch, first = node.RingEpoch(), c.Addr(i)
+		} else if node.RingEpoch() != epoch {
+			return fmt.Errorf("%s and %s have different rings", first, c.Addr(i))
+		}
+	}
+	return nil
+}
+
+// Wait until every running node agrees on the membership and the ring
+//
+// Arguments:
+//		timeout: longest time to wait
+// Returns:
+//		An error saying what still differs if the cluster did not converge
+//		in time
+func (c *Cluster) WaitForConvergence(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := c.converged()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(convergencePoll)
+	}
+}
+
+// Create a client of the cluster, seeded with the running nodes. The
+// caller closes it.
+func (c *Cluster) Client(opts client.Options) (*client.Client, error) {
+	return client.New(c.Addrs(), opts)
+}
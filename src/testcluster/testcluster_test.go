@@ -0,0 +1,113 @@
+package testcluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"pa2/src/client"
+	"testing"
+	"time"
+)
+
+// Number of keys written by the tests, enough for every node to own some
+const testKeys = 30
+
+// Put the test keys through a client, with values made of the prefix
+func putKeys(t *testing.T, c *client.Client, prefix string) {
+	t.Helper()
+	for i := 0; i < testKeys; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		value := []byte(fmt.Sprintf("%s-%d", prefix, i))
+		if err := c.Put(context.Background(), key, value, 0); err != nil {
+			t.Fatalf("putting %s: %v", key, err)
+		}
+	}
+}
+
+// Check that every test key has the value put with the prefix
+func checkKeys(t *testing.T, c *client.Client, prefix string) {
+	t.Helper()
+	for i := 0; i < testKeys; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		value, _, err := c.Get(context.Background(), key)
+		if err != nil {
+			t.Fatalf("getting %s: %v", key, err)
+		}
+		if want := []byte(fmt.Sprintf("%s-%d", prefix, i)); !bytes.Equal(value, want) {
+			t.Fatalf("getting %s: got %q, want %q", key, value, want)
+		}
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	c := Start(t, 3)
+	cl, err := c.Client(client.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	putKeys(t, cl, "value")
+	checkKeys(t, cl, "value")
+
+	if err := cl.Remove(context.Background(), []byte("key-0")); err != nil {
+		t.Fatalf("removing key-0: %v", err)
+	}
+	if _, _, err := cl.Get(context.Background(), []byte("key-0")); !client.IsNotFound(err) {
+		t.Fatalf("getting removed key-0: got %v, want not found", err)
+	}
+}
+
+func TestKillRestart(t *testing.T) {
+	c := Start(t, 3)
+	cl, err := c.Client(client.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	putKeys(t, cl, "before")
+
+	// The keys of the killed node go to the next nodes of the ring, which
+	// had them as replicas
+	c.Kill(1)
+	if err := c.WaitForConvergence(convergenceTimeout); err != nil {
+		t.Fatalf("cluster did not converge after a kill: %v", err)
+	}
+	if err := cl.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, cl, "before")
+	putKeys(t, cl, "during")
+	checkKeys(t, cl, "during")
+
+	// The restarted node joins again and its keys are moved back to it
+	if err := c.Restart(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitForConvergence(convergenceTimeout); err != nil {
+		t.Fatalf("cluster did not converge after a restart: %v", err)
+	}
+	if err := cl.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, cl, "during")
+}
+
+func TestKillWaitsForStop(t *testing.T) {
+	c := Start(t, 1)
+	done := make(chan struct{})
+	go func() {
+		c.Kill(0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the node did not stop")
+	}
+
+	// Its ports are free again once it stopped
+	if err := c.Restart(0); err != nil {
+		t.Fatal(err)
+	}
+}